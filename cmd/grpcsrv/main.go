@@ -1,19 +1,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/docs/grpcsrv"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/database"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/gateway"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
 	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
 	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
-	"io"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"net/http"
 	"os"
 )
 
@@ -25,8 +40,17 @@ var configs *user.AppConfig
 var v *viper.Viper
 var _log *logrus.Logger
 var dbHandler postgres.DBHandler
-var userRepo repo.GrpcUserRepository
-var userEventHandler handler.UserEventHandler
+var userRepo domain.UserRepository
+var userInvalidator domain.UserInvalidator
+var userSvc domain.UserService
+var eventRepo repo.EventRepository
+var deadLetterRepo repo.DeadLetterRepository
+var aggregateRepo repo.UserAggregateRepository
+var auditRepo audit.Repository
+var eventBroker broker.EventBroker
+var eventRecorder handler.EventRecorder
+var eventStreamHandler handler.EventStreamHandler
+var userEventSubscriber handler.UserEventSubscriber
 
 func init() {
 
@@ -46,41 +70,63 @@ func init() {
 		return
 	}
 
-	//Postresql connection
-	dbHandler = postgres.NewDBHandler(&configs.Postgresql, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
-	_db := dbHandler.New()
+	//Field-level encryption keys, fields must not be written in plaintext when the keys can't be read
+	if err := fieldcrypt.Setup(configs.Encryption.KEY_FILE); err != nil {
+		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Fatalf("An error occured when reading the encryption keys. %v", err)
+	}
+
+	//Database connection of the configured storage backend
+	dbHandler, err = storage.NewDBHandler(&configs.Storage, &configs.Postgresql, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Fatalf("An error occured when selecting the storage backend. %v", err)
+	}
+	_db, err := dbHandler.New()
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Fatalf("An error occured when connecting to the database. %v", err)
+	}
 	database.DB = _db
 	util.MigrateDB(_db, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
-	userRepo = repo.NewGrpcUserRepo(database.DB, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+
+	//Reads of the users are routed to the replicas
+	database.Reads, err = storage.NewReadRouter(&configs.Storage, &configs.Postgresql, _db, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Fatalf("An error occured when connecting to the replicas. %v", err)
+	}
+	userRepo = domain.NewBackendUserRepo(storage.Backend(&configs.Storage), database.DB, database.Reads, repo.EventStoreScrub(_log.WithFields(logrus.Fields{"service": "grpc_event_server"})), _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	//Users are read through the cache, the events are applied here so that every write evicts them
+	userCache, err := cache.New(&configs.Cache)
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Fatalf("An error occured when selecting the cache backend. %v", err)
+	}
+	if userCache != nil {
+		cachedRepo := domain.NewCachedUserRepo(userRepo, userCache, configs.Cache.TTL, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+		userInvalidator = cachedRepo
+		userRepo = cachedRepo
+	}
+	userSvc = domain.NewUserService(userRepo, nil, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	eventRepo = repo.NewEventRepo(database.DB, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	deadLetterRepo = repo.NewDeadLetterRepo(database.DB, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	snapshotRepo := repo.NewSnapshotRepo(database.DB, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	aggregateRepo = repo.NewUserAggregateRepo(eventRepo, snapshotRepo, configs.Grpc.SNAPSHOT_INTERVAL, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	eventBroker = broker.NewEventBroker()
+	auditRepo = audit.NewRepository(database.DB, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	eventRecorder = handler.NewEventRecorder(eventRepo, eventBroker, aggregateRepo, auditRepo, _log)
+	eventStreamHandler = handler.NewEventStreamHandler(userSvc, eventRecorder, deadLetterRepo, configs.Grpc.WORKERS, _log)
+	userEventSubscriber = handler.NewSubscriptionHandler(eventRepo, eventBroker, _log)
 }
 
+// HandleEvent consumes the events of the stream
+// Each stream is served by its own handler, see Grpc.WORKERS for concurrent processing
 func (s server) HandleEvent(eventServer pb.EventGrpcService_HandleEventServer) error {
 	_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Infoln("Event consuming operation is starting ...")
-	for {
-		event, err := eventServer.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Errorf("An error occured %s", err.Error())
-		}
-
-		if event != nil {
-			switch event.AggregateType {
-			case pb.AggregateType_USER:
-				userEventHandler = handler.NewUserEventHandler(userRepo, event, eventServer, _log)
-				err = userEventHandler.Handle()
-				if err != nil {
-					_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Errorf("An error occured %s", err.Error())
-				}
-			}
-		} else {
-			return err
-		}
-
-	}
+	return eventStreamHandler.Serve(eventServer)
+}
 
-	return nil
+// SubscribeUserEvents streams the user events to the consumer
+// Consumers can resume from the last sequence they have seen after reconnecting
+func (s server) SubscribeUserEvents(req *pb.SubscribeRequest, stream pb.EventGrpcService_SubscribeUserEventsServer) error {
+	_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Infof("Subscription is starting from sequence %d ...", req.FromSequence)
+	return userEventSubscriber.Subscribe(req, stream)
 }
 
 func main() {
@@ -104,6 +150,52 @@ func main() {
 	// gRPC implementation
 	s := grpc.NewServer()
 	pb.RegisterEventGrpcServiceServer(s, &server{})
+	userServer := handler.NewUserGrpcServer(userSvc, eventRecorder, _log)
+	pbuser.RegisterUserServiceServer(s, userServer)
+
+	// Services are reported as NOT_SERVING while Postgres is unreachable
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	healthWatcher := handler.NewDatabaseHealthWatcher(database.DB, healthServer, configs.Grpc.HEALTH_INTERVAL, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+	go healthWatcher.Watch(context.Background())
+	go database.Reads.Watch(context.Background())
+
+	// Deleted users are removed permanently after the retention period
+	if configs.Grpc.TRASH_RETENTION_DAYS > 0 {
+		purger := handler.NewTrashPurger(userRepo, auditRepo, configs.Grpc.TRASH_RETENTION_DAYS, configs.Grpc.PURGE_INTERVAL, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+		go purger.Run(context.Background())
+	}
+
+	// Users are written by user service as well, their events are followed from the event store so that they are evicted from the cache
+	if userInvalidator != nil {
+		from, err := eventRepo.LastSequence()
+		util.FailOnError(err, "last event could not be read.")
+
+		selfConn, err := grpc.Dial(fmt.Sprintf("localhost:%s", configs.Grpc.PORT), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		util.FailOnError(err, "event subscription failed.")
+		defer selfConn.Close()
+
+		listener := eventclient.NewInvalidationListener(pb.NewEventGrpcServiceClient(selfConn), userInvalidator, _log.WithFields(logrus.Fields{"service": "grpc_event_server"}))
+		go listener.Run(context.Background(), from)
+	}
+
+	if configs.Grpc.REFLECTION {
+		reflection.Register(s)
+	}
+
+	// REST gateway is generated from the HTTP annotations of UserService
+	if configs.Grpc.GATEWAY_PORT != "" {
+		gwHandler, err := gateway.NewGatewayHandler(context.Background(), userServer, grpcsrv.SwaggerJSON)
+		util.FailOnError(err, "gateway registration failed.")
+
+		go func() {
+			_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Printf("gateway listening at :%s", configs.Grpc.GATEWAY_PORT)
+			if err := http.ListenAndServe(fmt.Sprintf(":%s", configs.Grpc.GATEWAY_PORT), gwHandler); err != nil {
+				_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Errorf("failed to serve gateway: %v", err)
+			}
+		}()
+	}
+
 	_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Printf("server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		_log.WithFields(logrus.Fields{"service": "grpc_event_server"}).Errorf("failed to serve: %v", err)
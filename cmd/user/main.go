@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
 	_ "github.com/cemayan/faceit-technical-test/docs/user"
 	"github.com/cemayan/faceit-technical-test/internal/user/database"
 	"github.com/cemayan/faceit-technical-test/internal/user/router"
 	"github.com/cemayan/faceit-technical-test/internal/user/util"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	grpcrepo "github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
 	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/writer"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"os"
 )
 
@@ -38,11 +48,28 @@ func init() {
 		return
 	}
 
-	//Postresql connection
-	dbHandler = postgres.NewDBHandler(&configs.Postgresql, _log.WithFields(logrus.Fields{"service": "user"}))
-	_db := dbHandler.New()
+	//Field-level encryption keys, fields must not be written in plaintext when the keys can't be read
+	if err := fieldcrypt.Setup(configs.Encryption.KEY_FILE); err != nil {
+		_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when reading the encryption keys. %v", err)
+	}
+
+	//Database connection of the configured storage backend
+	dbHandler, err = storage.NewDBHandler(&configs.Storage, &configs.Postgresql, _log.WithFields(logrus.Fields{"service": "user"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when selecting the storage backend. %v", err)
+	}
+	_db, err := dbHandler.New()
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when connecting to the database. %v", err)
+	}
 	database.DB = _db
 	util.MigrateDB(_db, _log.WithFields(logrus.Fields{"service": "user"}))
+
+	//Reads of the users are routed to the replicas
+	database.Reads, err = storage.NewReadRouter(&configs.Storage, &configs.Postgresql, _db, _log.WithFields(logrus.Fields{"service": "user"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when connecting to the replicas. %v", err)
+	}
 }
 
 // @title        Faceit
@@ -76,9 +103,35 @@ func main() {
 			logrus.DebugLevel,
 		},
 	})
-	router.SetupRoutes(app, _log.WithFields(logrus.Fields{"service": "user"}), configs)
+	userCache, err := cache.New(&configs.Cache)
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when selecting the cache backend. %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go database.Reads.Watch(ctx)
+
+	changes := router.SetupRoutes(app, _log.WithFields(logrus.Fields{"service": "user"}), userCache, configs)
+
+	// Users are changed by gRPC event server and usrgrpc as well, their events evict them from the cache and send their reads to the primary
+	if changes != nil && (userCache != nil || len(configs.Postgresql.REPLICAS) > 0) {
+		from, err := grpcrepo.NewEventRepo(database.DB, _log.WithFields(logrus.Fields{"service": "user"})).LastSequence()
+		if err != nil {
+			_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when reading the last event. %v", err)
+		}
+
+		grpcConn, err := grpc.Dial(fmt.Sprintf("%s:%s", configs.Grpc.ADDR, configs.Grpc.PORT), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			_log.WithFields(logrus.Fields{"service": "user"}).Fatalf("An error occured when connecting to gRPC event server. %v", err)
+		}
+		defer grpcConn.Close()
+
+		listener := eventclient.NewInvalidationListener(pb.NewEventGrpcServiceClient(grpcConn), changes, _log.WithFields(logrus.Fields{"service": "user"}))
+		go listener.Run(ctx, from)
+	}
 
-	err := app.Listen(":8089")
+	err = app.Listen(":8089")
 	if err != nil {
 		return
 	}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/database"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/export"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/migrations"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	"github.com/cemayan/faceit-technical-test/pkg/migrate"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usrctl is the maintenance tool of gRPC event server
+
+Usage:
+  usrctl snapshots rebuild [-user <id>]   replays the events and replaces the snapshots of one or all users
+  usrctl snapshots compact                removes all snapshots except the latest one of each user
+  usrctl export -user <id> [-out <file>]  writes the data export archive of the user, user-<id>.zip by default
+  usrctl keys rotate [-batch <n>]         encrypts the users again whose fields are not encrypted with the current key
+  usrctl migrate up [-steps <n>]          applies the pending migrations, all of them by default
+  usrctl migrate down [-steps <n>]        reverts the latest migrations, one by default
+  usrctl migrate status                   lists the migrations and when they were applied
+  usrctl migrate create <name> [-dir d]   writes the up and down files of a new migration to migrations by default
+`
+
+var _log *logrus.Logger
+var configs *user.AppConfig
+var v *viper.Viper
+var dbHandler postgres.DBHandler
+var eventRepo repo.EventRepository
+var snapshotRepo repo.SnapshotRepository
+var aggregateRepo repo.UserAggregateRepository
+var userRepo domain.UserRepository
+var auditRepo audit.Repository
+
+func initLogger() {
+	//logrus init
+	_log = logrus.New()
+	_log.Out = os.Stderr
+	_log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+}
+
+// connect connects to the database without migrating it
+func connect() {
+	initLogger()
+
+	v = viper.New()
+	_configs := user.NewConfig(v)
+
+	env := os.Getenv("ENV")
+	appConfig, err := _configs.GetConfig(env)
+	configs = appConfig
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "usrctl"}).Fatalf("An error occured when getting config. %v", err)
+	}
+
+	//Field-level encryption keys, fields must not be written in plaintext when the keys can't be read
+	if err := fieldcrypt.Setup(configs.Encryption.KEY_FILE); err != nil {
+		_log.WithFields(logrus.Fields{"service": "usrctl"}).Fatalf("An error occured when reading the encryption keys. %v", err)
+	}
+
+	//Database connection of the configured storage backend
+	dbHandler, err = storage.NewDBHandler(&configs.Storage, &configs.Postgresql, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "usrctl"}).Fatalf("An error occured when selecting the storage backend. %v", err)
+	}
+	database.DB, err = dbHandler.New()
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "usrctl"}).Fatalf("An error occured when connecting to the database. %v", err)
+	}
+}
+
+func setup() {
+	connect()
+	util.MigrateDB(database.DB, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	eventRepo = repo.NewEventRepo(database.DB, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	snapshotRepo = repo.NewSnapshotRepo(database.DB, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	aggregateRepo = repo.NewUserAggregateRepo(eventRepo, snapshotRepo, configs.Grpc.SNAPSHOT_INTERVAL, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	userRepo = domain.NewBackendUserRepo(storage.Backend(&configs.Storage), database.DB, nil, repo.EventStoreScrub(_log.WithFields(logrus.Fields{"service": "usrctl"})), _log.WithFields(logrus.Fields{"service": "usrctl"}))
+	auditRepo = audit.NewRepository(database.DB, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+}
+
+// rebuildSnapshots replays the events of given user, or of all users when userID is empty
+func rebuildSnapshots(userID string) error {
+	userIDs := []string{userID}
+	if userID == "" {
+		ids, err := eventRepo.GetUserIDs()
+		if err != nil {
+			return err
+		}
+		userIDs = ids
+	}
+
+	for _, id := range userIDs {
+		state, err := aggregateRepo.Rebuild(id)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", id, err)
+		}
+		_log.WithFields(logrus.Fields{"method": "rebuildSnapshots"}).Infof("Snapshot of %s is rebuilt at sequence %d (%d events)", id, state.Sequence, state.Version)
+	}
+
+	_log.WithFields(logrus.Fields{"method": "rebuildSnapshots"}).Infof("%d snapshots are rebuilt", len(userIDs))
+	return nil
+}
+
+func compactSnapshots() error {
+	removed, err := snapshotRepo.Compact()
+	if err != nil {
+		return err
+	}
+
+	_log.WithFields(logrus.Fields{"method": "compactSnapshots"}).Infof("%d snapshots are removed", removed)
+	return nil
+}
+
+func snapshots(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("snapshots needs a command")
+	}
+
+	switch args[0] {
+	case "rebuild":
+		flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+		userID := flags.String("user", "", "id of the user, all users are rebuilt when it is empty")
+		_ = flags.Parse(args[1:])
+
+		setup()
+		return rebuildSnapshots(*userID)
+	case "compact":
+		setup()
+		return compactSnapshots()
+	}
+
+	return fmt.Errorf("unknown snapshots command %q", args[0])
+}
+
+// exportUser writes the data export archive of given user to the file
+func exportUser(userID string, out string) error {
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	builder := export.NewArchiveBuilder(userRepo, eventRepo, auditRepo)
+	if err := builder.Build(file, userID); err != nil {
+		_ = file.Close()
+		_ = os.Remove(out)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	_log.WithFields(logrus.Fields{"method": "exportUser"}).Infof("Data of %s is exported to %s", userID, out)
+	return nil
+}
+
+func exportCmd(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	userID := flags.String("user", "", "id of the user")
+	out := flags.String("out", "", "archive file, user-<id>.zip when it is empty")
+	_ = flags.Parse(args)
+
+	if *userID == "" {
+		return fmt.Errorf("export needs -user")
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("user-%s.zip", *userID)
+	}
+
+	setup()
+	return exportUser(*userID, *out)
+}
+
+// rotateKeys encrypts the users with the current key in batches
+func rotateKeys(batch int) error {
+	total := 0
+	for {
+		encrypted, err := userRepo.ReencryptUsers(batch)
+		total += encrypted
+		if err != nil {
+			return err
+		}
+		if encrypted < batch {
+			break
+		}
+		_log.WithFields(logrus.Fields{"method": "rotateKeys"}).Infof("%d users are encrypted", total)
+	}
+
+	_log.WithFields(logrus.Fields{"method": "rotateKeys"}).Infof("%d users are encrypted with key %q", total, fieldcrypt.CurrentKeyID())
+	return nil
+}
+
+func keys(args []string) error {
+	if len(args) == 0 || args[0] != "rotate" {
+		return fmt.Errorf("keys needs the rotate command")
+	}
+
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "number of users which are encrypted at once")
+	_ = flags.Parse(args[1:])
+	if *batch <= 0 {
+		return fmt.Errorf("batch must be positive")
+	}
+
+	setup()
+	return rotateKeys(*batch)
+}
+
+func migrateCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs a command")
+	}
+
+	switch args[0] {
+	case "up", "down":
+		defaultSteps := 0
+		if args[0] == "down" {
+			defaultSteps = 1
+		}
+		flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+		steps := flags.Int("steps", defaultSteps, "number of migrations, 0 is all of them")
+		_ = flags.Parse(args[1:])
+		if *steps < 0 {
+			return fmt.Errorf("steps must not be negative")
+		}
+
+		connect()
+		migrator := migrate.New(database.DB, migrations.FS, _log.WithFields(logrus.Fields{"service": "usrctl"}))
+		if args[0] == "up" {
+			applied, err := migrator.Up(*steps)
+			if err != nil {
+				return err
+			}
+			_log.WithFields(logrus.Fields{"method": "migrateCmd"}).Infof("%d migrations are applied", len(applied))
+			return nil
+		}
+
+		reverted, err := migrator.Down(*steps)
+		if err != nil {
+			return err
+		}
+		_log.WithFields(logrus.Fields{"method": "migrateCmd"}).Infof("%d migrations are reverted", len(reverted))
+		return nil
+	case "status":
+		connect()
+		statuses, err := migrate.New(database.DB, migrations.FS, _log.WithFields(logrus.Fields{"service": "usrctl"})).Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%-30s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ExitOnError)
+		dir := flags.String("dir", "migrations", "directory of the migrations")
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("create needs a name")
+		}
+		_ = flags.Parse(args[2:])
+
+		initLogger()
+		paths, err := migrate.Create(*dir, args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			_log.WithFields(logrus.Fields{"method": "migrateCmd"}).Infof("%s is created", path)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "snapshots":
+		err = snapshots(os.Args[2:])
+	case "export":
+		err = exportCmd(os.Args[2:])
+	case "keys":
+		err = keys(os.Args[2:])
+	case "migrate":
+		err = migrateCmd(os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usrctl: %v\n\n%s", err, usage)
+		os.Exit(1)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
 	_ "github.com/cemayan/faceit-technical-test/docs/usrgrpc"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/database"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/router"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
 	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
 	pb "github.com/cemayan/faceit-technical-test/protos/event"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,7 +21,10 @@ import (
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var _log *logrus.Logger
@@ -49,11 +58,28 @@ func init() {
 
 	_log.Infoln("gRPC connection is starting...")
 
-	//Postresql connection
-	dbHandler = postgres.NewDBHandler(&configs.Postgresql, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
-	_db := dbHandler.New()
+	//Field-level encryption keys, fields must not be written in plaintext when the keys can't be read
+	if err := fieldcrypt.Setup(configs.Encryption.KEY_FILE); err != nil {
+		_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when reading the encryption keys. %v", err)
+	}
+
+	//Database connection of the configured storage backend
+	dbHandler, err = storage.NewDBHandler(&configs.Storage, &configs.Postgresql, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when selecting the storage backend. %v", err)
+	}
+	_db, err := dbHandler.New()
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when connecting to the database. %v", err)
+	}
 	database.DB = _db
 	util.MigrateDB(_db, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
+
+	//Reads of the users are routed to the replicas
+	database.Reads, err = storage.NewReadRouter(&configs.Storage, &configs.Postgresql, _db, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when connecting to the replicas. %v", err)
+	}
 }
 
 // @title        Faceit
@@ -66,6 +92,9 @@ func init() {
 func main() {
 
 	grpcClient := pb.NewEventGrpcServiceClient(grpcConn)
+	streams := eventclient.NewStreamPool(grpcClient, configs.Grpc.STREAMS, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
+	breaker := eventclient.NewBreaker(configs.Grpc.BREAKER_FAILURES, configs.Grpc.BREAKER_COOLDOWN)
+	events := eventclient.NewEventClient(streams, breaker, configs.Grpc.RETRIES, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
@@ -83,11 +112,45 @@ func main() {
 		_log.SetOutput(os.Stdout)
 	}
 
-	router.SetupGrpcRoutes(app, _log.WithFields(logrus.Fields{"service": "user_grpc"}), grpcClient, configs)
+	userCache, err := cache.New(&configs.Cache)
+	if err != nil {
+		_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when selecting the cache backend. %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go database.Reads.Watch(ctx)
+
+	changes := router.SetupGrpcRoutes(app, _log.WithFields(logrus.Fields{"service": "user_grpc"}), events, eventclient.NewHealthChecker(healthpb.NewHealthClient(grpcConn)), userCache, configs)
+
+	// Users are changed by gRPC event server, its events evict them from the cache and send their reads to the primary
+	if changes != nil && (userCache != nil || len(configs.Postgresql.REPLICAS) > 0) {
+		from, err := repo.NewEventRepo(database.DB, _log.WithFields(logrus.Fields{"service": "user_grpc"})).LastSequence()
+		if err != nil {
+			_log.WithFields(logrus.Fields{"service": "user_grpc"}).Fatalf("An error occured when reading the last event. %v", err)
+		}
+		listener := eventclient.NewInvalidationListener(grpcClient, changes, _log.WithFields(logrus.Fields{"service": "user_grpc"}))
+		go listener.Run(ctx, from)
+	}
 
-	err := app.Listen(":8092")
+	// Event streams are closed after the in-flight requests are finished
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+
+		_log.Infoln("Shutting down ...")
+		if err := app.Shutdown(); err != nil {
+			_log.Errorf("An error occured when shutting down %v", err)
+		}
+	}()
+
+	err = app.Listen(":8092")
 	if err != nil {
-		return
+		_log.Errorf("An error occured when listening %v", err)
 	}
 
+	_ = events.Close()
+	_ = grpcConn.Close()
+
 }
@@ -21,6 +21,9 @@ type Config struct {
 type AppConfig struct {
 	Postgresql common.Postgresql
 	Grpc       common.Grpc
+	Encryption common.Encryption
+	Storage    common.Storage
+	Cache      common.Cache
 }
 
 // LoadConfig file from given path
@@ -0,0 +1,8 @@
+// Package grpcsrv contains the OpenAPI document of gRPCServer REST gateway
+// swagger.json is generated from protos/user/user.proto by protoc-gen-openapiv2
+package grpcsrv
+
+import _ "embed"
+
+//go:embed swagger.json
+var SwaggerJSON []byte
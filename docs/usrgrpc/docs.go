@@ -121,7 +121,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/dto.UpdateUser"
+                            "$ref": "#/definitions/domain.UpdateUser"
                         }
                     }
                 ],
@@ -146,7 +146,7 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "dto.UpdateUser": {
+        "domain.UpdateUser": {
             "type": "object",
             "properties": {
                 "country": {
@@ -0,0 +1,231 @@
+package domain
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_hits_total",
+		Help: "Number of the user lookups which are served from the cache",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_misses_total",
+		Help: "Number of the user lookups which are read from the repository",
+	})
+	cacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_invalidations_total",
+		Help: "Number of the users which are evicted from the cache after they are changed",
+	})
+	cacheErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_errors_total",
+		Help: "Number of the failed cache calls, lookups fall back to the repository",
+	})
+)
+
+// UserInvalidator evicts the users from the cache after they are changed
+type UserInvalidator interface {
+	Invalidate(ids ...string)
+}
+
+// CachedUserRepository is a UserRepository which serves the user lookups from a cache
+type CachedUserRepository interface {
+	UserRepository
+	UserInvalidator
+}
+
+// A CachedUserrepo reads the users through the cache and evicts them on every write
+// Only the users which are found are cached, lists and the trash are always read from the repository
+// Writes of the other processes are not seen until the entry expires unless they are given to Invalidate
+// A user which is read while a user is invalidated is not cached, it may be older than the write which invalidated it
+type CachedUserrepo struct {
+	cached        UserInvalidator
+	users         UserRepository
+	cache         cache.Cache
+	ttl           time.Duration
+	invalidations *atomic.Uint64
+	log           *log.Entry
+}
+
+// A CacheInvalidator evicts the users from the cache
+type CacheInvalidator struct {
+	cache cache.Cache
+	log   *log.Entry
+}
+
+// UserCacheKey returns the cache key of the user
+func UserCacheKey(id string) string {
+	return "user:" + id
+}
+
+// Invalidate evicts the users from the cache, failures are logged since the entries expire anyway
+func (i CacheInvalidator) Invalidate(ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, UserCacheKey(id))
+	}
+
+	if err := i.cache.Delete(keys...); err != nil {
+		cacheErrors.Inc()
+		i.log.WithFields(log.Fields{"method": "Invalidate"}).Errorf("An error occurred when evicting the users %s", err.Error())
+		return
+	}
+	cacheInvalidations.Add(float64(len(ids)))
+}
+
+// Invalidate evicts the users from the cache and passes them to the repository when it routes their reads as well
+func (r CachedUserrepo) Invalidate(ids ...string) {
+	if users, ok := r.users.(UserInvalidator); ok {
+		users.Invalidate(ids...)
+	}
+	r.invalidate(ids...)
+}
+
+// invalidate counts the invalidation before the users are evicted, so that the reads which are in flight don't cache them again
+func (r CachedUserrepo) invalidate(ids ...string) {
+	r.invalidations.Add(1)
+	r.cached.Invalidate(ids...)
+}
+
+// GetUserByID returns the cached user, the user is read from the repository and cached on a miss
+func (r CachedUserrepo) GetUserByID(id string) (*User, error) {
+	key := UserCacheKey(id)
+
+	value, ok, err := r.cache.Get(key)
+	if err != nil {
+		cacheErrors.Inc()
+		r.log.WithFields(log.Fields{"method": "GetUserByID"}).Errorf("An error occurred when reading the cache %s", err.Error())
+	}
+	if ok {
+		var user User
+		if err := json.Unmarshal(value, &user); err == nil {
+			cacheHits.Inc()
+			return &user, nil
+		}
+		r.cached.Invalidate(id)
+	}
+
+	cacheMisses.Inc()
+	generation := r.invalidations.Load()
+	user, err := r.users.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.invalidations.Load() != generation {
+		return user, nil
+	}
+
+	value, err = json.Marshal(user)
+	if err == nil {
+		err = r.cache.Set(key, value, r.ttl)
+	}
+	if err != nil {
+		cacheErrors.Inc()
+		r.log.WithFields(log.Fields{"method": "GetUserByID"}).Errorf("An error occurred when caching the user %s", err.Error())
+	}
+
+	// a write which is finished while the user is cached may have been evicted before it
+	if r.invalidations.Load() != generation {
+		r.cached.Invalidate(id)
+	}
+	return user, nil
+}
+
+func (r CachedUserrepo) GetAllUser(pagination common.Pagination) (*common.Pagination, error) {
+	return r.users.GetAllUser(pagination)
+}
+
+func (r CachedUserrepo) CreateUser(user *User) (*User, error) {
+	return r.users.CreateUser(user)
+}
+
+func (r CachedUserrepo) UpdateUser(id string, update *UpdateUser) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.UpdateUser(id, update)
+}
+
+func (r CachedUserrepo) DeleteUser(id string) error {
+	defer r.invalidate(id)
+	return r.users.DeleteUser(id)
+}
+
+func (r CachedUserrepo) ChangePassword(id string, hash string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.ChangePassword(id, hash)
+}
+
+func (r CachedUserrepo) ChangeEmail(id string, email string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.ChangeEmail(id, email)
+}
+
+func (r CachedUserrepo) SuspendUser(id string, reason string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.SuspendUser(id, reason)
+}
+
+func (r CachedUserrepo) ReactivateUser(id string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.ReactivateUser(id)
+}
+
+func (r CachedUserrepo) RestoreUser(id string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.RestoreUser(id)
+}
+
+func (r CachedUserrepo) ChangeRole(id string, role string) (*User, error) {
+	defer r.invalidate(id)
+	return r.users.ChangeRole(id, role)
+}
+
+func (r CachedUserrepo) GetDeletedUsers(pagination common.Pagination) (*common.Pagination, error) {
+	return r.users.GetDeletedUsers(pagination)
+}
+
+func (r CachedUserrepo) PurgeDeletedUsers(before time.Time, limit int) ([]string, error) {
+	ids, err := r.users.PurgeDeletedUsers(before, limit)
+	r.invalidate(ids...)
+	return ids, err
+}
+
+func (r CachedUserrepo) EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error) {
+	defer r.invalidate(id)
+	return r.users.EraseUser(id, receipt)
+}
+
+// ReencryptUsers doesn't change the values of the users, so the cache is kept
+func (r CachedUserrepo) ReencryptUsers(limit int) (int, error) {
+	return r.users.ReencryptUsers(limit)
+}
+
+// NewUserInvalidator returns the invalidator of the users which are cached in cache
+// Processes which don't write the users themselves use it to follow the changes of the others
+func NewUserInvalidator(cache cache.Cache, log *log.Entry) UserInvalidator {
+	return CacheInvalidator{cache: cache, log: log}
+}
+
+// NewCachedUserRepo returns users which are read through cache, the cached users expire after ttl
+// The users are evicted after every write, so the writes must go through it in this process
+func NewCachedUserRepo(users UserRepository, cache cache.Cache, ttl time.Duration, log *log.Entry) CachedUserRepository {
+	return &CachedUserrepo{
+		cached:        NewUserInvalidator(cache, log),
+		users:         users,
+		cache:         cache,
+		ttl:           ttl,
+		invalidations: &atomic.Uint64{},
+		log:           log,
+	}
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	"regexp"
+)
+
+// encryptedColumn matches the encrypted columns of the users table in a condition query
+var encryptedColumn = regexp.MustCompile(`(?i)\b(email|first_name|last_name)\b`)
+
+// emailEquality is the only condition query on an encrypted column which can be answered, by the blind index of the email
+var emailEquality = regexp.MustCompile(`(?i)^\s*email\s*=\s*\?\s*$`)
+
+// lookupFilters rewrites the filters of the pagination, so that they don't compare the encrypted columns
+// Email is only matched exactly by its blind index, names can't be filtered and none of them can be sorted
+func lookupFilters(pagination common.Pagination) (common.Pagination, error) {
+	if pagination.SColumn == common.Email {
+		return pagination, unsupportedFilter("sColumn", "email can not be sorted, it is encrypted")
+	}
+
+	if len(pagination.Conditions) > 0 {
+		conditions := make(map[string]interface{}, len(pagination.Conditions))
+		for column, value := range pagination.Conditions {
+			switch column {
+			case "email":
+				conditions["email_index"] = fieldcrypt.BlindIndex(fmt.Sprint(value))
+			case "first_name", "last_name":
+				return pagination, unsupportedFilter(column, "can not be filtered, it is encrypted")
+			default:
+				conditions[column] = value
+			}
+		}
+		pagination.Conditions = conditions
+	}
+
+	if pagination.CQuery != "" && encryptedColumn.MatchString(pagination.CQuery) {
+		if !emailEquality.MatchString(pagination.CQuery) {
+			return pagination, unsupportedFilter("cQuery", "email can only be matched exactly, names can not be filtered")
+		}
+		pagination.CQuery = "email_index = ?"
+		pagination.CValue = fieldcrypt.BlindIndex(pagination.CValue)
+	}
+	return pagination, nil
+}
+
+func unsupportedFilter(field string, description string) error {
+	return &apperror.Error{
+		Kind:       apperror.InvalidArgument,
+		Reason:     apperror.ReasonInvalidArgument,
+		Message:    field + " " + description,
+		Violations: []apperror.FieldViolation{{Field: field, Description: description}},
+	}
+}
@@ -0,0 +1,446 @@
+package domain
+
+import (
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	"github.com/cemayan/faceit-technical-test/pkg/memstore"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryUserrepo keeps the users in memory, it is used by the memory storage backend and the tests
+// Unique indexes of the users table are checked among the users which are not deleted
+// The personal data of an erased user is scrubbed from db as well when it is given
+type MemoryUserrepo struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]*User
+	order []uuid.UUID
+	db    *gorm.DB
+	scrub ScrubFunc
+	log   *log.Entry
+}
+
+// userColumns returns the column values of the user which are used by the filters and the sorting
+func userColumns(user User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             user.ID.String(),
+		"created_at":     user.CreatedAt,
+		"updated_at":     user.UpdatedAt,
+		"nick_name":      user.NickName,
+		"email":          user.Email,
+		"email_index":    user.EmailIndex,
+		"first_name":     user.FirstName,
+		"last_name":      user.LastName,
+		"country":        user.Country,
+		"suspended":      user.Suspended,
+		"suspend_reason": user.SuspendReason,
+		"role":           user.Role,
+		"key_id":         user.KeyID,
+	}
+}
+
+// clone returns a copy of the user which doesn't share its Base
+func clone(user *User) User {
+	c := *user
+	base := *user.Base
+	c.Base = &base
+	return c
+}
+
+func (r *MemoryUserrepo) GetAllUser(pagination common.Pagination) (*common.Pagination, error) {
+	if len(pagination.Conditions) == 0 && (pagination.CQuery == "") != (pagination.CValue == "") {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users, err := memstore.Page(r.active(), &pagination, userColumns)
+	pagination.Rows = users
+	return &pagination, err
+}
+
+// UpdateUser applies the update to an active user and returns it
+func (r *MemoryUserrepo) UpdateUser(id string, update *UpdateUser) (*User, error) {
+	return r.change(id, update.apply)
+}
+
+func (r *MemoryUserrepo) DeleteUser(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.get(id)
+	if err != nil {
+		return err
+	}
+
+	r.users[user.ID].Deleted = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (r *MemoryUserrepo) CreateUser(user *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	user.Base = &Base{ID: uuid.New(), CreatedAt: now, UpdatedAt: now}
+	if user.Role == "" {
+		// default of the role column
+		user.Role = RoleUser
+	}
+	user.EmailIndex = fieldcrypt.BlindIndex(user.Email)
+	user.KeyID = fieldcrypt.CurrentKeyID()
+
+	if err := r.checkUnique(user); err != nil {
+		return nil, err
+	}
+
+	stored := clone(user)
+	r.users[user.ID] = &stored
+	r.order = append(r.order, user.ID)
+	return user, nil
+}
+
+// GetUserByID returns user based on given id
+func (r *MemoryUserrepo) GetUserByID(id string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePassword replaces the password hash of an active user
+func (r *MemoryUserrepo) ChangePassword(id string, hash string) (*User, error) {
+	return r.change(id, func(user *User) error {
+		if user.Suspended {
+			return errSuspended()
+		}
+		user.Password = hash
+		return nil
+	})
+}
+
+// ChangeEmail replaces the email of an active user
+func (r *MemoryUserrepo) ChangeEmail(id string, email string) (*User, error) {
+	return r.change(id, func(user *User) error {
+		if user.Suspended {
+			return errSuspended()
+		}
+		user.Email = email
+		return nil
+	})
+}
+
+// SuspendUser suspends an active user with given reason
+func (r *MemoryUserrepo) SuspendUser(id string, reason string) (*User, error) {
+	return r.change(id, func(user *User) error {
+		if user.Suspended {
+			return errSuspended()
+		}
+		user.Suspended = true
+		user.SuspendReason = reason
+		return nil
+	})
+}
+
+// ReactivateUser reactivates a suspended user
+func (r *MemoryUserrepo) ReactivateUser(id string) (*User, error) {
+	return r.change(id, func(user *User) error {
+		if !user.Suspended {
+			return errNotSuspended()
+		}
+		user.Suspended = false
+		user.SuspendReason = ""
+		return nil
+	})
+}
+
+// RestoreUser brings back a deleted user
+func (r *MemoryUserrepo) RestoreUser(id string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_id, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	stored, ok := r.users[_id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := checkRestorable(stored, id); err != nil {
+		return nil, err
+	}
+
+	user := clone(stored)
+	user.Deleted = gorm.DeletedAt{}
+	if err := r.checkUnique(&user); err != nil {
+		return nil, err
+	}
+
+	stored.Deleted = gorm.DeletedAt{}
+	return &user, nil
+}
+
+// ChangeRole replaces the role of the user
+func (r *MemoryUserrepo) ChangeRole(id string, role string) (*User, error) {
+	return r.change(id, func(user *User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+// GetDeletedUsers returns the soft-deleted users from the most recently deleted one
+func (r *MemoryUserrepo) GetDeletedUsers(pagination common.Pagination) (*common.Pagination, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deleted := r.deleted()
+	sort.SliceStable(deleted, func(i, j int) bool { return deleted[i].Deleted.Time.After(deleted[j].Deleted.Time) })
+
+	users := []User{}
+	if offset := pagination.GetOffset(); offset < len(deleted) {
+		end := offset + pagination.GetLimit()
+		if end > len(deleted) {
+			end = len(deleted)
+		}
+		users = deleted[offset:end]
+	}
+
+	pagination.Rows = users
+	pagination.TotalRows = int64(len(deleted))
+	pagination.TotalPages = int(math.Ceil(float64(len(deleted)) / float64(pagination.GetLimit())))
+	return &pagination, nil
+}
+
+// PurgeDeletedUsers permanently removes at most limit users which are deleted before given time and returns their ids
+// Their personal data in db is scrubbed in the same way as Userrepo does
+func (r *MemoryUserrepo) PurgeDeletedUsers(before time.Time, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := r.deleted()
+	sort.SliceStable(deleted, func(i, j int) bool { return deleted[i].Deleted.Time.Before(deleted[j].Deleted.Time) })
+
+	var ids []string
+	for _, user := range deleted {
+		if len(ids) == limit || !user.Deleted.Time.Before(before) {
+			break
+		}
+		ids = append(ids, user.ID.String())
+	}
+
+	if r.db != nil && len(ids) > 0 {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			for _, id := range ids {
+				if err := scrubUserData(tx, id, &erasure.Receipt{UserID: id, Purge: true}, r.scrub, r.log); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, user := range deleted[:len(ids)] {
+		r.remove(user.ID)
+	}
+	return ids, nil
+}
+
+// EraseUser irreversibly anonymizes the user, its personal data in db is scrubbed in the same way as Userrepo does
+func (r *MemoryUserrepo) EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_id, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	stored, ok := r.users[_id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	receipt.UserID = id
+	if r.db != nil {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			return scrubUserData(tx, id, &receipt, r.scrub, r.log)
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		receipt.CreatedAt = time.Now()
+	}
+
+	stored.NickName = erasure.Nickname(id)
+	stored.Email = erasure.Email(id)
+	stored.EmailIndex = fieldcrypt.BlindIndex(stored.Email)
+	stored.Password = ""
+	stored.FirstName = ""
+	stored.LastName = ""
+	stored.Country = ""
+	stored.SuspendReason = ""
+	if !stored.Deleted.Valid {
+		stored.Deleted = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	}
+	stored.UpdatedAt = time.Now()
+	return &receipt, nil
+}
+
+// ReencryptUsers marks the users whose fields are not encrypted with the current key as encrypted with it
+// Users are kept in plaintext in memory, so only their key ids and blind indexes are updated
+func (r *MemoryUserrepo) ReencryptUsers(limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := fieldcrypt.CurrentKeyID()
+	count := 0
+	for _, id := range r.order {
+		user := r.users[id]
+		if count == limit {
+			break
+		}
+		if user.KeyID == current {
+			continue
+		}
+		user.EmailIndex = fieldcrypt.BlindIndex(user.Email)
+		user.KeyID = current
+		count++
+	}
+	return count, nil
+}
+
+// change applies fn to a copy of the active user and stores it when fn succeeds
+func (r *MemoryUserrepo) change(id string, fn func(user *User) error) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(&user); err != nil {
+		return nil, err
+	}
+	if err := r.save(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// get returns a copy of the active user, gorm.ErrRecordNotFound is returned for the deleted and unknown users
+func (r *MemoryUserrepo) get(id string) (User, error) {
+	_id, err := uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := r.users[_id]
+	if !ok || user.Deleted.Valid {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return clone(user), nil
+}
+
+// save replaces the stored user as gorm's Save does, unique indexes are checked first
+func (r *MemoryUserrepo) save(user *User) error {
+	user.EmailIndex = fieldcrypt.BlindIndex(user.Email)
+	user.KeyID = fieldcrypt.CurrentKeyID()
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+	stored := clone(user)
+	r.users[user.ID] = &stored
+	return nil
+}
+
+// checkUnique returns the violation of the partial unique indexes of the users table by given user
+func (r *MemoryUserrepo) checkUnique(user *User) error {
+	if user.Deleted.Valid {
+		return nil
+	}
+
+	for _, other := range r.users {
+		if other.ID == user.ID || other.Deleted.Valid {
+			continue
+		}
+		if other.NickName == user.NickName {
+			return &apperror.UniqueViolation{Index: "idx_users_nick_name_active"}
+		}
+		if other.EmailIndex == user.EmailIndex {
+			return &apperror.UniqueViolation{Index: "idx_users_email_index_active"}
+		}
+	}
+	return nil
+}
+
+// active returns copies of the users which are not deleted in the order they are created
+func (r *MemoryUserrepo) active() []User {
+	users := make([]User, 0, len(r.order))
+	for _, id := range r.order {
+		if user := r.users[id]; !user.Deleted.Valid {
+			users = append(users, clone(user))
+		}
+	}
+	return users
+}
+
+// deleted returns copies of the soft-deleted users in the order they are created
+func (r *MemoryUserrepo) deleted() []User {
+	users := make([]User, 0)
+	for _, id := range r.order {
+		if user := r.users[id]; user.Deleted.Valid {
+			users = append(users, clone(user))
+		}
+	}
+	return users
+}
+
+func (r *MemoryUserrepo) remove(id uuid.UUID) {
+	delete(r.users, id)
+	for i, ordered := range r.order {
+		if ordered == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// NewMemoryUserRepo returns the in-memory user repository
+// db is the database of the audit log and scrub's data which are scrubbed when a user is erased, it can be nil
+func NewMemoryUserRepo(db *gorm.DB, scrub ScrubFunc, log *log.Entry) UserRepository {
+	return &MemoryUserrepo{
+		users: make(map[uuid.UUID]*User),
+		db:    db,
+		scrub: scrub,
+		log:   log,
+	}
+}
+
+// NewBackendUserRepo returns the user repository of the storage backend
+// SQLite and Postgres share the gorm implementation, reads can be nil
+func NewBackendUserRepo(backend string, db *gorm.DB, reads postgres.ReadRouter, scrub ScrubFunc, log *log.Entry) UserRepository {
+	if backend == storage.Memory {
+		return NewMemoryUserRepo(db, scrub, log)
+	}
+	return NewReplicatedUserRepo(db, reads, scrub, log)
+}
@@ -0,0 +1,420 @@
+package domain
+
+import (
+	"errors"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+// UserRepository stores the users, passwords must be hashed before they are given to it
+type UserRepository interface {
+	GetAllUser(pagination common.Pagination) (*common.Pagination, error)
+	CreateUser(user *User) (*User, error)
+	UpdateUser(id string, update *UpdateUser) (*User, error)
+	DeleteUser(id string) error
+	GetUserByID(id string) (*User, error)
+	ChangePassword(id string, hash string) (*User, error)
+	ChangeEmail(id string, email string) (*User, error)
+	SuspendUser(id string, reason string) (*User, error)
+	ReactivateUser(id string) (*User, error)
+	RestoreUser(id string) (*User, error)
+	ChangeRole(id string, role string) (*User, error)
+	GetDeletedUsers(pagination common.Pagination) (*common.Pagination, error)
+	PurgeDeletedUsers(before time.Time, limit int) ([]string, error)
+	EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error)
+	ReencryptUsers(limit int) (int, error)
+}
+
+// ScrubFunc scrubs the personal data of an erased user which is kept outside of the users table and the audit log
+// It runs in the transaction of the erasure
+type ScrubFunc func(tx *gorm.DB, id string, receipt *erasure.Receipt) error
+
+// A Userrepo writes the users to db, reads of the users are routed by reads when it is not nil
+type Userrepo struct {
+	db    *gorm.DB
+	reads postgres.ReadRouter
+	scrub ScrubFunc
+	log   *log.Entry
+}
+
+// paginate returns gorm DB struct which is calculated result
+func (r Userrepo) paginate(value interface{}, pagination *common.Pagination, db *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	var totalRows int64
+	db.Model(value).Count(&totalRows)
+
+	pagination.TotalRows = totalRows
+	totalPages := int(math.Ceil(float64(totalRows) / float64(pagination.Limit)))
+	pagination.TotalPages = totalPages
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Order(pagination.GetSort())
+	}
+}
+
+// GetAllUser returns common.Pagination struct which is sorted, paginated and filtered
+// You can get user which is  filtered, sorted and paginated list.
+// http://localhost:8089/api/v1/user/?limit=10&page=1&cQuery=country%20%3D%20%3F&cValue=UK
+// http://localhost:8089/api/v1/user/?limit=10&page=1&sColumn=0&sType=0
+func (r Userrepo) GetAllUser(pagination common.Pagination) (*common.Pagination, error) {
+	var users []User
+	reads := r.reader("")
+
+	if len(pagination.Conditions) > 0 {
+		db := reads.Where(pagination.Conditions).Session(&gorm.Session{})
+		tx := db.Scopes(r.paginate(users, &pagination, db)).Find(&users)
+		pagination.Rows = users
+		return &pagination, tx.Error
+	}
+
+	if pagination.CQuery != "" && pagination.CValue != "" {
+		conditionQuery := pagination.CQuery
+		conditionValue := pagination.CValue
+		// the condition is applied to the count as well
+		db := reads.Where(conditionQuery, conditionValue).Session(&gorm.Session{})
+		tx := db.Scopes(r.paginate(users, &pagination, db)).Find(&users)
+		pagination.Rows = users
+		return &pagination, tx.Error
+	} else if pagination.CQuery == "" && pagination.CValue == "" {
+		tx := reads.Scopes(r.paginate(users, &pagination, reads)).Find(&users)
+		pagination.Rows = users
+		return &pagination, tx.Error
+	} else {
+		return nil, nil
+	}
+
+}
+
+// UpdateUser applies the update to an active user and returns it
+func (r Userrepo) UpdateUser(id string, update *UpdateUser) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := update.apply(user); err != nil {
+		return nil, err
+	}
+
+	return user, r.save(user)
+}
+
+// DeleteUser returns error if deleting process get an error
+func (r Userrepo) DeleteUser(id string) error {
+	user, err := r.find(r.db, id)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.written(id)
+	tx := r.db.Delete(user)
+	return tx.Error
+}
+
+// CreateUser stores the user, its password must be hashed already
+func (r Userrepo) CreateUser(user *User) (*User, error) {
+	if err := r.db.Create(user).Error; err != nil {
+		return nil, err
+	}
+	r.written(user.ID.String())
+	return user, nil
+}
+
+// GetUserByID returns user based on given id
+func (r Userrepo) GetUserByID(id string) (*User, error) {
+	return r.find(r.reader(id), id)
+}
+
+// find returns the active user of given id from db, the writes read the user from the primary
+func (r Userrepo) find(db *gorm.DB, id string) (*User, error) {
+	var user User
+	_id, err := uuid.Parse(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Where("id = ?", _id).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ChangePassword replaces the password hash of an active user
+func (r Userrepo) ChangePassword(id string, hash string) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Suspended {
+		return nil, errSuspended()
+	}
+
+	user.Password = hash
+	return user, r.save(user)
+}
+
+// ChangeEmail replaces the email of an active user
+func (r Userrepo) ChangeEmail(id string, email string) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Suspended {
+		return nil, errSuspended()
+	}
+
+	user.Email = email
+	return user, r.save(user)
+}
+
+// SuspendUser suspends an active user with given reason
+func (r Userrepo) SuspendUser(id string, reason string) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Suspended {
+		return nil, errSuspended()
+	}
+
+	user.Suspended = true
+	user.SuspendReason = reason
+	return user, r.save(user)
+}
+
+// ReactivateUser reactivates a suspended user
+func (r Userrepo) ReactivateUser(id string) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Suspended {
+		return nil, errNotSuspended()
+	}
+
+	user.Suspended = false
+	user.SuspendReason = ""
+	return user, r.save(user)
+}
+
+// RestoreUser brings back a deleted user
+func (r Userrepo) RestoreUser(id string) (*User, error) {
+	var user User
+	if err := r.db.Unscoped().Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if err := checkRestorable(&user, id); err != nil {
+		return nil, err
+	}
+
+	defer r.written(id)
+	if err := r.db.Unscoped().Model(&user).Update("deleted", nil).Error; err != nil {
+		return nil, err
+	}
+	user.Deleted = gorm.DeletedAt{}
+	return &user, nil
+}
+
+// ChangeRole replaces the role of the user
+func (r Userrepo) ChangeRole(id string, role string) (*User, error) {
+	user, err := r.find(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	return user, r.save(user)
+}
+
+// GetDeletedUsers returns the soft-deleted users from the most recently deleted one
+func (r Userrepo) GetDeletedUsers(pagination common.Pagination) (*common.Pagination, error) {
+	var users []User
+	var total int64
+
+	db := r.db.Unscoped().Model(&User{}).Where("deleted IS NOT NULL")
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	err := db.Order("deleted desc").Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Find(&users).Error
+	pagination.Rows = users
+	pagination.TotalRows = total
+	pagination.TotalPages = int(math.Ceil(float64(total) / float64(pagination.GetLimit())))
+	return &pagination, err
+}
+
+// PurgeDeletedUsers permanently removes at most limit users which are deleted before given time and returns their ids
+// Their personal data is scrubbed in the same transaction as the erasure does
+func (r Userrepo) PurgeDeletedUsers(before time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := r.db.Unscoped().Model(&User{}).
+		Where("deleted IS NOT NULL AND deleted < ?", before).
+		Order("deleted asc").Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	defer r.written(ids...)
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id IN ?", ids).Delete(&User{}).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := scrubUserData(tx, id, &erasure.Receipt{UserID: id, Purge: true}, r.scrub, r.log); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// EraseUser irreversibly anonymizes the user and scrubs its personal data from the audit log and the scrub func
+// The user is kept as a deleted tombstone, so its id stays valid and its nickname and email are free
+func (r Userrepo) EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error) {
+	receipt.UserID = id
+	defer r.written(id)
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Unscoped().Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+
+		err := tx.Unscoped().Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"nick_name":      erasure.Nickname(id),
+			"email":          erasure.Email(id),
+			"email_index":    fieldcrypt.BlindIndex(erasure.Email(id)),
+			"password":       "",
+			"first_name":     "",
+			"last_name":      "",
+			"country":        "",
+			"suspend_reason": "",
+			"deleted":        gorm.Expr("COALESCE(deleted, ?)", time.Now()),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return scrubUserData(tx, id, &receipt, r.scrub, r.log)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// ReencryptUsers encrypts at most limit users whose fields are not encrypted with the current key and returns their count
+// Deleted users are encrypted as well, updated_at is not changed
+func (r Userrepo) ReencryptUsers(limit int) (int, error) {
+	var users []User
+	current := fieldcrypt.CurrentKeyID()
+	if err := r.db.Unscoped().Where("key_id IS NULL OR key_id <> ?", current).Order("id").Limit(limit).Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	for i := range users {
+		user := &users[i]
+		user.EmailIndex = fieldcrypt.BlindIndex(user.Email)
+		user.KeyID = current
+
+		err := r.db.Unscoped().Model(user).Select("email", "first_name", "last_name", "email_index", "key_id").Updates(user).Error
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
+
+// Invalidate sends the reads of the users to the primary for the sticky window, it is called when they are changed by another process
+func (r Userrepo) Invalidate(ids ...string) {
+	r.written(ids...)
+}
+
+// reader returns the database which serves the read of the user, key is empty for the reads of many users
+func (r Userrepo) reader(key string) *gorm.DB {
+	if r.reads == nil {
+		return r.db
+	}
+	return r.reads.Reader(key)
+}
+
+// written records the write of the users so that their writer reads them from the primary
+func (r Userrepo) written(ids ...string) {
+	if r.reads != nil {
+		r.reads.Written(ids...)
+	}
+}
+
+// save writes the user to the primary
+func (r Userrepo) save(user *User) error {
+	defer r.written(user.ID.String())
+	return r.db.Save(user).Error
+}
+
+// scrubUserData scrubs the personal data of the user from the audit log and the scrub func and records the receipt of its erasure
+func scrubUserData(tx *gorm.DB, id string, receipt *erasure.Receipt, scrub ScrubFunc, log *log.Entry) error {
+	var err error
+	if receipt.AuditScrubbed, err = audit.NewRepository(tx, log).Scrub(id); err != nil {
+		return err
+	}
+
+	if scrub != nil {
+		if err := scrub(tx, id, receipt); err != nil {
+			return err
+		}
+	}
+	return tx.Create(receipt).Error
+}
+
+// checkRestorable returns the reason why the user can't be restored
+func checkRestorable(user *User, id string) error {
+	if !user.Deleted.Valid {
+		return apperror.New(apperror.FailedPrecondition, apperror.ReasonUserNotDeleted, "user is not deleted")
+	}
+	if user.NickName == erasure.Nickname(id) {
+		return apperror.New(apperror.FailedPrecondition, apperror.ReasonUserErased, "user is erased")
+	}
+	return nil
+}
+
+func errSuspended() error {
+	return apperror.New(apperror.FailedPrecondition, apperror.ReasonUserSuspended, "user is suspended")
+}
+
+func errNotSuspended() error {
+	return apperror.New(apperror.FailedPrecondition, apperror.ReasonUserNotSuspended, "user is not suspended")
+}
+
+// NewUserRepo returns the gorm user repository, scrub can be nil
+func NewUserRepo(db *gorm.DB, scrub ScrubFunc, log *log.Entry) UserRepository {
+	return NewReplicatedUserRepo(db, nil, scrub, log)
+}
+
+// NewReplicatedUserRepo returns the gorm user repository whose reads are routed to the replicas by reads
+func NewReplicatedUserRepo(db *gorm.DB, reads postgres.ReadRouter, scrub ScrubFunc, log *log.Entry) UserRepository {
+	return &Userrepo{
+		db:    db,
+		reads: reads,
+		scrub: scrub,
+		log:   log,
+	}
+}
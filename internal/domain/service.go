@@ -0,0 +1,250 @@
+package domain
+
+import (
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of the users
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// HashCost is the bcrypt cost of the passwords
+var HashCost = 14
+
+// UserService contains the business rules of the users, the payloads are validated and the passwords are hashed by it
+// Errors are domain errors of apperror or the errors of the repository
+type UserService interface {
+	Validate(user *User) error
+	GetUser(id string) (*User, error)
+	ListUsers(pagination common.Pagination) (*common.Pagination, error)
+	ListDeletedUsers(pagination common.Pagination) (*common.Pagination, error)
+	CreateUser(user *User) (*User, error)
+	UpdateUser(id string, update *UpdateUser) (*User, error)
+	DeleteUser(id string) error
+	ChangePassword(id string, password string) (*User, error)
+	ChangeEmail(id string, email string) (*User, error)
+	SuspendUser(id string, reason string) (*User, error)
+	ReactivateUser(id string) (*User, error)
+	RestoreUser(id string) (*User, error)
+	ChangeRole(id string, role string) (*User, error)
+	EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error)
+}
+
+// A UserSvc contains the required dependencies for this service
+type UserSvc struct {
+	users    UserRepository
+	validate *validator.Validate
+	log      *log.Entry
+}
+
+// HashPassword returns encrypted password based on given password
+func HashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), HashCost)
+	return string(bytes), err
+}
+
+// Validate returns the violations of the user payload
+func (s UserSvc) Validate(user *User) error {
+	return s.validate.Struct(user)
+}
+
+// GetUser returns the active user based on given id
+func (s UserSvc) GetUser(id string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return s.users.GetUserByID(id)
+}
+
+// ListUsers returns filtered, sorted and paginated active users
+// Filters on the encrypted columns are rewritten or rejected, see lookupFilters
+func (s UserSvc) ListUsers(pagination common.Pagination) (*common.Pagination, error) {
+	pagination, err := lookupFilters(pagination)
+	if err != nil {
+		return nil, err
+	}
+	return s.users.GetAllUser(pagination)
+}
+
+// ListDeletedUsers returns the soft-deleted users from the most recently deleted one
+func (s UserSvc) ListDeletedUsers(pagination common.Pagination) (*common.Pagination, error) {
+	return s.users.GetDeletedUsers(pagination)
+}
+
+// CreateUser validates the user and stores it with its hashed password
+func (s UserSvc) CreateUser(user *User) (*User, error) {
+	if err := s.Validate(user); err != nil {
+		return nil, err
+	}
+
+	hash, err := HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+
+	return s.users.CreateUser(user)
+}
+
+// UpdateUser validates the update and applies it to an active user
+// Nickname, email and password can't be cleared by an update mask
+func (s UserSvc) UpdateUser(id string, update *UpdateUser) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+
+	for _, path := range update.UpdateMask {
+		switch path {
+		case "nickname", "email", "password":
+		case "first_name", "last_name", "country":
+			continue
+		default:
+			return nil, &apperror.Error{
+				Kind:       apperror.InvalidArgument,
+				Reason:     apperror.ReasonInvalidArgument,
+				Message:    "unknown update_mask path " + path,
+				Violations: []apperror.FieldViolation{{Field: "update_mask", Description: "unknown path " + path}},
+			}
+		}
+		if update.value(path) == "" {
+			return nil, validationFailed(path, "can not be empty")
+		}
+	}
+
+	if !update.has("password") {
+		return s.users.UpdateUser(id, update)
+	}
+
+	hash, err := HashPassword(update.Password)
+	if err != nil {
+		return nil, err
+	}
+	hashed := *update
+	hashed.Password = hash
+
+	user, err := s.users.UpdateUser(id, &hashed)
+	update.Changed = hashed.Changed
+	return user, err
+}
+
+// DeleteUser soft-deletes the user based on given id
+func (s UserSvc) DeleteUser(id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+	return s.users.DeleteUser(id)
+}
+
+// ChangePassword replaces the password of an active user with the hash of given password
+func (s UserSvc) ChangePassword(id string, password string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return nil, validationFailed("password", "can not be empty")
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return s.users.ChangePassword(id, hash)
+}
+
+// ChangeEmail replaces the email of an active user
+func (s UserSvc) ChangeEmail(id string, email string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	if err := s.validate.Var(email, "required,email"); err != nil {
+		return nil, validationFailed("email", "must be a valid email")
+	}
+	return s.users.ChangeEmail(id, email)
+}
+
+// SuspendUser suspends an active user with given reason
+func (s UserSvc) SuspendUser(id string, reason string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return s.users.SuspendUser(id, reason)
+}
+
+// ReactivateUser reactivates a suspended user
+func (s UserSvc) ReactivateUser(id string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return s.users.ReactivateUser(id)
+}
+
+// RestoreUser brings back a deleted user which is not erased
+func (s UserSvc) RestoreUser(id string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return s.users.RestoreUser(id)
+}
+
+// ChangeRole replaces the role of the user, role is one of RoleUser and RoleAdmin
+func (s UserSvc) ChangeRole(id string, role string) (*User, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	if role != RoleUser && role != RoleAdmin {
+		return nil, &apperror.Error{
+			Kind:       apperror.InvalidArgument,
+			Reason:     apperror.ReasonInvalidArgument,
+			Message:    "role unknown role",
+			Violations: []apperror.FieldViolation{{Field: "role", Description: "unknown role"}},
+		}
+	}
+	return s.users.ChangeRole(id, role)
+}
+
+// EraseUser irreversibly anonymizes the user and scrubs its personal data, the receipt is returned with its counts
+func (s UserSvc) EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return s.users.EraseUser(id, receipt)
+}
+
+func validID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return apperror.InvalidID(id)
+	}
+	return nil
+}
+
+func validationFailed(field string, description string) error {
+	return &apperror.Error{
+		Kind:       apperror.Validation,
+		Reason:     apperror.ReasonValidationFailed,
+		Message:    "validation failed",
+		Violations: []apperror.FieldViolation{{Field: field, Description: description}},
+	}
+}
+
+// NewUserService returns the user service over given repository
+// The validator reports the json names of the fields, a new one is used when it is nil
+func NewUserService(users UserRepository, validate *validator.Validate, log *log.Entry) UserService {
+	if validate == nil {
+		validate = validator.New()
+	}
+	apperror.UseJSONFieldNames(validate)
+
+	return &UserSvc{
+		users:    users,
+		validate: validate,
+		log:      log,
+	}
+}
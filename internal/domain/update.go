@@ -0,0 +1,80 @@
+package domain
+
+import "github.com/google/uuid"
+
+// UpdateUser is representation of the update payload
+// When UpdateMask is given only the listed fields are changed even if they are empty
+// Changed is set to the paths of the fields whose values are changed once the update is applied, a set password is always changed
+type UpdateUser struct {
+	ID         uuid.UUID `json:"id"`
+	NickName   string    `json:"nickname"`
+	Email      string    `json:"email"`
+	Password   string    `json:"password"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Country    string    `json:"country"`
+	UpdateMask []string  `json:"-"`
+	Changed    []string  `json:"-"`
+}
+
+// has reports whether the field is changed by the update
+func (u *UpdateUser) has(path string) bool {
+	if len(u.UpdateMask) == 0 {
+		return u.value(path) != ""
+	}
+
+	for _, masked := range u.UpdateMask {
+		if masked == path {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the value of the field of the update
+func (u *UpdateUser) value(path string) string {
+	switch path {
+	case "nickname":
+		return u.NickName
+	case "email":
+		return u.Email
+	case "password":
+		return u.Password
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "country":
+		return u.Country
+	}
+	return ""
+}
+
+// apply applies the fields of the update to an active user, the password of the update must be hashed already
+// Only the fields of the update mask are applied when it is given, otherwise the non-empty fields are
+func (u *UpdateUser) apply(user *User) error {
+	if user.Suspended {
+		return errSuspended()
+	}
+
+	u.Changed = nil
+	u.set("nickname", &user.NickName)
+	u.set("email", &user.Email)
+	u.set("password", &user.Password)
+	u.set("first_name", &user.FirstName)
+	u.set("last_name", &user.LastName)
+	u.set("country", &user.Country)
+	return nil
+}
+
+// set applies the field of the update and records it in Changed when its value differs
+func (u *UpdateUser) set(path string, field *string) {
+	if !u.has(path) {
+		return
+	}
+
+	if value := u.value(path); *field != value || path == "password" {
+		*field = value
+		u.Changed = append(u.Changed, path)
+	}
+}
@@ -0,0 +1,89 @@
+// Package domain contains the user model, its repository and the business rules which are shared by the services
+// HTTP services and gRPC event server are adapters over UserService
+package domain
+
+import (
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Base contains common columns for all tables.
+type Base struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;" json:"id"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Deleted   gorm.DeletedAt
+}
+
+// User struct
+// Nickname and email are unique among the users which are not deleted, so they can be registered again after a delete
+// Email and names are encrypted, email is unique and looked up by its blind index, KeyID is the key which encrypted them
+type User struct {
+	*Base
+	NickName  string `gorm:"index:idx_users_nick_name_active,unique,where:deleted IS NULL" json:"nickname" validate:"required"   `
+	Email     string `gorm:"serializer:encrypted" json:"email"  validate:"required,email" `
+	Password  string `gorm:"not null" json:"password"  validate:"required" `
+	FirstName string `gorm:"serializer:encrypted" json:"first_name"`
+	LastName  string `gorm:"serializer:encrypted" json:"last_name"`
+	Country   string `json:"country"`
+	// Suspended users can't change their profile, email or password until they are reactivated
+	Suspended     bool   `json:"suspended"`
+	SuspendReason string `json:"suspend_reason,omitempty"`
+	Role          string `gorm:"not null;default:user" json:"role"`
+	EmailIndex    string `gorm:"index:idx_users_email_index_active,unique,where:deleted IS NULL" json:"-"`
+	KeyID         string `gorm:"index" json:"-"`
+}
+
+type UserData struct {
+	ID        uuid.UUID `json:"id"`
+	NickName  string    `json:"nickname,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Password  string    `json:"password,omitempty"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Suspended bool      `json:"suspended,omitempty"`
+	Role      string    `json:"role,omitempty"`
+}
+
+func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	var base = Base{
+		ID:        uuid.UUID{},
+		CreatedAt: time.Time{},
+		UpdatedAt: time.Time{},
+		Deleted:   gorm.DeletedAt{},
+	}
+	u.Base = &base
+	str := uuid.New()
+	u.ID = str
+	return err
+}
+
+// Data returns the response representation of the user, password is not included
+func (u *User) Data() UserData {
+	return UserData{
+		ID:        u.ID,
+		NickName:  u.NickName,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Country:   u.Country,
+		Suspended: u.Suspended,
+		Role:      u.Role,
+	}
+}
+
+// DeletedUserData is representation of a user in the trash
+type DeletedUserData struct {
+	UserData
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// BeforeSave keeps the blind index of the email and the key id of the encrypted fields up to date
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	u.EmailIndex = fieldcrypt.BlindIndex(u.Email)
+	u.KeyID = fieldcrypt.CurrentKeyID()
+	return err
+}
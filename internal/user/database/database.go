@@ -1,8 +1,12 @@
 package database
 
 import (
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
 	"gorm.io/gorm"
 )
 
 // DB gorm connector
 var DB *gorm.DB
+
+// Reads routes the reads of the users to the replicas of DB, they are read from DB when it is nil
+var Reads postgres.ReadRouter
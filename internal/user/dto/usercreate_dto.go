@@ -1,10 +0,0 @@
-package dto
-
-type NewUser struct {
-	Nickname  string `json:"nickname"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Country   string `json:"country"`
-}
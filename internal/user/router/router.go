@@ -2,14 +2,21 @@ package router
 
 import (
 	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/user/database"
-	"github.com/cemayan/faceit-technical-test/internal/user/repo"
 	"github.com/cemayan/faceit-technical-test/internal/user/service"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	grpcrepo "github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/swagger"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
@@ -23,20 +30,29 @@ func prometheusHandler() http.Handler {
 // SetupRoutes creates the fiber's routes
 // api/v1 is root group.
 // Before the reach services interface is configured
-func SetupRoutes(app *fiber.App, log *log.Entry, configs *user.AppConfig) {
+// Users are read through userCache when it is not nil, the returned invalidator evicts the users which are changed by the other processes
+func SetupRoutes(app *fiber.App, log *log.Entry, userCache cache.Cache, configs *user.AppConfig) domain.UserInvalidator {
 
 	app.Get("/metrics", monitor.New(monitor.Config{Title: "MyService Metrics Page"}))
 
-	api := app.Group("/api", logger.New())
+	api := app.Group("/api", requestid.New(), logger.New())
 	v1 := api.Group("/v1")
 
 	v1.Get("/metrics", adaptor.HTTPHandler(prometheusHandler()))
 	v1.Get("/swagger/*", swagger.HandlerDefault) // default
 
-	userRepo := repo.NewUserRepo(database.DB, log)
+	userRepo := domain.NewBackendUserRepo(storage.Backend(&configs.Storage), database.DB, database.Reads, grpcrepo.EventStoreScrub(log), log)
+	if userCache != nil {
+		userRepo = domain.NewCachedUserRepo(userRepo, userCache, configs.Cache.TTL, log)
+	}
+	changes, _ := userRepo.(domain.UserInvalidator)
+	auditRepo := audit.NewRepository(database.DB, log)
+
+	// Changes are appended to the event store which is shared with gRPC event server, they are audited by this service
+	eventRecorder := handler.NewEventRecorder(grpcrepo.NewEventRepo(database.DB, log), broker.NewEventBroker(), nil, nil, log.Logger)
 
 	var validate = validator.New()
-	var userSvc = service.NewUserService(userRepo, validate, log, configs)
+	var userSvc = service.NewUserService(domain.NewUserService(userRepo, validate, log), auditRepo, eventRecorder, log, configs)
 
 	v1.Get("/health", userSvc.HealthCheck)
 
@@ -46,4 +62,7 @@ func SetupRoutes(app *fiber.App, log *log.Entry, configs *user.AppConfig) {
 	userGroup.Post("/", userSvc.CreateUser)
 	userGroup.Put("/:id", userSvc.UpdateUser)
 	userGroup.Delete("/:id", userSvc.DeleteUser)
+	userGroup.Post("/:id/erase", userSvc.EraseUser)
+
+	return changes
 }
@@ -3,14 +3,18 @@ package service
 import (
 	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
-	"github.com/cemayan/faceit-technical-test/internal/user/dto"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/user/model"
-	"github.com/cemayan/faceit-technical-test/internal/user/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	grpcutil "github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
 	"github.com/cemayan/faceit-technical-test/pkg/common"
-	"github.com/go-playground/validator/v10"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService interface {
@@ -20,15 +24,22 @@ type UserService interface {
 	CreateUser(c *fiber.Ctx) error
 	UpdateUser(c *fiber.Ctx) error
 	DeleteUser(c *fiber.Ctx) error
+	EraseUser(c *fiber.Ctx) error
 	HealthCheck(c *fiber.Ctx) error
 }
 
+// auditSource is the source of the audit entries which are recorded by this service
+const auditSource = "user"
+
 // A UserSvc  contains the required dependencies for this service
+// Writes are recorded to the shared event store like the ones of gRPC event server, so that the history,
+// the subscribers and the caches of the other services see them
 type UserSvc struct {
-	repository repo.UserRepository
-	validate   *validator.Validate
-	log        *log.Entry
-	configs    *user.AppConfig
+	users   domain.UserService
+	audits  audit.Repository
+	events  handler.EventRecorder
+	log     *log.Entry
+	configs *user.AppConfig
 }
 
 // GetAllUser returns filtered users based on given payload
@@ -53,7 +64,7 @@ func (s UserSvc) GetAllUser(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := s.repository.GetAllUser(pagination)
+	result, err := s.users.ListUsers(pagination)
 
 	if err != nil {
 		s.log.WithFields(log.Fields{"method": "GetAllUser"}).Errorf(fmt.Sprintf("An error occured %s \n", err))
@@ -76,8 +87,7 @@ func (s UserSvc) HealthCheck(c *fiber.Ctx) error {
 
 // HashPassword returns encrypted password based on given password
 func (s UserSvc) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return domain.HashPassword(password)
 }
 
 // GetUser returns user based on given id.
@@ -88,7 +98,7 @@ func (s UserSvc) HashPassword(password string) (string, error) {
 func (s UserSvc) GetUser(c *fiber.Ctx) error {
 
 	id := c.Params("id")
-	userModel, err := s.repository.GetUserByID(id)
+	userModel, err := s.users.GetUser(id)
 	if userModel == nil || err != nil {
 		s.log.WithFields(log.Fields{"method": "GetUser"}).Errorf("No user found with %s \n", id)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
@@ -99,26 +109,19 @@ func (s UserSvc) GetUser(c *fiber.Ctx) error {
 
 	return c.JSON(model.Response{
 		StatusCode: 200,
-		Data: model.UserData{
-			ID:        userModel.ID,
-			NickName:  userModel.NickName,
-			Email:     userModel.Email,
-			FirstName: userModel.FirstName,
-			LastName:  userModel.LastName,
-			Country:   userModel.Country,
-		},
+		Data:       userModel.Data(),
 	})
 }
 
 // CreateUser creates new user based on given payload
 // While user is creating password is encrypted then it is assigned as a password
 // @Summary  CreateUser
-// @Param    request body model.User true "query params"
+// @Param    request body domain.User true "query params"
 // @Tags     User
 // @Router   / [post]
 func (s UserSvc) CreateUser(c *fiber.Ctx) error {
 
-	userReq := new(model.User)
+	userReq := new(domain.User)
 	if err := c.BodyParser(userReq); err != nil {
 		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Review your input %s", err)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
@@ -127,7 +130,7 @@ func (s UserSvc) CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	err := s.validate.Struct(userReq)
+	err := s.users.Validate(userReq)
 
 	if err != nil {
 		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Review your payload %s", err)
@@ -137,18 +140,7 @@ func (s UserSvc) CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	hash, err := s.HashPassword(userReq.Password)
-	if err != nil {
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Couldn't hash password %s \n", err)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("Couldn't hash password %s", err),
-			StatusCode: 400,
-		})
-	}
-
-	userReq.Password = hash
-
-	userResp, err := s.repository.CreateUser(userReq)
+	userResp, err := s.users.CreateUser(userReq)
 	if userResp == nil || err != nil {
 		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Couldn't create use %s \n", err)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
@@ -157,23 +149,22 @@ func (s UserSvc) CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	newUser := dto.NewUser{
-		Email:    userResp.Email,
-		Nickname: userResp.NickName,
+	s.audit(c, audit.ActionUserCreated, userResp.ID.String(), nil, auditFields(userResp))
+	err = s.record(c, &pb.Events{
+		EventName: pb.EventName_USER_CREATED,
+		Payload:   &pb.Events_CreateUser{CreateUser: grpcutil.NewCreateUserPayload(userResp)},
+	}, userResp.ID.String())
+	if err != nil {
+		return s.unrecorded(c, err)
 	}
 
+	newUser := fmt.Sprintf("{%s %s}", userResp.NickName, userResp.Email)
+
 	s.log.Infof("User created %s \n", newUser)
 	return c.Status(fiber.StatusCreated).JSON(model.Response{
 		StatusCode: 201,
-		Data: model.UserData{
-			ID:        userResp.ID,
-			NickName:  userResp.NickName,
-			Email:     userResp.Email,
-			FirstName: userResp.FirstName,
-			LastName:  userResp.LastName,
-			Country:   userResp.Country,
-		},
-		Message: fmt.Sprintf("User created %s", newUser),
+		Data:       userResp.Data(),
+		Message:    fmt.Sprintf("User created %s", newUser),
 	})
 
 }
@@ -181,12 +172,12 @@ func (s UserSvc) CreateUser(c *fiber.Ctx) error {
 // UpdateUser return updated user based on given payload
 // @Summary  UpdateUser
 // @Param    id      path string         true "id"
-// @Param    request body dto.UpdateUser true "query params"
+// @Param    request body domain.UpdateUser true "query params"
 // @Tags     User
 // @Router   /{id} [put]
 func (s UserSvc) UpdateUser(c *fiber.Ctx) error {
 
-	var userDTO dto.UpdateUser
+	var userDTO domain.UpdateUser
 	if err := c.BodyParser(&userDTO); err != nil {
 		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("Review your input %s \n", err)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
@@ -204,8 +195,8 @@ func (s UserSvc) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	userModel, err := s.repository.GetUserByID(id)
-	if userModel == nil || err != nil {
+	current, err := s.users.GetUser(id)
+	if current == nil || err != nil {
 		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("No user found with %s", err)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
 			StatusCode: 400,
@@ -213,27 +204,9 @@ func (s UserSvc) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	if userDTO.Password != "" {
-		hash, _ := s.HashPassword(userDTO.Password)
-		userModel.Password = hash
-	}
-	if userDTO.NickName != "" {
-		userModel.NickName = userDTO.NickName
-	}
-	if userDTO.Email != "" {
-		userModel.Email = userDTO.Email
-	}
-	if userDTO.FirstName != "" {
-		userModel.FirstName = userDTO.FirstName
-	}
-	if userDTO.LastName != "" {
-		userModel.FirstName = userDTO.FirstName
-	}
-	if userDTO.Country != "" {
-		userModel.Country = userDTO.Country
-	}
+	before := auditFields(current)
 
-	err = s.repository.UpdateUser(userModel)
+	userModel, err := s.users.UpdateUser(id, &userDTO)
 	if err != nil {
 		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("While user is updating an error occured: %s", err)
 		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
@@ -241,18 +214,15 @@ func (s UserSvc) UpdateUser(c *fiber.Ctx) error {
 			Message:    fmt.Sprintf("While user is updating an error occured: %s", err),
 		})
 	} else {
+		s.audit(c, audit.ActionUserUpdated, id, before, auditFields(userModel))
+		if err := s.recordUpdate(c, id, &userDTO); err != nil {
+			return s.unrecorded(c, err)
+		}
 		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Infof("User successfully updated \n")
 		return c.Status(fiber.StatusOK).JSON(model.Response{
 			StatusCode: 200,
-			Data: model.UserData{
-				ID:        userModel.ID,
-				NickName:  userModel.NickName,
-				Email:     userModel.Email,
-				FirstName: userModel.FirstName,
-				LastName:  userModel.LastName,
-				Country:   userModel.Country,
-			},
-			Message: "User successfully updated",
+			Data:       userModel.Data(),
+			Message:    "User successfully updated",
 		})
 	}
 }
@@ -265,7 +235,12 @@ func (s UserSvc) UpdateUser(c *fiber.Ctx) error {
 func (s UserSvc) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	err := s.repository.DeleteUser(id)
+	var before map[string]string
+	if userModel, err := s.users.GetUser(id); err == nil && userModel != nil {
+		before = auditFields(userModel)
+	}
+
+	err := s.users.DeleteUser(id)
 	if err != nil {
 
 		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("While user is deleting an error occured: %s \n", err)
@@ -274,6 +249,14 @@ func (s UserSvc) DeleteUser(c *fiber.Ctx) error {
 			Message:    fmt.Sprintf("While user is deleting an error occured: %s", err),
 		})
 	} else {
+		s.audit(c, audit.ActionUserDeleted, id, before, nil)
+		err = s.record(c, &pb.Events{
+			EventName: pb.EventName_USER_DELETED,
+			Payload:   &pb.Events_DeleteUser{DeleteUser: &pb.DeleteUser{Id: id}},
+		}, id)
+		if err != nil {
+			return s.unrecorded(c, err)
+		}
 		s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("User successfully deleted %v \n", id)
 		return c.Status(fiber.StatusOK).JSON(model.Response{
 			StatusCode: 200,
@@ -282,11 +265,138 @@ func (s UserSvc) DeleteUser(c *fiber.Ctx) error {
 	}
 }
 
-func NewUserService(rep repo.UserRepository, validate *validator.Validate, log *log.Entry, configs *user.AppConfig) UserService {
+// EraseUser irreversibly anonymizes the user and scrubs its personal data, response contains the erasure receipt
+// @Summary  EraseUser
+// @Param    id path string true "id"
+// @Tags     User
+// @Router   /{id}/erase [post]
+func (s UserSvc) EraseUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	// IP and user agent are not recorded, the erased user may have sent the request
+	requestMeta := audit.MetaFromRequest(c)
+	meta := audit.Meta{ActorID: requestMeta.ActorID, RequestID: requestMeta.RequestID}
+
+	receipt, err := s.users.EraseUser(id, erasure.Receipt{
+		ActorID:   meta.ActorID,
+		RequestID: meta.RequestID,
+		Source:    auditSource,
+	})
+	if err != nil {
+		s.log.WithFields(log.Fields{"method": "EraseUser"}).Errorf("While user is erasing an error occured: %s \n", err)
+		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
+			StatusCode: 400,
+			Message:    fmt.Sprintf("While user is erasing an error occured: %s", err),
+		})
+	}
+
+	s.auditMeta(meta, audit.ActionUserErased, id, nil, nil)
+	err = s.recordMeta(meta, &pb.Events{
+		EventName: pb.EventName_USER_ERASED,
+		Payload:   &pb.Events_EraseUser{EraseUser: &pb.EraseUser{Id: id}},
+	}, id)
+	if err != nil {
+		return s.unrecorded(c, err)
+	}
+	s.log.WithFields(log.Fields{"method": "EraseUser"}).Infof("User successfully erased %v \n", id)
+	return c.Status(fiber.StatusOK).JSON(model.Response{
+		StatusCode: 200,
+		Message:    fmt.Sprintf("User successfully erased %v", id),
+		Data:       receipt,
+	})
+}
+
+// audit records the change to the audit log, a failure is only logged so that the request isn't failed after the change
+func (s UserSvc) audit(c *fiber.Ctx, action string, userID string, before map[string]string, after map[string]string) {
+	s.auditMeta(audit.MetaFromRequest(c), action, userID, before, after)
+}
+
+func (s UserSvc) auditMeta(meta audit.Meta, action string, userID string, before map[string]string, after map[string]string) {
+	if s.audits == nil {
+		return
+	}
+
+	entry, err := audit.NewEntry(action, userID, auditSource, meta, audit.Diff(before, after))
+	if err == nil {
+		err = s.audits.Append(entry)
+	}
+	if err != nil {
+		s.log.WithFields(log.Fields{"method": "audit"}).Errorf("An error occured when writing the audit log %s \n", err)
+	}
+}
+
+// record appends the event of the change with the actor and the meta of the request
+func (s UserSvc) record(c *fiber.Ctx, event *pb.Events, userID string) error {
+	return s.recordMeta(audit.MetaFromRequest(c), event, userID)
+}
+
+func (s UserSvc) recordMeta(meta audit.Meta, event *pb.Events, userID string) error {
+	if s.events == nil {
+		return nil
+	}
+
+	event.AggregateType = pb.AggregateType_USER
+	event.AggregateId = uuid.New().String()
+	event.EventDate = grpcutil.GetTime()
+	event.InternalId = userID
+	event.Actor = meta.ActorID
+	event.Meta = &pb.RequestMeta{Ip: meta.IP, UserAgent: meta.UserAgent, RequestId: meta.RequestID}
+	return s.events.Record(event, userID)
+}
+
+// recordUpdate appends the update and the email and password changes which are derived from it
+func (s UserSvc) recordUpdate(c *fiber.Ctx, id string, update *domain.UpdateUser) error {
+	payload := &pb.UpdateUser{
+		Id:        id,
+		Nickname:  update.NickName,
+		Email:     update.Email,
+		Password:  update.Password,
+		FirstName: update.FirstName,
+		LastName:  update.LastName,
+		Country:   update.Country,
+	}
+	grpcutil.FillUpdateMask(payload)
+
+	event := &pb.Events{EventName: pb.EventName_USER_UPDATED, Payload: &pb.Events_UpdateUser{UpdateUser: payload}}
+	if err := s.record(c, event, id); err != nil {
+		return err
+	}
+
+	for _, derived := range grpcutil.DerivedEvents(update, id, event) {
+		if err := s.events.Record(derived, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unrecorded responds the change whose event couldn't be recorded, the change itself is already applied
+func (s UserSvc) unrecorded(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(model.Response{
+		StatusCode: 500,
+		Message:    apperror.Wrap(err).Message,
+	})
+}
+
+// auditFields returns the fields of the user which are compared in the audit log, password is redacted by the audit log
+func auditFields(user *domain.User) map[string]string {
+	return map[string]string{
+		"nickname":   user.NickName,
+		"email":      user.Email,
+		"password":   user.Password,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"country":    user.Country,
+	}
+}
+
+// NewUserService returns the HTTP user service, events can be nil when the changes are not recorded
+func NewUserService(users domain.UserService, audits audit.Repository, events handler.EventRecorder, log *log.Entry, configs *user.AppConfig) UserService {
 	return &UserSvc{
-		repository: rep,
-		validate:   validate,
-		log:        log,
-		configs:    configs,
+		users:   users,
+		audits:  audits,
+		events:  events,
+		log:     log,
+		configs: configs,
 	}
 }
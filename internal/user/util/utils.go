@@ -1,33 +1,42 @@
 package util
 
 import (
-	"github.com/cemayan/faceit-technical-test/internal/user/model"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	grpcmodel "github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/migrations"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/cemayan/faceit-technical-test/pkg/migrate"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"os"
 )
 
+// MigrateDB applies the pending migrations of the shared database
+// Test databases are reset to an empty schema first
+// The SQL migrations are written for Postgres, the schema of the other databases is created from the models
+// The service exits when the database can't be migrated, it must not run against an unknown schema
 func MigrateDB(db *gorm.DB, log *log.Entry) {
-	if os.Getenv("ENV") == "test" {
-		// ConnectDBForTesting  serves to connect to db for Testing
-		// When DB connection is successful then model migration is started
-		isExist := db.Migrator().HasTable(&model.User{})
-		if isExist {
-			err := db.Migrator().DropTable(&model.User{})
-			if err != nil {
-				return
-			}
-		}
-		err := db.AutoMigrate(&model.User{})
-		if err != nil {
-			return
+	if db.Dialector.Name() != "postgres" {
+		models := []interface{}{&domain.User{}, &grpcmodel.Event{}, &grpcmodel.DeadLetter{}, &grpcmodel.Snapshot{}, &grpcmodel.Export{}, &audit.Entry{}, &erasure.Receipt{}}
+		if err := db.AutoMigrate(models...); err != nil {
+			log.Fatalf("Database couldn't be migrated %s", err.Error())
 		}
 		log.Infoln("Database Migrated")
-	} else {
-		err := db.AutoMigrate(&model.User{})
-		if err != nil {
-			return
+		return
+	}
+
+	migrator := migrate.New(db, migrations.FS, log)
+
+	if os.Getenv("ENV") == "test" {
+		if err := migrator.Reset(); err != nil {
+			log.Fatalf("Database couldn't be reset %s", err.Error())
 		}
-		log.Infoln("Database Migrated")
 	}
+
+	applied, err := migrator.Up(0)
+	if err != nil {
+		log.Fatalf("Database couldn't be migrated %s", err.Error())
+	}
+	log.Infof("Database Migrated, %d migrations are applied", len(applied))
 }
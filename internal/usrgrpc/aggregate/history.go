@@ -0,0 +1,74 @@
+package aggregate
+
+import (
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"strconv"
+	"time"
+)
+
+// FieldChange is a change of a single field, values of the secret fields such as password are not given
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// HistoryEntry is representation of an event which changed the user
+type HistoryEntry struct {
+	Sequence int64         `json:"sequence"`
+	Event    string        `json:"event"`
+	Date     time.Time     `json:"date"`
+	Actor    string        `json:"actor,omitempty"`
+	Changes  []FieldChange `json:"changes"`
+}
+
+// History applies the events to the state and returns the field-level changes of each of them
+// Events which don't change any field, e.g. the email change which is derived from an update, are left out
+func History(state *User, events []*pb.Events) []HistoryEntry {
+	var entries []HistoryEntry
+	for _, event := range events {
+		before := state.Clone()
+		state.Apply(event)
+		if state.Version == before.Version {
+			continue
+		}
+
+		changes := Diff(before, state)
+		if event.EventName == pb.EventName_USER_PASSWORD_CHANGED {
+			changes = append(changes, FieldChange{Field: "password"})
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		entries = append(entries, HistoryEntry{
+			Sequence: event.Sequence,
+			Event:    event.EventName.String(),
+			Date:     time.Unix(event.EventDate, 0).UTC(),
+			Actor:    event.Actor,
+			Changes:  changes,
+		})
+	}
+	return entries
+}
+
+// Diff returns the changed fields between two states of a user
+func Diff(before *User, after *User) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, from string, to string) {
+		if from != to {
+			changes = append(changes, FieldChange{Field: field, From: from, To: to})
+		}
+	}
+
+	add("nickname", before.NickName, after.NickName)
+	add("email", before.Email, after.Email)
+	add("first_name", before.FirstName, after.FirstName)
+	add("last_name", before.LastName, after.LastName)
+	add("country", before.Country, after.Country)
+	add("role", before.Role, after.Role)
+	add("suspended", strconv.FormatBool(before.Suspended), strconv.FormatBool(after.Suspended))
+	add("suspend_reason", before.SuspendReason, after.SuspendReason)
+	add("deleted", strconv.FormatBool(before.Deleted), strconv.FormatBool(after.Deleted))
+	return changes
+}
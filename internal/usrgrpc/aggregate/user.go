@@ -0,0 +1,98 @@
+package aggregate
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+)
+
+// User is the state of a user which is rebuilt from its events
+// Sequence and EventDate belong to the last applied event, Version is the number of applied events
+type User struct {
+	ID            string `json:"id"`
+	NickName      string `json:"nickname"`
+	Email         string `json:"email"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Country       string `json:"country"`
+	Suspended     bool   `json:"suspended"`
+	SuspendReason string `json:"suspend_reason,omitempty"`
+	Role          string `json:"role"`
+	Deleted       bool   `json:"deleted"`
+	Sequence      int64  `json:"sequence"`
+	EventDate     int64  `json:"event_date"`
+	Version       int    `json:"version"`
+}
+
+// NewUser returns the empty state of given user
+func NewUser(id string) *User {
+	return &User{ID: id, Role: util.RoleName(pb.Role_ROLE_USER)}
+}
+
+// Apply folds the given event into the state
+// Events must be applied in sequence order, the ones which are already applied are skipped
+func (u *User) Apply(event *pb.Events) {
+	if event.Sequence != 0 && event.Sequence <= u.Sequence {
+		return
+	}
+
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser:
+		u.NickName = payload.CreateUser.Nickname
+		u.Email = payload.CreateUser.Email
+		u.FirstName = payload.CreateUser.FirstName
+		u.LastName = payload.CreateUser.LastName
+		u.Country = payload.CreateUser.Country
+	case *pb.Events_UpdateUser:
+		u.applyUpdate(payload.UpdateUser)
+	case *pb.Events_DeleteUser:
+		u.Deleted = true
+	case *pb.Events_ChangeEmail:
+		u.Email = payload.ChangeEmail.Email
+	case *pb.Events_SuspendUser:
+		u.Suspended = true
+		u.SuspendReason = payload.SuspendUser.Reason
+	case *pb.Events_ReactivateUser:
+		u.Suspended = false
+		u.SuspendReason = ""
+	case *pb.Events_RestoreUser:
+		u.Deleted = false
+	case *pb.Events_ChangeRole:
+		u.Role = util.RoleName(payload.ChangeRole.Role)
+	case *pb.Events_EraseUser:
+		u.NickName = erasure.Nickname(u.ID)
+		u.Email = erasure.Email(u.ID)
+		u.FirstName, u.LastName, u.Country = "", "", ""
+		u.SuspendReason = ""
+		u.Deleted = true
+	}
+
+	u.Sequence = event.Sequence
+	u.EventDate = event.EventDate
+	u.Version++
+}
+
+// applyUpdate changes only the fields in the update mask
+// Email is changed by the derived USER_EMAIL_CHANGED event as well, applying it twice is harmless
+func (u *User) applyUpdate(payload *pb.UpdateUser) {
+	for _, path := range payload.GetUpdateMask().GetPaths() {
+		switch path {
+		case "nickname":
+			u.NickName = payload.Nickname
+		case "email":
+			u.Email = payload.Email
+		case "first_name":
+			u.FirstName = payload.FirstName
+		case "last_name":
+			u.LastName = payload.LastName
+		case "country":
+			u.Country = payload.Country
+		}
+	}
+}
+
+// Clone returns a copy of the state
+func (u *User) Clone() *User {
+	clone := *u
+	return &clone
+}
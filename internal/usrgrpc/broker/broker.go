@@ -0,0 +1,58 @@
+package broker
+
+import (
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"sync"
+)
+
+// subscriberBuffer is the number of events which can wait for a slow subscriber
+const subscriberBuffer = 256
+
+type EventBroker interface {
+	Publish(event *pb.Events)
+	Subscribe() (<-chan *pb.Events, func())
+}
+
+// A Broker fans out the persisted events to the live subscribers
+// When a subscriber can not keep up, its channel is closed and it has to resume from the event store
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan *pb.Events]struct{}
+}
+
+// Publish sends the given event to all subscribers without blocking
+func (b *Broker) Publish(event *pb.Events) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel for the published events and a function which cancels the subscription
+func (b *Broker) Subscribe() (<-chan *pb.Events, func()) {
+	ch := make(chan *pb.Events, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func NewEventBroker() EventBroker {
+	return &Broker{subscribers: map[chan *pb.Events]struct{}{}}
+}
@@ -0,0 +1,21 @@
+package dto
+
+// ChangePassword is representation of the password change payload
+type ChangePassword struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangeEmail is representation of the email change payload
+type ChangeEmail struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// SuspendUser is representation of the suspend payload
+type SuspendUser struct {
+	Reason string `json:"reason"`
+}
+
+// ChangeRole is representation of the role change payload
+type ChangeRole struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
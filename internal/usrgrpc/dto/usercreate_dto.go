@@ -1,6 +0,0 @@
-package dto
-
-type NewUser struct {
-	Nickname string `json:"nickname"`
-	Email    string `json:"email"`
-}
@@ -0,0 +1,131 @@
+package eventclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "closed"
+}
+
+var (
+	circuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "usrgrpc_event_stream_circuit_state",
+		Help: "State of the circuit breaker in front of gRPC event server (0 closed, 1 half-open, 2 open)",
+	})
+	circuitRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "usrgrpc_event_stream_circuit_rejected_total",
+		Help: "Number of requests which are rejected while the circuit breaker is open",
+	})
+)
+
+// A Breaker stops calling gRPC event server after consecutive failures
+// After cooldown a single trial request is let through, its result closes or reopens the circuit
+type Breaker struct {
+	mu        sync.Mutex
+	state     State
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	trial     bool
+}
+
+// Allow reports whether a request can be sent now
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			circuitRejected.Inc()
+			return false
+		}
+		b.setState(HalfOpen)
+		b.trial = true
+		return true
+	case HalfOpen:
+		if b.trial {
+			circuitRejected.Inc()
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// Success closes the circuit and resets the failures
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+	b.setState(Closed)
+}
+
+// Failure opens the circuit when the threshold is reached or the trial request fails
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(Open)
+	}
+}
+
+// Release lets another trial request through when the result of the current one is unknown
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	circuitState.Set(float64(state))
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
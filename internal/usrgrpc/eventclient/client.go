@@ -0,0 +1,118 @@
+package eventclient
+
+import (
+	"context"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const retryBackoff = 50 * time.Millisecond
+
+// ErrCircuitOpen is returned without calling the event server while the circuit breaker is open
+var ErrCircuitOpen = status.Error(codes.Unavailable, "event server is unavailable, circuit breaker is open")
+
+type EventClient interface {
+	StreamManager
+	SendIdempotent(ctx context.Context, event *pb.Events) (*pb.Response, error)
+	State() State
+}
+
+// A Client sends the events through the circuit breaker
+// Idempotent events are retried with jittered backoff until they succeed, the retries run out or ctx is done
+type Client struct {
+	streams StreamManager
+	breaker *Breaker
+	retries int
+	log     *log.Entry
+}
+
+// Send sends the event once
+func (c *Client) Send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	return c.send(ctx, event)
+}
+
+// SendIdempotent sends the event and retries it when the event server is unavailable
+// Every attempt carries the same correlation id
+// A failed attempt may have been applied before the stream broke, so a retried delete which doesn't find the user has succeeded
+func (c *Client) SendIdempotent(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	if event.CorrelationId == "" {
+		event.CorrelationId = uuid.New().String()
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, event)
+		if err == nil && attempt > 0 && event.EventName == pb.EventName_USER_DELETED && resp.StatusCode == http.StatusNotFound {
+			c.log.WithFields(log.Fields{"method": "SendIdempotent"}).Infof("%s is applied before it is retried", event.CorrelationId)
+			return &pb.Response{StatusCode: http.StatusOK, CorrelationId: event.CorrelationId}, nil
+		}
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return resp, err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		c.log.WithFields(log.Fields{"method": "SendIdempotent"}).Warnf("Retrying %s in %v, attempt %d: %s", event.CorrelationId, wait, attempt+1, err.Error())
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		backoff *= 2
+	}
+}
+
+// State returns the state of the circuit breaker
+func (c *Client) State() State {
+	return c.breaker.State()
+}
+
+// Close closes the underlying streams
+func (c *Client) Close() error {
+	return c.streams.Close()
+}
+
+func (c *Client) send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	if !c.breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := c.streams.Send(ctx, event)
+	switch {
+	case err == nil:
+		c.breaker.Success()
+	case status.Code(err) == codes.Canceled:
+		// The caller is gone, it says nothing about the event server
+		c.breaker.Release()
+	case retryable(err):
+		c.breaker.Failure()
+	default:
+		c.breaker.Success()
+	}
+	return resp, err
+}
+
+// retryable reports whether err is caused by an unreachable or slow event server
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return err != ErrCircuitOpen && err != ErrClosed
+	}
+	return false
+}
+
+// NewEventClient wraps streams with a circuit breaker and a retry policy for the idempotent events
+func NewEventClient(streams StreamManager, breaker *Breaker, retries int, _log *log.Entry) EventClient {
+	return &Client{
+		streams: streams,
+		breaker: breaker,
+		retries: retries,
+		log:     _log,
+	}
+}
@@ -0,0 +1,35 @@
+package eventclient
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type HealthChecker interface {
+	Serving(ctx context.Context) error
+}
+
+// A ServerHealthChecker asks the grpc.health.v1 service of the event server whether it can handle the events
+type ServerHealthChecker struct {
+	client healthpb.HealthClient
+}
+
+// Serving returns nil when the event service is SERVING
+func (h ServerHealthChecker) Serving(ctx context.Context) error {
+	resp, err := h.client.Check(ctx, &healthpb.HealthCheckRequest{Service: pb.EventGrpcService_ServiceDesc.ServiceName})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return status.Error(codes.Unavailable, fmt.Sprintf("event server is %s", resp.Status.String()))
+	}
+	return nil
+}
+
+func NewHealthChecker(client healthpb.HealthClient) HealthChecker {
+	return ServerHealthChecker{client: client}
+}
@@ -0,0 +1,84 @@
+package eventclient
+
+import (
+	"context"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// maxResubscribeBackoff is the longest wait between two subscription attempts
+const maxResubscribeBackoff = 5 * time.Second
+
+// resumeOverlap is the number of sequences below the highest seen one which are subscribed again after the subscription breaks
+// Events may be streamed out of sequence order, it matches the reorder window of gRPC event server
+const resumeOverlap = 256
+
+// An InvalidationListener follows the user events of gRPC event server and evicts the changed users from the cache
+// Users are changed by gRPC event server, so this is how the users cached by other processes are invalidated
+// Subscription is resumed below the highest seen sequence after it breaks, so no late event is missed
+type InvalidationListener struct {
+	client pb.EventGrpcServiceClient
+	users  domain.UserInvalidator
+	log    *log.Entry
+}
+
+// Run follows the events after sequence from until ctx is done
+func (l *InvalidationListener) Run(ctx context.Context, from int64) {
+	backoff := retryBackoff
+	for {
+		highest, err := l.follow(ctx, from)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if highest > from {
+			backoff = retryBackoff
+		}
+		if highest-resumeOverlap > from {
+			from = highest - resumeOverlap
+		}
+		l.log.WithFields(log.Fields{"method": "Run"}).Warnf("Resubscribing from sequence %d in %v: %v", from, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
+
+// follow invalidates the users of the events until the subscription breaks and returns the highest seen sequence
+func (l *InvalidationListener) follow(ctx context.Context, from int64) (int64, error) {
+	stream, err := l.client.SubscribeUserEvents(ctx, &pb.SubscribeRequest{FromSequence: from})
+	if err != nil {
+		return from, err
+	}
+
+	highest := from
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return highest, err
+		}
+
+		if event.InternalId != "" {
+			l.users.Invalidate(event.InternalId)
+		}
+		if event.Sequence > highest {
+			highest = event.Sequence
+		}
+	}
+}
+
+func NewInvalidationListener(client pb.EventGrpcServiceClient, users domain.UserInvalidator, log *log.Entry) *InvalidationListener {
+	return &InvalidationListener{
+		client: client,
+		users:  users,
+		log:    log,
+	}
+}
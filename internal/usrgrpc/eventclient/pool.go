@@ -0,0 +1,270 @@
+package eventclient
+
+import (
+	"context"
+	"errors"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPoolSize = 4
+	minBackoff      = 100 * time.Millisecond
+	maxBackoff      = 5 * time.Second
+)
+
+// ErrClosed is returned when the pool is used after Close
+var ErrClosed = status.Error(codes.Unavailable, "event stream pool is closed")
+
+type StreamManager interface {
+	Send(ctx context.Context, event *pb.Events) (*pb.Response, error)
+	Close() error
+}
+
+// A Pool keeps long-lived HandleEvent streams open and spreads the events over them
+// Responses are matched to the requests by correlation id, so many requests share a stream
+type Pool struct {
+	client  pb.EventGrpcServiceClient
+	streams []*managedStream
+	next    uint32
+	ctx     context.Context
+	cancel  context.CancelFunc
+	log     *log.Entry
+}
+
+// managedStream is a single HandleEvent stream which reconnects with backoff when it breaks
+type managedStream struct {
+	pool *Pool
+
+	sendMu sync.Mutex
+	mu     sync.Mutex
+	stream pb.EventGrpcService_HandleEventClient
+	// pending holds the response channels of the events which are waiting for their response
+	pending map[string]chan *pb.Response
+	// reconnecting is set while the reconnect loop is running
+	reconnecting bool
+}
+
+// Send sends the event over one of the streams and waits for its response
+// Correlation id is assigned when the event has none
+func (p *Pool) Send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	if p.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+
+	if event.CorrelationId == "" {
+		event.CorrelationId = uuid.New().String()
+	}
+
+	i := atomic.AddUint32(&p.next, 1)
+	return p.streams[int(i)%len(p.streams)].send(ctx, event)
+}
+
+// Close closes the send direction of every stream and fails the pending requests
+func (p *Pool) Close() error {
+	p.cancel()
+
+	for _, ms := range p.streams {
+		ms.sendMu.Lock()
+		ms.mu.Lock()
+		if ms.stream != nil {
+			_ = ms.stream.CloseSend()
+			ms.stream = nil
+		}
+		ms.failPending()
+		ms.mu.Unlock()
+		ms.sendMu.Unlock()
+	}
+
+	p.log.WithFields(log.Fields{"method": "Close"}).Infoln("Event streams are closed")
+	return nil
+}
+
+func (ms *managedStream) send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	ch := make(chan *pb.Response, 1)
+
+	ms.mu.Lock()
+	stream := ms.stream
+	if stream == nil {
+		ms.mu.Unlock()
+		ms.reconnect()
+		return nil, status.Error(codes.Unavailable, "event stream is reconnecting")
+	}
+	ms.pending[event.CorrelationId] = ch
+	ms.mu.Unlock()
+
+	ms.sendMu.Lock()
+	err := stream.Send(event)
+	ms.sendMu.Unlock()
+	if err != nil {
+		ms.forget(event.CorrelationId)
+		ms.broken(stream, err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, status.Error(codes.Unavailable, "event stream is closed before the response")
+		}
+		return resp, nil
+	case <-ctx.Done():
+		ms.forget(event.CorrelationId)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// connect opens a new stream which lives until the pool is closed
+func (ms *managedStream) connect() error {
+	stream, err := ms.pool.client.HandleEvent(ms.pool.ctx)
+	if err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	if ms.pool.ctx.Err() != nil {
+		ms.mu.Unlock()
+		_ = stream.CloseSend()
+		return ms.pool.ctx.Err()
+	}
+	ms.stream = stream
+	ms.mu.Unlock()
+
+	go ms.receive(stream)
+	return nil
+}
+
+// receive dispatches the responses to the waiting requests until the stream breaks
+func (ms *managedStream) receive(stream pb.EventGrpcService_HandleEventClient) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			ms.broken(stream, err)
+			return
+		}
+
+		ms.mu.Lock()
+		ch, ok := ms.pending[resp.CorrelationId]
+		delete(ms.pending, resp.CorrelationId)
+		ms.mu.Unlock()
+
+		if !ok {
+			ms.pool.log.WithFields(log.Fields{"method": "receive"}).Warnf("No request is waiting for the response %s", resp.CorrelationId)
+			continue
+		}
+		ch <- resp
+	}
+}
+
+// broken drops the given stream, fails its pending requests and starts reconnecting
+func (ms *managedStream) broken(stream pb.EventGrpcService_HandleEventClient, err error) {
+	ms.mu.Lock()
+	if ms.stream != stream {
+		ms.mu.Unlock()
+		return
+	}
+	ms.stream = nil
+	ms.failPending()
+	ms.mu.Unlock()
+
+	if ms.pool.ctx.Err() != nil {
+		return
+	}
+
+	ms.pool.log.WithFields(log.Fields{"method": "broken"}).Errorf("Event stream is broken %s", err.Error())
+	ms.reconnect()
+}
+
+// reconnect starts the reconnect loop unless it is already running
+// Backoff is doubled after every failed attempt and jittered so that the pods do not reconnect at once
+func (ms *managedStream) reconnect() {
+	ms.mu.Lock()
+	if ms.reconnecting || ms.stream != nil {
+		ms.mu.Unlock()
+		return
+	}
+	ms.reconnecting = true
+	ms.mu.Unlock()
+
+	go func() {
+		defer func() {
+			ms.mu.Lock()
+			ms.reconnecting = false
+			ms.mu.Unlock()
+		}()
+
+		backoff := minBackoff
+		for {
+			err := ms.connect()
+			if err == nil {
+				return
+			}
+			if errors.Is(err, context.Canceled) || ms.pool.ctx.Err() != nil {
+				return
+			}
+
+			ms.pool.log.WithFields(log.Fields{"method": "reconnect"}).Errorf("Couldn't open event stream %s", err.Error())
+
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+			select {
+			case <-time.After(wait):
+			case <-ms.pool.ctx.Done():
+				return
+			}
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+// forget removes the pending request of given correlation id
+func (ms *managedStream) forget(correlationID string) {
+	ms.mu.Lock()
+	delete(ms.pending, correlationID)
+	ms.mu.Unlock()
+}
+
+// failPending closes the channels of the pending requests, ms.mu must be held
+func (ms *managedStream) failPending() {
+	for id, ch := range ms.pending {
+		close(ch)
+		delete(ms.pending, id)
+	}
+}
+
+// NewStreamPool opens size streams to the event server
+// Streams which can not be opened now are opened in the background with backoff
+func NewStreamPool(client pb.EventGrpcServiceClient, size int, _log *log.Entry) StreamManager {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &Pool{
+		client: client,
+		ctx:    ctx,
+		cancel: cancel,
+		log:    _log,
+	}
+
+	for i := 0; i < size; i++ {
+		ms := &managedStream{pool: pool, pending: map[string]chan *pb.Response{}}
+		pool.streams = append(pool.streams, ms)
+		if err := ms.connect(); err != nil {
+			_log.WithFields(log.Fields{"method": "NewStreamPool"}).Errorf("Couldn't open event stream %s", err.Error())
+			ms.reconnect()
+		}
+	}
+
+	return pool
+}
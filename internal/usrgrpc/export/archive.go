@@ -0,0 +1,226 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"strconv"
+	"time"
+)
+
+// eventBatchSize is the number of events which are read at once
+const eventBatchSize = 500
+
+// Profile is the exported representation of a user, password hash is never exported
+type Profile struct {
+	ID            string    `json:"id"`
+	NickName      string    `json:"nickname"`
+	Email         string    `json:"email"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Country       string    `json:"country"`
+	Role          string    `json:"role"`
+	Suspended     bool      `json:"suspended"`
+	SuspendReason string    `json:"suspend_reason"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Manifest describes the files of an archive
+type Manifest struct {
+	UserID      string    `json:"user_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Files       []string  `json:"files"`
+}
+
+type Builder interface {
+	Build(w io.Writer, userID string) error
+}
+
+// An ArchiveBuilder writes all records of a user to a zip archive
+// Each record type is written as JSON and CSV: profile, events and audit entries
+type ArchiveBuilder struct {
+	users  domain.UserRepository
+	events repo.EventRepository
+	audits audit.Repository
+}
+
+// Build writes the archive of given user to w
+func (b ArchiveBuilder) Build(w io.Writer, userID string) error {
+	user, err := b.users.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	manifest := Manifest{UserID: userID, GeneratedAt: time.Now().UTC()}
+
+	steps := []func(*zip.Writer, *Manifest) error{
+		func(zw *zip.Writer, m *Manifest) error { return writeProfile(zw, m, user) },
+		func(zw *zip.Writer, m *Manifest) error { return b.writeEvents(zw, m, userID) },
+		func(zw *zip.Writer, m *Manifest) error { return b.writeAudit(zw, m, userID) },
+	}
+	for _, step := range steps {
+		if err := step(archive, &manifest); err != nil {
+			return err
+		}
+	}
+
+	if err := writeJSON(archive, &manifest, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeProfile(archive *zip.Writer, manifest *Manifest, user *domain.User) error {
+	profile := Profile{
+		ID:            user.ID.String(),
+		NickName:      user.NickName,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Country:       user.Country,
+		Role:          user.Role,
+		Suspended:     user.Suspended,
+		SuspendReason: user.SuspendReason,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+	if err := writeJSON(archive, manifest, "profile.json", profile); err != nil {
+		return err
+	}
+
+	return writeCSV(archive, manifest, "profile.csv",
+		[]string{"id", "nickname", "email", "first_name", "last_name", "country", "role", "suspended", "suspend_reason", "created_at", "updated_at"},
+		[][]string{{
+			profile.ID, profile.NickName, profile.Email, profile.FirstName, profile.LastName, profile.Country, profile.Role,
+			strconv.FormatBool(profile.Suspended), profile.SuspendReason, formatTime(profile.CreatedAt), formatTime(profile.UpdatedAt),
+		}})
+}
+
+func (b ArchiveBuilder) writeEvents(archive *zip.Writer, manifest *Manifest, userID string) error {
+	var events []json.RawMessage
+	var rows [][]string
+
+	filter := repo.EventFilter{UserID: userID}
+	for {
+		batch, err := b.events.GetEvents(filter, eventBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range batch {
+			raw, err := protojson.Marshal(event)
+			if err != nil {
+				return err
+			}
+			events = append(events, raw)
+			rows = append(rows, eventRow(event, string(raw)))
+			filter.FromSequence = event.Sequence
+		}
+
+		if len(batch) < eventBatchSize {
+			break
+		}
+	}
+
+	if events == nil {
+		events = []json.RawMessage{}
+	}
+	if err := writeJSON(archive, manifest, "events.json", events); err != nil {
+		return err
+	}
+	return writeCSV(archive, manifest, "events.csv",
+		[]string{"sequence", "event_name", "event_date", "actor", "ip", "user_agent", "request_id", "event"}, rows)
+}
+
+func eventRow(event *pb.Events, raw string) []string {
+	meta := event.GetMeta()
+	return []string{
+		strconv.FormatInt(event.Sequence, 10),
+		event.EventName.String(),
+		formatTime(time.Unix(event.EventDate, 0)),
+		event.Actor,
+		meta.GetIp(),
+		meta.GetUserAgent(),
+		meta.GetRequestId(),
+		raw,
+	}
+}
+
+func (b ArchiveBuilder) writeAudit(archive *zip.Writer, manifest *Manifest, userID string) error {
+	entries := []audit.EntryData{}
+	var rows [][]string
+
+	err := b.audits.Export(audit.Filter{UserID: userID}, func(entry audit.Entry) error {
+		data, err := entry.Data()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, data)
+		rows = append(rows, []string{
+			strconv.FormatUint(entry.ID, 10), formatTime(entry.CreatedAt), entry.Action, entry.Source,
+			entry.ActorID, entry.IP, entry.UserAgent, entry.RequestID, string(entry.Changes),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSON(archive, manifest, "audit.json", entries); err != nil {
+		return err
+	}
+	return writeCSV(archive, manifest, "audit.csv",
+		[]string{"id", "created_at", "action", "source", "actor_id", "ip", "user_agent", "request_id", "changes"}, rows)
+}
+
+func writeJSON(archive *zip.Writer, manifest *Manifest, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, name)
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeCSV(archive *zip.Writer, manifest *Manifest, name string, header []string, rows [][]string) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, name)
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func NewArchiveBuilder(users domain.UserRepository, events repo.EventRepository, audits audit.Repository) Builder {
+	return &ArchiveBuilder{
+		users:  users,
+		events: events,
+		audits: audits,
+	}
+}
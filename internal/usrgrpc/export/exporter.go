@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const defaultExportTTL = 24 * time.Hour
+
+// cleanupInterval is the interval of the deletion of the expired exports
+const cleanupInterval = time.Hour
+
+// heartbeatInterval is the interval of renewing the heartbeat of an export while its archive is built
+const heartbeatInterval = 30 * time.Second
+
+// leaseTimeout is the time after the last heartbeat which a pending export is considered interrupted after
+// Exports of the other instances keep their heartbeat, so only the exports whose build is lost time out
+const leaseTimeout = 4 * heartbeatInterval
+
+// interruptedReason is the error of the exports whose builds are lost
+const interruptedReason = "export is interrupted, it can be started again"
+
+type Exporter interface {
+	Start(userID string) (*model.Export, error)
+	Run(ctx context.Context)
+}
+
+// A JobExporter builds the archives in the background, so that large histories don't block the request
+// An export which is in progress or finished within the TTL is reused instead of building the same archive again
+type JobExporter struct {
+	mu      sync.Mutex
+	builder Builder
+	jobs    repo.ExportRepository
+	ttl     time.Duration
+	log     *log.Entry
+}
+
+// Start returns the export of given user which is pending or finished within the TTL
+// Otherwise it adds a pending export and builds its archive in the background
+func (e *JobExporter) Start(userID string) (*model.Export, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	job, err := e.jobs.Reusable(userID, now.Add(-e.ttl), now.Add(-leaseTimeout))
+	if err != nil || job != nil {
+		return job, err
+	}
+
+	job, err = e.jobs.Create(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	go e.run(job.ID, userID)
+	return job, nil
+}
+
+// Run fails the interrupted exports and deletes the expired exports periodically until ctx is done
+// Archives are only built by the process which started them, a pending export whose heartbeat is older than the lease is never finished
+func (e *JobExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if failed, err := e.jobs.FailStale(time.Now().Add(-leaseTimeout), interruptedReason); err != nil {
+			e.log.WithFields(log.Fields{"method": "Run"}).Errorf("An error occurred when failing the interrupted exports %s", err.Error())
+		} else if failed > 0 {
+			e.log.WithFields(log.Fields{"method": "Run"}).Infof("%d interrupted exports are failed", failed)
+		}
+
+		if deleted, err := e.jobs.DeleteFinished(time.Now().Add(-e.ttl)); err != nil {
+			e.log.WithFields(log.Fields{"method": "Run"}).Errorf("An error occurred when deleting the expired exports %s", err.Error())
+		} else if deleted > 0 {
+			e.log.WithFields(log.Fields{"method": "Run"}).Infof("%d expired exports are deleted", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *JobExporter) run(id string, userID string) {
+	done := make(chan struct{})
+	defer close(done)
+	go e.heartbeat(id, done)
+
+	var archive bytes.Buffer
+	if err := e.builder.Build(&archive, userID); err != nil {
+		e.log.WithFields(log.Fields{"method": "Start"}).Errorf("An error occurred when exporting %s %s", userID, err.Error())
+		if err := e.jobs.Fail(id, err.Error()); err != nil {
+			e.log.WithFields(log.Fields{"method": "Start"}).Errorf("An error occurred when saving the export %s", err.Error())
+		}
+		return
+	}
+
+	if err := e.jobs.Complete(id, archive.Bytes()); err != nil {
+		e.log.WithFields(log.Fields{"method": "Start"}).Errorf("An error occurred when saving the export %s", err.Error())
+	}
+}
+
+// heartbeat renews the heartbeat of the export until done is closed
+func (e *JobExporter) heartbeat(id string, done <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.jobs.Heartbeat(id); err != nil {
+				e.log.WithFields(log.Fields{"method": "Start"}).Errorf("An error occurred when renewing the export %s", err.Error())
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// NewJobExporter returns the exporter whose exports are kept for ttl after they are finished
+func NewJobExporter(builder Builder, jobs repo.ExportRepository, ttl time.Duration, log *log.Entry) Exporter {
+	if ttl <= 0 {
+		ttl = defaultExportTTL
+	}
+	return &JobExporter{
+		builder: builder,
+		jobs:    jobs,
+		ttl:     ttl,
+		log:     log,
+	}
+}
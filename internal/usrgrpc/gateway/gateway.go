@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"context"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"net/http"
+	"strings"
+)
+
+// NewGatewayHandler returns the REST gateway of UserService which is generated from the HTTP annotations in protos/user/user.proto
+// Requests are passed to userServer in-process, the OpenAPI document is served on /v1/swagger.json
+// X-Actor and X-Request-Id headers are passed as metadata, so the events are attributed to them
+func NewGatewayHandler(ctx context.Context, userServer pbuser.UserServiceServer, swaggerJSON []byte) (http.Handler, error) {
+	gwMux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(headerMatcher))
+	if err := pbuser.RegisterUserServiceHandlerServer(ctx, gwMux, userServer); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", gwMux)
+	mux.HandleFunc("/v1/swagger.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(swaggerJSON)
+	})
+
+	return mux, nil
+}
+
+// headerMatcher passes X-Actor and X-Request-Id besides the default headers
+func headerMatcher(key string) (string, bool) {
+	switch {
+	case strings.EqualFold(key, "X-Actor"):
+		return "x-actor", true
+	case strings.EqualFold(key, "X-Request-Id"):
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gorm.io/gorm"
+	"time"
+)
+
+const defaultHealthInterval = 5 * time.Second
+
+type HealthWatcher interface {
+	Watch(ctx context.Context)
+}
+
+// A DatabaseHealthWatcher pings Postgres periodically and reports the result with grpc.health.v1
+// Services are NOT_SERVING while Postgres is unreachable
+type DatabaseHealthWatcher struct {
+	db       *gorm.DB
+	server   *health.Server
+	interval time.Duration
+	log      *log.Entry
+}
+
+// Watch checks the database until ctx is done
+func (w DatabaseHealthWatcher) Watch(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.check(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			w.check(ctx)
+		case <-ctx.Done():
+			w.server.Shutdown()
+			return
+		}
+	}
+}
+
+func (w DatabaseHealthWatcher) check(ctx context.Context) {
+	servingStatus := healthpb.HealthCheckResponse_SERVING
+	if err := w.ping(ctx); err != nil {
+		w.log.WithFields(log.Fields{"method": "Watch"}).Errorf("Database is unreachable %s", err.Error())
+		servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	for _, service := range []string{"", pb.EventGrpcService_ServiceDesc.ServiceName, pbuser.UserService_ServiceDesc.ServiceName} {
+		w.server.SetServingStatus(service, servingStatus)
+	}
+}
+
+func (w DatabaseHealthWatcher) ping(ctx context.Context) error {
+	sqlDB, err := w.db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.interval)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+func NewDatabaseHealthWatcher(db *gorm.DB, server *health.Server, interval time.Duration, log *log.Entry) HealthWatcher {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	return DatabaseHealthWatcher{db: db, server: server, interval: interval, log: log}
+}
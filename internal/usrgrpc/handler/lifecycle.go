@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+)
+
+// isLifecycleEvent reports whether the event changes a single attribute of the user
+func isLifecycleEvent(eventName pb.EventName) bool {
+	switch eventName {
+	case pb.EventName_USER_PASSWORD_CHANGED, pb.EventName_USER_EMAIL_CHANGED, pb.EventName_USER_SUSPENDED,
+		pb.EventName_USER_REACTIVATED, pb.EventName_USER_RESTORED, pb.EventName_USER_ROLE_CHANGED:
+		return true
+	}
+	return false
+}
+
+// applyLifecycle applies the lifecycle event to the user and returns the changed user
+// The payload must match event_name, its id falls back to InternalId
+func applyLifecycle(users domain.UserService, event *pb.Events) (*domain.User, error) {
+	id := payloadUserID(event)
+	if id == "" {
+		id = event.InternalId
+	}
+
+	switch event.EventName {
+	case pb.EventName_USER_PASSWORD_CHANGED:
+		payload := event.GetChangePassword()
+		if payload == nil {
+			return nil, missingPayload("change_password")
+		}
+		return users.ChangePassword(id, payload.Password)
+	case pb.EventName_USER_EMAIL_CHANGED:
+		payload := event.GetChangeEmail()
+		if payload == nil {
+			return nil, missingPayload("change_email")
+		}
+		return users.ChangeEmail(id, payload.Email)
+	case pb.EventName_USER_SUSPENDED:
+		if event.GetSuspendUser() == nil {
+			return nil, missingPayload("suspend_user")
+		}
+		return users.SuspendUser(id, event.GetSuspendUser().Reason)
+	case pb.EventName_USER_REACTIVATED:
+		if event.GetReactivateUser() == nil {
+			return nil, missingPayload("reactivate_user")
+		}
+		return users.ReactivateUser(id)
+	case pb.EventName_USER_RESTORED:
+		if event.GetRestoreUser() == nil {
+			return nil, missingPayload("restore_user")
+		}
+		return users.RestoreUser(id)
+	case pb.EventName_USER_ROLE_CHANGED:
+		payload := event.GetChangeRole()
+		if payload == nil {
+			return nil, missingPayload("change_role")
+		}
+		if _, ok := pb.Role_name[int32(payload.Role)]; !ok {
+			return nil, invalidArgument("role", "unknown role")
+		}
+		return users.ChangeRole(id, util.RoleName(payload.Role))
+	}
+
+	return nil, apperror.New(apperror.InvalidArgument, apperror.ReasonMalformedPayload, "unknown event_name "+event.EventName.String())
+}
+
+// payloadUserID returns the user id in the payload of the event
+func payloadUserID(event *pb.Events) string {
+	switch payload := event.Payload.(type) {
+	case *pb.Events_UpdateUser:
+		return payload.UpdateUser.Id
+	case *pb.Events_DeleteUser:
+		return payload.DeleteUser.Id
+	case *pb.Events_ChangePassword:
+		return payload.ChangePassword.Id
+	case *pb.Events_ChangeEmail:
+		return payload.ChangeEmail.Id
+	case *pb.Events_SuspendUser:
+		return payload.SuspendUser.Id
+	case *pb.Events_ReactivateUser:
+		return payload.ReactivateUser.Id
+	case *pb.Events_RestoreUser:
+		return payload.RestoreUser.Id
+	case *pb.Events_ChangeRole:
+		return payload.ChangeRole.Id
+	case *pb.Events_EraseUser:
+		return payload.EraseUser.Id
+	}
+	return ""
+}
+
+// setPayloadUserID sets the user id of the payload, creates get it once the user is created
+func setPayloadUserID(event *pb.Events, userID string) {
+	switch payload := event.Payload.(type) {
+	case *pb.Events_UpdateUser:
+		payload.UpdateUser.Id = userID
+	case *pb.Events_DeleteUser:
+		payload.DeleteUser.Id = userID
+	case *pb.Events_ChangePassword:
+		payload.ChangePassword.Id = userID
+	case *pb.Events_ChangeEmail:
+		payload.ChangeEmail.Id = userID
+	case *pb.Events_SuspendUser:
+		payload.SuspendUser.Id = userID
+	case *pb.Events_ReactivateUser:
+		payload.ReactivateUser.Id = userID
+	case *pb.Events_RestoreUser:
+		payload.RestoreUser.Id = userID
+	case *pb.Events_ChangeRole:
+		payload.ChangeRole.Id = userID
+	case *pb.Events_EraseUser:
+		payload.EraseUser.Id = userID
+	}
+}
+
+func missingPayload(name string) error {
+	return apperror.New(apperror.InvalidArgument, apperror.ReasonMalformedPayload, name+" payload is required")
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/aggregate"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"sync"
+)
+
+type EventRecorder interface {
+	Record(event *pb.Events, userID string) error
+}
+
+// A Recorder appends and publishes events one at a time
+// Subscribers rely on receiving live events in sequence order, so concurrent workers must not interleave here
+type Recorder struct {
+	mu         sync.Mutex
+	eventRepo  repo.EventRepository
+	broker     broker.EventBroker
+	aggregates repo.UserAggregateRepository
+	audits     audit.Repository
+	log        *logrus.Logger
+}
+
+// auditSource is the source of the audit entries which are recorded by gRPC event server
+const auditSource = "grpcsrv"
+
+// Record appends the applied event to the event store and publishes it to the subscribers
+// Password is cleared before the event is stored so that it is never persisted
+// The change is written to the audit log with the diff of the user state before and after the event
+// A snapshot of the user is saved once enough events are appended after its latest snapshot
+// Error is returned when the event couldn't be appended, the change is already applied to the user in that case
+func (r *Recorder) Record(event *pb.Events, userID string) error {
+	event = proto.Clone(event).(*pb.Events)
+	event.InternalId = userID
+	event.SchemaVersion = schema.CurrentVersion
+	setPayloadUserID(event, userID)
+	util.ClearPasswords(event)
+
+	after, err := r.append(event, userID)
+	if err != nil {
+		r.log.WithFields(logrus.Fields{"method": "Record"}).Errorf("An error occurred when appending the event %s of the user %s %s", event.EventName, userID, err.Error())
+		return apperror.New(apperror.Internal, apperror.ReasonEventNotRecorded, "user is changed but its event couldn't be recorded")
+	}
+
+	if after == nil {
+		return nil
+	}
+
+	if _, err := r.aggregates.Snapshot(after); err != nil {
+		r.log.WithFields(logrus.Fields{"method": "Record"}).Errorf("An error occurred when saving the snapshot %s", err.Error())
+	}
+	return nil
+}
+
+// append stores, publishes and audits the event while holding the lock
+// The user is loaded once under the same lock, so the audited diff is against the state right before the event
+// It returns the state of the user after the event, it is nil when the state can't be loaded
+func (r *Recorder) append(event *pb.Events, userID string) (*aggregate.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := r.stateBefore(userID)
+
+	stored, err := r.eventRepo.Append(event)
+	if err != nil {
+		return nil, err
+	}
+	r.broker.Publish(stored)
+
+	var after *aggregate.User
+	var entries []aggregate.HistoryEntry
+	if before != nil {
+		after = before.Clone()
+		entries = aggregate.History(after, []*pb.Events{stored})
+	}
+
+	if r.audits != nil {
+		r.audit(before, entries, stored)
+	}
+	return after, nil
+}
+
+// stateBefore returns the state of the user before the event, it is nil when the state can't be loaded
+func (r *Recorder) stateBefore(userID string) *aggregate.User {
+	if r.aggregates == nil || userID == "" {
+		return nil
+	}
+
+	state, err := r.aggregates.Load(userID)
+	if err == nil {
+		return state
+	}
+	if apperror.Wrap(err).Kind == apperror.NotFound {
+		return aggregate.NewUser(userID)
+	}
+
+	r.log.WithFields(logrus.Fields{"method": "Record"}).Errorf("An error occurred when loading the user for the audit log %s", err.Error())
+	return nil
+}
+
+// audit records the event with the changed fields
+// Events which don't change anything, e.g. the email change which is derived from an update, are not recorded
+// Erasures are always recorded, their state before is already scrubbed
+func (r *Recorder) audit(before *aggregate.User, entries []aggregate.HistoryEntry, stored *pb.Events) {
+	var changes []audit.Change
+	if before != nil {
+		if len(entries) == 0 && stored.EventName != pb.EventName_USER_ERASED {
+			return
+		}
+		for _, entry := range entries {
+			for _, change := range entry.Changes {
+				changes = append(changes, audit.Change{Field: change.Field, From: change.From, To: change.To})
+			}
+		}
+	}
+
+	meta := audit.Meta{
+		ActorID:   stored.Actor,
+		IP:        stored.GetMeta().GetIp(),
+		UserAgent: stored.GetMeta().GetUserAgent(),
+		RequestID: stored.GetMeta().GetRequestId(),
+	}
+
+	entry, err := audit.NewEntry(stored.EventName.String(), stored.InternalId, auditSource, meta, changes)
+	if err == nil {
+		err = r.audits.Append(entry)
+	}
+	if err != nil {
+		r.log.WithFields(logrus.Fields{"method": "Record"}).Errorf("An error occurred when writing the audit log %s", err.Error())
+	}
+}
+
+// NewEventRecorder returns the recorder of the applied events
+// aggregates can be nil to disable the snapshots and audits can be nil to disable the audit log
+func NewEventRecorder(eventRepo repo.EventRepository, broker broker.EventBroker, aggregates repo.UserAggregateRepository, audits audit.Repository, log *logrus.Logger) EventRecorder {
+	return &Recorder{
+		eventRepo:  eventRepo,
+		broker:     broker,
+		aggregates: aggregates,
+		audits:     audits,
+		log:        log,
+	}
+}
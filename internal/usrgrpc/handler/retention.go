@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const defaultPurgeInterval = time.Hour
+
+// purgeBatchSize is the number of users which are removed at once
+const purgeBatchSize = 100
+
+// retentionActor is the actor of the audit entries of the purged users
+const retentionActor = "retention"
+
+type TrashPurger interface {
+	Run(ctx context.Context)
+	Purge() (int, error)
+}
+
+// A RetentionPurger permanently removes the users which are deleted longer than the retention period
+// Every purged user is recorded to the audit log
+type RetentionPurger struct {
+	userRepo  domain.UserRepository
+	audits    audit.Repository
+	retention time.Duration
+	interval  time.Duration
+	log       *log.Entry
+}
+
+// Run purges the users periodically until ctx is done
+func (p RetentionPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.Purge(); err != nil {
+			p.log.WithFields(log.Fields{"method": "Run"}).Errorf("An error occurred when purging the deleted users %s", err.Error())
+		} else if purged > 0 {
+			p.log.WithFields(log.Fields{"method": "Run"}).Infof("%d deleted users are purged", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Purge removes the users which are deleted before the retention period and returns their count
+func (p RetentionPurger) Purge() (int, error) {
+	before := time.Now().Add(-p.retention)
+
+	purged := 0
+	for {
+		ids, err := p.userRepo.PurgeDeletedUsers(before, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+
+		for _, id := range ids {
+			p.audit(id)
+		}
+
+		if len(ids) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (p RetentionPurger) audit(userID string) {
+	if p.audits == nil {
+		return
+	}
+
+	entry, err := audit.NewEntry(audit.ActionUserPurged, userID, auditSource, audit.Meta{ActorID: retentionActor}, nil)
+	if err == nil {
+		err = p.audits.Append(entry)
+	}
+	if err != nil {
+		p.log.WithFields(log.Fields{"method": "Purge"}).Errorf("An error occurred when writing the audit log %s", err.Error())
+	}
+}
+
+// NewTrashPurger returns the purger of the users which are deleted more than retentionDays ago
+func NewTrashPurger(userRepo domain.UserRepository, audits audit.Repository, retentionDays int, interval time.Duration, log *log.Entry) TrashPurger {
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+	return RetentionPurger{
+		userRepo:  userRepo,
+		audits:    audits,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+		interval:  interval,
+		log:       log,
+	}
+}
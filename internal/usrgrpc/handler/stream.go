@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+// workerQueueSize is the number of events which can wait for a busy worker
+const workerQueueSize = 16
+
+type EventStreamHandler interface {
+	Serve(stream pb.EventGrpcService_HandleEventServer) error
+}
+
+// A StreamHandler processes the events of HandleEvent streams
+// Every stream gets its own UserHandler so that concurrent streams do not share any state
+// When workers is greater than 1 the events of a stream are processed concurrently,
+// events of the same aggregate always go to the same worker to keep their order
+type StreamHandler struct {
+	users       domain.UserService
+	recorder    EventRecorder
+	deadLetters repo.DeadLetterRepository
+	workers     int
+	log         *logrus.Logger
+}
+
+// syncSender serializes Send calls since a gRPC stream is not safe for concurrent sends
+type syncSender struct {
+	mu     sync.Mutex
+	stream pb.EventGrpcService_HandleEventServer
+}
+
+func (s *syncSender) Send(response *pb.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(response)
+}
+
+// Serve consumes the stream until the client closes it
+func (sh StreamHandler) Serve(stream pb.EventGrpcService_HandleEventServer) error {
+	userHandler := NewUserEventHandler(sh.users, sh.recorder, &syncSender{stream: stream}, sh.deadLetters, sh.log)
+
+	if sh.workers <= 1 {
+		for {
+			event, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				sh.log.WithFields(logrus.Fields{"method": "Serve"}).Errorf("An error occured %s", err.Error())
+				return err
+			}
+			sh.handle(userHandler, event)
+		}
+	}
+
+	var wg sync.WaitGroup
+	queues := make([]chan *pb.Events, sh.workers)
+	for i := range queues {
+		queues[i] = make(chan *pb.Events, workerQueueSize)
+		wg.Add(1)
+		go func(queue chan *pb.Events) {
+			defer wg.Done()
+			for event := range queue {
+				sh.handle(userHandler, event)
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			sh.log.WithFields(logrus.Fields{"method": "Serve"}).Errorf("An error occured %s", err.Error())
+			return err
+		}
+		queues[sh.partition(event)] <- event
+	}
+}
+
+func (sh StreamHandler) handle(userHandler UserEventHandler, event *pb.Events) {
+	switch event.AggregateType {
+	case pb.AggregateType_USER:
+		err := userHandler.Handle(event)
+		if err != nil {
+			sh.log.WithFields(logrus.Fields{"method": "Serve"}).Errorf("An error occured %s", err.Error())
+		}
+	}
+}
+
+// partition returns the worker of the event based on its aggregate
+// Creates have no user id yet, so they are spread by their aggregate id
+func (sh StreamHandler) partition(event *pb.Events) int {
+	key := aggregateKey(event)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(sh.workers))
+}
+
+func aggregateKey(event *pb.Events) string {
+	if id := payloadUserID(event); id != "" {
+		return id
+	}
+
+	if event.InternalId != "" {
+		return event.InternalId
+	}
+	return event.AggregateId
+}
+
+func NewEventStreamHandler(users domain.UserService, recorder EventRecorder, deadLetters repo.DeadLetterRepository, workers int, log *logrus.Logger) EventStreamHandler {
+	return &StreamHandler{
+		users:       users,
+		recorder:    recorder,
+		deadLetters: deadLetters,
+		workers:     workers,
+		log:         log,
+	}
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// replayBatchSize is the number of stored events which are read at once while replaying
+const replayBatchSize = 100
+
+// reorderWindow is the number of sequences below the highest sent one which are still checked for late events
+const reorderWindow = 256
+
+// catchUpInterval is the interval of reading the event store for the events which are not published to this server
+const catchUpInterval = 5 * time.Second
+
+type UserEventSubscriber interface {
+	Subscribe(req *pb.SubscribeRequest, stream pb.EventGrpcService_SubscribeUserEventsServer) error
+}
+
+type SubscriptionHandler struct {
+	eventRepo repo.EventRepository
+	broker    broker.EventBroker
+	log       *logrus.Logger
+}
+
+// delivery keeps the sequences which are sent to a subscriber
+// Sequences are allocated before the events are committed, so an event may become visible after the events with higher sequences,
+// e.g. when it is appended by another process. Such late events are sent once as long as they are within the reorder window
+type delivery struct {
+	from    int64
+	highest int64
+	latest  int64
+	sent    map[int64]struct{}
+}
+
+// floor returns the sequence which all late events are expected to be above, it is the resume point of the subscriber
+func (d *delivery) floor() int64 {
+	if floor := d.highest - reorderWindow; floor > d.from {
+		return floor
+	}
+	return d.from
+}
+
+// pending reports whether the event with given sequence is not sent yet
+func (d *delivery) pending(sequence int64) bool {
+	if sequence <= d.floor() {
+		return false
+	}
+	_, ok := d.sent[sequence]
+	return !ok
+}
+
+func (d *delivery) markSent(sequence int64) {
+	d.sent[sequence] = struct{}{}
+	if sequence > d.highest {
+		d.highest = sequence
+	}
+
+	if len(d.sent) > 2*reorderWindow {
+		floor := d.floor()
+		for seq := range d.sent {
+			if seq <= floor {
+				delete(d.sent, seq)
+			}
+		}
+	}
+}
+
+// observe records the sequence of a live event and reports whether the events before it are missing from the live stream
+func (d *delivery) observe(sequence int64) bool {
+	gap := d.latest != 0 && sequence > d.latest+1
+	if sequence > d.latest {
+		d.latest = sequence
+	}
+	return gap
+}
+
+// Subscribe streams the user events which are matched with the request
+// Stored events after FromSequence are replayed first, then live events are streamed
+// Live subscription is opened before the replay so that no event is lost in between
+// Events which are missing from the live stream are read from the event store, so subscribers must accept the events out of sequence order
+func (sh SubscriptionHandler) Subscribe(req *pb.SubscribeRequest, stream pb.EventGrpcService_SubscribeUserEventsServer) error {
+	live, cancel := sh.broker.Subscribe()
+	defer cancel()
+
+	sent := &delivery{from: req.FromSequence, sent: map[int64]struct{}{}}
+	if err := sh.catchUp(req, sent, stream); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(catchUpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+			if err := sh.catchUp(req, sent, stream); err != nil {
+				return err
+			}
+		case event, ok := <-live:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "subscriber fell behind, resume from sequence %d", sent.floor())
+			}
+
+			gap := sent.observe(event.Sequence)
+			if sent.pending(event.Sequence) && matches(req, event) {
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+				sent.markSent(event.Sequence)
+			}
+
+			if gap {
+				if err := sh.catchUp(req, sent, stream); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// catchUp sends the stored events above the floor which are not sent yet
+func (sh SubscriptionHandler) catchUp(req *pb.SubscribeRequest, sent *delivery, stream pb.EventGrpcService_SubscribeUserEventsServer) error {
+	filter := repo.EventFilter{
+		FromSequence: sent.floor(),
+		EventNames:   req.EventNames,
+		UserID:       req.UserId,
+	}
+
+	for {
+		events, err := sh.eventRepo.GetEvents(filter, replayBatchSize)
+		if err != nil {
+			sh.log.WithFields(logrus.Fields{"method": "Subscribe"}).Errorf("An error occurred when replaying events %s", err.Error())
+			return status.Error(codes.Internal, "events could not be replayed")
+		}
+
+		for _, event := range events {
+			if sent.pending(event.Sequence) {
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+				sent.markSent(event.Sequence)
+			}
+			filter.FromSequence = event.Sequence
+		}
+
+		if len(events) < replayBatchSize {
+			return nil
+		}
+	}
+}
+
+// matches reports whether the event passes the filters of the request
+func matches(req *pb.SubscribeRequest, event *pb.Events) bool {
+	if req.UserId != "" && req.UserId != event.InternalId {
+		return false
+	}
+
+	if len(req.EventNames) == 0 {
+		return true
+	}
+
+	for _, name := range req.EventNames {
+		if name == event.EventName {
+			return true
+		}
+	}
+	return false
+}
+
+func NewSubscriptionHandler(eventRepo repo.EventRepository, broker broker.EventBroker, log *logrus.Logger) UserEventSubscriber {
+	return &SubscriptionHandler{
+		eventRepo: eventRepo,
+		broker:    broker,
+		log:       log,
+	}
+}
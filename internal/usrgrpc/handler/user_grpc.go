@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// Metadata keys of the request which are recorded in the events for the audit log
+// The REST gateway passes user-agent and the client address as grpcgateway-user-agent and x-forwarded-for
+const (
+	actorKey            = "x-actor"
+	requestIDKey        = "x-request-id"
+	forwardedForKey     = "x-forwarded-for"
+	userAgentKey        = "user-agent"
+	gatewayUserAgentKey = "grpcgateway-user-agent"
+)
+
+// A UserGrpcServer serves the unary user API over the same user service as the event stream
+// Every write is recorded to the event store like the streamed events
+type UserGrpcServer struct {
+	pbuser.UnimplementedUserServiceServer
+	users    domain.UserService
+	recorder EventRecorder
+	log      *logrus.Logger
+}
+
+// GetUser returns user based on given id
+func (us UserGrpcServer) GetUser(ctx context.Context, req *pbuser.GetUserRequest) (*pb.User, error) {
+	user, err := us.users.GetUser(req.Id)
+	if err != nil {
+		return nil, us.toStatus("GetUser", err)
+	}
+
+	return util.ToProtoUser(user), nil
+}
+
+// ListUsers returns filtered, sorted and paginated users
+func (us UserGrpcServer) ListUsers(ctx context.Context, req *pbuser.ListUsersRequest) (*pbuser.ListUsersResponse, error) {
+	page, err := decodePageToken(req.PageToken)
+	if err != nil {
+		return nil, invalidArgument("page_token", "invalid page token")
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize < 0 {
+		return nil, invalidArgument("page_size", "can not be negative")
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	if _, ok := pbuser.SortField_name[int32(req.SortField)]; !ok {
+		return nil, invalidArgument("sort_field", "unknown sort field")
+	}
+	// emails are encrypted, their order in db is meaningless
+	if req.SortField == pbuser.SortField_SORT_FIELD_EMAIL {
+		return nil, invalidArgument("sort_field", "email can not be sorted, it is encrypted")
+	}
+
+	pagination := common.Pagination{
+		Limit:      pageSize,
+		Page:       page,
+		SColumn:    common.SortingColumnName(req.SortField),
+		SType:      common.ASC,
+		Conditions: map[string]interface{}{},
+	}
+	if req.Descending {
+		pagination.SType = common.DESC
+	}
+
+	if filter := req.Filter; filter != nil {
+		if filter.Country != "" {
+			pagination.Conditions["country"] = filter.Country
+		}
+		if filter.Nickname != "" {
+			pagination.Conditions["nick_name"] = filter.Nickname
+		}
+		if filter.Email != "" {
+			pagination.Conditions["email"] = filter.Email
+		}
+	}
+
+	result, err := us.users.ListUsers(pagination)
+	if err != nil {
+		return nil, us.toStatus("ListUsers", err)
+	}
+
+	users, _ := result.Rows.([]domain.User)
+	resp := &pbuser.ListUsersResponse{TotalSize: result.TotalRows}
+	for i := range users {
+		resp.Users = append(resp.Users, util.ToProtoUser(&users[i]))
+	}
+
+	if page < result.TotalPages {
+		resp.NextPageToken = encodePageToken(page + 1)
+	}
+
+	return resp, nil
+}
+
+// CreateUser creates new user based on given payload
+func (us UserGrpcServer) CreateUser(ctx context.Context, req *pb.CreateUser) (*pb.User, error) {
+	user := &domain.User{
+		NickName:  req.Nickname,
+		Email:     req.Email,
+		Password:  req.Password,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Country:   req.Country,
+	}
+
+	createUser, err := us.users.CreateUser(user)
+	if err != nil {
+		return nil, us.toStatus("CreateUser", err)
+	}
+
+	err = us.recorder.Record(stamp(ctx, &pb.Events{
+		AggregateId: uuid.New().String(),
+		EventDate:   util.GetTime(),
+		EventName:   pb.EventName_USER_CREATED,
+		Payload:     &pb.Events_CreateUser{CreateUser: req},
+	}), createUser.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToProtoUser(createUser), nil
+}
+
+// UpdateUser changes the fields in update_mask and returns the updated user
+func (us UserGrpcServer) UpdateUser(ctx context.Context, req *pb.UpdateUser) (*pb.User, error) {
+	if _, err := uuid.Parse(req.Id); err != nil {
+		return nil, apperror.InvalidID(req.Id)
+	}
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return nil, invalidArgument("update_mask", "is required")
+	}
+
+	update := toUpdate(req)
+	user, err := us.users.UpdateUser(req.Id, update)
+	if err != nil {
+		return nil, us.toStatus("UpdateUser", err)
+	}
+
+	event := stamp(ctx, &pb.Events{
+		AggregateId: uuid.New().String(),
+		EventDate:   util.GetTime(),
+		EventName:   pb.EventName_USER_UPDATED,
+		InternalId:  req.Id,
+		Payload:     &pb.Events_UpdateUser{UpdateUser: req},
+	})
+	for _, recorded := range append([]*pb.Events{event}, util.DerivedEvents(update, req.Id, event)...) {
+		if err := us.recorder.Record(recorded, req.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return util.ToProtoUser(user), nil
+}
+
+// DeleteUser removes the user based on given id
+func (us UserGrpcServer) DeleteUser(ctx context.Context, req *pb.DeleteUser) (*emptypb.Empty, error) {
+	if err := us.users.DeleteUser(req.Id); err != nil {
+		return nil, us.toStatus("DeleteUser", err)
+	}
+
+	err := us.recorder.Record(stamp(ctx, &pb.Events{
+		AggregateId: uuid.New().String(),
+		EventDate:   util.GetTime(),
+		EventName:   pb.EventName_USER_DELETED,
+		InternalId:  req.Id,
+		Payload:     &pb.Events_DeleteUser{DeleteUser: req},
+	}), req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ChangePassword replaces the password of the user
+func (us UserGrpcServer) ChangePassword(ctx context.Context, req *pb.ChangePassword) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "ChangePassword", &pb.Events{
+		EventName: pb.EventName_USER_PASSWORD_CHANGED,
+		Payload:   &pb.Events_ChangePassword{ChangePassword: req},
+	})
+}
+
+// ChangeEmail replaces the email of the user
+func (us UserGrpcServer) ChangeEmail(ctx context.Context, req *pb.ChangeEmail) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "ChangeEmail", &pb.Events{
+		EventName: pb.EventName_USER_EMAIL_CHANGED,
+		Payload:   &pb.Events_ChangeEmail{ChangeEmail: req},
+	})
+}
+
+// SuspendUser suspends an active user
+func (us UserGrpcServer) SuspendUser(ctx context.Context, req *pb.SuspendUser) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "SuspendUser", &pb.Events{
+		EventName: pb.EventName_USER_SUSPENDED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: req},
+	})
+}
+
+// ReactivateUser reactivates a suspended user
+func (us UserGrpcServer) ReactivateUser(ctx context.Context, req *pb.ReactivateUser) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "ReactivateUser", &pb.Events{
+		EventName: pb.EventName_USER_REACTIVATED,
+		Payload:   &pb.Events_ReactivateUser{ReactivateUser: req},
+	})
+}
+
+// RestoreUser brings back a deleted user
+func (us UserGrpcServer) RestoreUser(ctx context.Context, req *pb.RestoreUser) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "RestoreUser", &pb.Events{
+		EventName: pb.EventName_USER_RESTORED,
+		Payload:   &pb.Events_RestoreUser{RestoreUser: req},
+	})
+}
+
+// ChangeRole replaces the role of the user
+func (us UserGrpcServer) ChangeRole(ctx context.Context, req *pb.ChangeRole) (*pb.User, error) {
+	return us.applyLifecycle(ctx, "ChangeRole", &pb.Events{
+		EventName: pb.EventName_USER_ROLE_CHANGED,
+		Payload:   &pb.Events_ChangeRole{ChangeRole: req},
+	})
+}
+
+// applyLifecycle applies the event like the event stream does and records it
+func (us UserGrpcServer) applyLifecycle(ctx context.Context, method string, event *pb.Events) (*pb.User, error) {
+	event.AggregateId = uuid.New().String()
+	event.EventDate = util.GetTime()
+	stamp(ctx, event)
+
+	user, err := applyLifecycle(us.users, event)
+	if err != nil {
+		return nil, us.toStatus(method, err)
+	}
+
+	if err := us.recorder.Record(event, user.ID.String()); err != nil {
+		return nil, err
+	}
+	return util.ToProtoUser(user), nil
+}
+
+// toStatus returns the domain error of given repository error which carries its gRPC status
+func (us UserGrpcServer) toStatus(method string, err error) error {
+	appErr := apperror.Wrap(err)
+	if appErr.Kind == apperror.Internal {
+		us.log.WithFields(logrus.Fields{"method": method}).Errorf("An error occurred %s", err.Error())
+	}
+	return appErr
+}
+
+// stamp sets the actor and the request meta of the event from the incoming metadata
+func stamp(ctx context.Context, event *pb.Events) *pb.Events {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if values := md.Get(key); len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	event.Actor = first(actorKey)
+	event.Meta = &pb.RequestMeta{
+		Ip:        strings.TrimSpace(strings.Split(first(forwardedForKey), ",")[0]),
+		UserAgent: first(gatewayUserAgentKey, userAgentKey),
+		RequestId: first(requestIDKey),
+	}
+	if p, ok := peer.FromContext(ctx); ok && event.Meta.Ip == "" {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			event.Meta.Ip = host
+		}
+	}
+	return event
+}
+
+func invalidArgument(field string, description string) error {
+	return &apperror.Error{
+		Kind:       apperror.InvalidArgument,
+		Reason:     apperror.ReasonInvalidArgument,
+		Message:    field + " " + description,
+		Violations: []apperror.FieldViolation{{Field: field, Description: description}},
+	}
+}
+
+func encodePageToken(page int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(page)))
+}
+
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 1, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+
+	page, err := strconv.Atoi(string(decoded))
+	if err != nil || page < 1 {
+		return 0, errors.New("invalid page")
+	}
+	return page, nil
+}
+
+func NewUserGrpcServer(users domain.UserService, recorder EventRecorder, log *logrus.Logger) pbuser.UserServiceServer {
+	return &UserGrpcServer{
+		users:    users,
+		recorder: recorder,
+		log:      log,
+	}
+}
@@ -2,118 +2,249 @@ package handler
 
 import (
 	"encoding/json"
-	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
-	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
 	pb "github.com/cemayan/faceit-technical-test/protos/event"
 	"github.com/sirupsen/logrus"
 )
 
 type UserEventHandler interface {
-	Handle() error
+	Handle(event *pb.Events) error
+}
+
+// ResponseSender sends the responses of the handled events to the stream
+type ResponseSender interface {
+	Send(response *pb.Response) error
 }
 
 type UserHandler struct {
-	userRepo    repo.GrpcUserRepository
-	event       *pb.Events
-	eventServer pb.EventGrpcService_HandleEventServer
+	users       domain.UserService
+	recorder    EventRecorder
+	sender      ResponseSender
+	deadLetters repo.DeadLetterRepository
 	log         *logrus.Logger
 }
 
 // Handle consumes the stream events
 // When request come to CREATE endpoint of API  these events called
-func (uh UserHandler) Handle() error {
+// Events of older schema versions such as the legacy JSON event_data are upcast to the current version first
+func (uh UserHandler) Handle(event *pb.Events) error {
+
+	err := schema.Default.Upcast(event)
+	if err != nil {
+		uh.log.WithFields(logrus.Fields{"method": "Handle"}).Errorf("An error occurred when upcasting the incoming event %s", err.Error())
+		return uh.sendError(event, apperror.Malformed(err))
+	}
 
-	switch uh.event.EventName {
+	switch event.EventName {
 	case pb.EventName_USER_CREATED:
+		return uh.handleCreate(event)
+	case pb.EventName_USER_UPDATED:
+		return uh.handleUpdate(event)
+	case pb.EventName_USER_DELETED:
+		return uh.handleDelete(event)
+	case pb.EventName_USER_ERASED:
+		return uh.handleErase(event)
+	}
 
-		var user model.User
-		err := json.Unmarshal(uh.event.EventData, &user)
-		if err != nil {
-			uh.log.WithFields(logrus.Fields{"method": "Handle"}).Errorln("An error occurred when unmarshalling the incoming eventdata")
-			return err
-		}
+	if isLifecycleEvent(event.EventName) {
+		return uh.handleLifecycle(event)
+	}
+	// Client waits for the response of every event, events which aren't handled are rejected
+	return uh.sendError(event, apperror.New(apperror.InvalidArgument, apperror.ReasonMalformedPayload, fmt.Sprintf("%s events are not handled", event.EventName)))
+}
 
-		createUser, err := uh.userRepo.CreateUser(&user)
-		if err != nil {
-			err := uh.eventServer.Send(&pb.Response{
-				Data:       []byte(err.Error()),
-				StatusCode: 400,
-			})
-			if err != nil {
-				return err
-			}
-
-		} else {
-			response, _ := json.Marshal(createUser)
-			err := uh.eventServer.Send(&pb.Response{
-				Data:       response,
-				StatusCode: 200,
-			})
-			if err != nil {
-				return err
-			}
+func (uh UserHandler) handleCreate(event *pb.Events) error {
+	payload := event.GetCreateUser()
+	if payload == nil {
+		return uh.sendError(event, apperror.New(apperror.InvalidArgument, apperror.ReasonMalformedPayload, "create_user payload is required"))
+	}
 
-		}
+	createUser, err := uh.users.CreateUser(&domain.User{
+		NickName:  payload.Nickname,
+		Email:     payload.Email,
+		Password:  payload.Password,
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Country:   payload.Country,
+	})
+	if err != nil {
+		return uh.sendError(event, err)
+	}
 
-	case pb.EventName_USER_UPDATED:
-		var user dto.UpdateUser
-		err := json.Unmarshal(uh.event.EventData, &user)
-		if err != nil {
-			uh.log.WithFields(logrus.Fields{"method": "Handle-Create"}).Errorln("An error occurred when unmarshalling the incoming eventdata")
-			err := uh.eventServer.Send(&pb.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-			if err != nil {
-				return err
-			}
-		}
+	if err := uh.recorder.Record(event, createUser.ID.String()); err != nil {
+		return uh.respondError(event, err)
+	}
 
-		err = uh.userRepo.UpdateUser(uh.event.InternalId, &user)
-		if err != nil {
-			err := uh.eventServer.Send(&pb.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			err := uh.eventServer.Send(&pb.Response{
-				StatusCode: 200,
-			})
-			if err != nil {
-				return err
-			}
-		}
-	case pb.EventName_USER_DELETED:
-		err := uh.userRepo.DeleteUser(uh.event.InternalId)
-		if err != nil {
-			err := uh.eventServer.Send(&pb.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			err := uh.eventServer.Send(&pb.Response{
-				StatusCode: 200,
-			})
-			if err != nil {
-				return err
-			}
+	response, _ := json.Marshal(createUser)
+	return uh.sender.Send(&pb.Response{
+		Data:          response,
+		User:          util.ToProtoUser(createUser),
+		StatusCode:    200,
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+func (uh UserHandler) handleUpdate(event *pb.Events) error {
+	payload := event.GetUpdateUser()
+	if payload == nil {
+		return uh.sendError(event, apperror.New(apperror.InvalidArgument, apperror.ReasonMalformedPayload, "update_user payload is required"))
+	}
+
+	id := payload.Id
+	if id == "" {
+		id = event.InternalId
+	}
+
+	update := toUpdate(payload)
+	_, err := uh.users.UpdateUser(id, update)
+	if err != nil {
+		return uh.sendError(event, err)
+	}
+
+	for _, recorded := range append([]*pb.Events{event}, util.DerivedEvents(update, id, event)...) {
+		if err := uh.recorder.Record(recorded, id); err != nil {
+			return uh.respondError(event, err)
 		}
 	}
-	return nil
+
+	return uh.sender.Send(&pb.Response{
+		StatusCode:    200,
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+// handleLifecycle applies the events which change a single attribute of the user
+// Response contains the changed user
+func (uh UserHandler) handleLifecycle(event *pb.Events) error {
+	user, err := applyLifecycle(uh.users, event)
+	if err != nil {
+		return uh.sendError(event, err)
+	}
+
+	if err := uh.recorder.Record(event, user.ID.String()); err != nil {
+		return uh.respondError(event, err)
+	}
+
+	return uh.sender.Send(&pb.Response{
+		User:          util.ToProtoUser(user),
+		StatusCode:    200,
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+func (uh UserHandler) handleDelete(event *pb.Events) error {
+	id := event.GetDeleteUser().GetId()
+	if id == "" {
+		id = event.InternalId
+	}
+
+	err := uh.users.DeleteUser(id)
+	if err != nil {
+		return uh.sendError(event, err)
+	}
+
+	if err := uh.recorder.Record(event, id); err != nil {
+		return uh.respondError(event, err)
+	}
+
+	return uh.sender.Send(&pb.Response{
+		StatusCode:    200,
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+// handleErase anonymizes the user and responds with the erasure receipt
+// IP and user agent of the request are not stored since the erased user may have sent it
+func (uh UserHandler) handleErase(event *pb.Events) error {
+	id := payloadUserID(event)
+	if id == "" {
+		id = event.InternalId
+	}
+	if event.GetEraseUser() == nil {
+		return uh.sendError(event, missingPayload("erase_user"))
+	}
+
+	event.Meta = &pb.RequestMeta{RequestId: event.GetMeta().GetRequestId()}
+	receipt, err := uh.users.EraseUser(id, erasure.Receipt{
+		ActorID:   event.Actor,
+		RequestID: event.Meta.RequestId,
+		Source:    auditSource,
+	})
+	if err != nil {
+		return uh.sendError(event, err)
+	}
+
+	if err := uh.recorder.Record(event, id); err != nil {
+		return uh.respondError(event, err)
+	}
+
+	response, _ := json.Marshal(receipt)
+	return uh.sender.Send(&pb.Response{
+		Data:          response,
+		StatusCode:    200,
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+// sendError sends the failed response with the HTTP status code and the gRPC status details of given error
+// Events which failed because of the server are dead-lettered, the client errors are only returned
+func (uh UserHandler) sendError(event *pb.Events, err error) error {
+	appErr := apperror.Wrap(err)
+	if appErr.Kind == apperror.Internal || appErr.Kind == apperror.Unavailable {
+		uh.log.WithFields(logrus.Fields{"method": "Handle"}).Errorf("An error occurred %s", err.Error())
+		uh.deadLetter(event, appErr)
+	}
+
+	return uh.respondError(event, appErr)
+}
+
+// respondError sends the failed response without dead-lettering the event
+// It is used when the change is applied but its event couldn't be recorded, retrying the event would apply it twice
+func (uh UserHandler) respondError(event *pb.Events, err error) error {
+	appErr := apperror.Wrap(err)
+	return uh.sender.Send(&pb.Response{
+		Message:       appErr.Message,
+		StatusCode:    int32(appErr.HTTPStatus()),
+		Status:        appErr.GRPCStatus().Proto(),
+		CorrelationId: event.CorrelationId,
+	})
+}
+
+func (uh UserHandler) deadLetter(event *pb.Events, appErr *apperror.Error) {
+	if uh.deadLetters == nil {
+		return
+	}
+
+	if err := uh.deadLetters.Add(event, appErr); err != nil {
+		uh.log.WithFields(logrus.Fields{"method": "Handle"}).Errorf("Event couldn't be dead-lettered %s", err.Error())
+	}
+}
+
+// toUpdate returns the domain update which contains only the fields in update_mask
+func toUpdate(payload *pb.UpdateUser) *domain.UpdateUser {
+	return &domain.UpdateUser{
+		NickName:   payload.Nickname,
+		Email:      payload.Email,
+		Password:   payload.Password,
+		FirstName:  payload.FirstName,
+		LastName:   payload.LastName,
+		Country:    payload.Country,
+		UpdateMask: payload.GetUpdateMask().GetPaths(),
+	}
 }
 
-func NewUserEventHandler(userRepo repo.GrpcUserRepository, event *pb.Events, eventServer pb.EventGrpcService_HandleEventServer, log *logrus.Logger) UserEventHandler {
+func NewUserEventHandler(users domain.UserService, recorder EventRecorder, sender ResponseSender, deadLetters repo.DeadLetterRepository, log *logrus.Logger) UserEventHandler {
 	return &UserHandler{
-		userRepo:    userRepo,
-		event:       event,
-		eventServer: eventServer,
+		users:       users,
+		recorder:    recorder,
+		sender:      sender,
+		deadLetters: deadLetters,
 		log:         log,
 	}
 }
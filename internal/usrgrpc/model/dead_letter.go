@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// DeadLetter is representation of an event which couldn't be processed by gRPC event server
+// ID is the correlation id of the event, so that the failures of a retried event are counted on the same record
+type DeadLetter struct {
+	ID            string `gorm:"primaryKey"`
+	AggregateId   string
+	AggregateType int32
+	EventName     int32 `gorm:"index"`
+	InternalId    string
+	EventDate     int64
+	Payload       []byte
+	Reason        string
+	Error         string
+	Attempts      int
+	CreatedAt     time.Time `gorm:"index"`
+	UpdatedAt     time.Time
+}
+
+// DeadLetterData is the response representation of a dead letter
+// Event is the JSON form of the original event whose personal data is redacted
+// PasswordRequired is set when the password has to be sent again to retry the event, passwords are never stored
+type DeadLetterData struct {
+	ID               string      `json:"id"`
+	EventName        string      `json:"event_name"`
+	AggregateId      string      `json:"aggregate_id"`
+	InternalId       string      `json:"internal_id"`
+	Reason           string      `json:"reason"`
+	Error            string      `json:"error"`
+	Attempts         int         `json:"attempts"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+	PasswordRequired bool        `json:"password_required"`
+	Event            interface{} `json:"event,omitempty"`
+}
+
+// RetryDeadLetter is representation of the retry payload
+type RetryDeadLetter struct {
+	Password string `json:"password"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Event is representation of a persisted event in the event store
+// Sequence is increased monotonically, consumers use it to resume their subscription
+type Event struct {
+	Sequence      int64  `gorm:"primaryKey;autoIncrement"`
+	AggregateId   string `gorm:"index"`
+	AggregateType int32
+	EventName     int32  `gorm:"index"`
+	InternalId    string `gorm:"index"`
+	EventDate     int64
+	Payload       []byte
+	CreatedAt     time.Time
+}
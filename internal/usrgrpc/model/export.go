@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Statuses of a data export
+const (
+	ExportPending = "pending"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// Export is a data export of a user which is generated in the background
+// Archive is the zip file of the export when its status is done, it is removed once it is downloaded
+// HeartbeatAt is renewed while the archive is built, a pending export whose heartbeat stops is interrupted
+type Export struct {
+	ID          string `gorm:"primaryKey"`
+	UserID      string `gorm:"index"`
+	Status      string
+	Error       string
+	Archive     []byte
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	HeartbeatAt *time.Time
+}
+
+// ExportData is the response representation of a data export
+type ExportData struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Size        int        `json:"size,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
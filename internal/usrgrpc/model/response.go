@@ -1,8 +1,13 @@
 package model
 
+import "github.com/cemayan/faceit-technical-test/pkg/apperror"
+
 // Response is representation of the response payload
+// Reason and Errors are filled when the request fails
 type Response struct {
-	Message    string      `json:"message,omitempty"`
-	Data       interface{} `json:"data,omitempty"`
-	StatusCode int         `json:"statusCode,omitempty"`
+	Message    string                    `json:"message,omitempty"`
+	Reason     string                    `json:"reason,omitempty"`
+	Errors     []apperror.FieldViolation `json:"errors,omitempty"`
+	Data       interface{}               `json:"data,omitempty"`
+	StatusCode int                       `json:"statusCode,omitempty"`
 }
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Snapshot is the state of a user aggregate after the event with Sequence
+// EventDate is the date of that event, it is used to find the snapshot of a point in time
+// State is the JSON form of the aggregate, the events after Sequence are applied on it when the user is loaded
+type Snapshot struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	UserID    string `gorm:"uniqueIndex:idx_snapshots_user_sequence"`
+	Sequence  int64  `gorm:"uniqueIndex:idx_snapshots_user_sequence"`
+	EventDate int64  `gorm:"index"`
+	Version   int
+	State     []byte
+	CreatedAt time.Time
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/aggregate"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// DefaultSnapshotInterval is the number of events between two snapshots when it is not configured
+const DefaultSnapshotInterval = 100
+
+// aggregateBatchSize is the number of events which are read at once while the aggregate is rebuilt
+const aggregateBatchSize = 500
+
+type UserAggregateRepository interface {
+	Load(userID string) (*aggregate.User, error)
+	LoadAsOf(userID string, asOf time.Time) (*aggregate.User, error)
+	History(userID string) ([]aggregate.HistoryEntry, error)
+	Snapshot(state *aggregate.User) (bool, error)
+	Rebuild(userID string) (*aggregate.User, error)
+}
+
+// UserAggregateRepo rebuilds the users from the event store
+// The latest snapshot is loaded first, only the events after it are applied
+type UserAggregateRepo struct {
+	eventRepo EventRepository
+	snapshots SnapshotRepository
+	interval  int
+	log       *log.Entry
+}
+
+// Load returns the current state of given user
+func (r UserAggregateRepo) Load(userID string) (*aggregate.User, error) {
+	state, err := r.snapshots.Latest(userID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = aggregate.NewUser(userID)
+	}
+
+	state, err = r.replay(state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Version == 0 {
+		return nil, userNotFound()
+	}
+	return state, nil
+}
+
+// LoadAsOf returns the state of given user at asOf
+// The latest snapshot before asOf is loaded and the events until asOf are applied on it
+func (r UserAggregateRepo) LoadAsOf(userID string, asOf time.Time) (*aggregate.User, error) {
+	state, err := r.snapshots.LatestAsOf(userID, asOf.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = aggregate.NewUser(userID)
+	}
+
+	state, err = r.replayUntil(state, func(event *pb.Events) bool {
+		return event.EventDate <= asOf.Unix()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state.Version == 0 {
+		return nil, userNotFound()
+	}
+	return state, nil
+}
+
+// History returns the field-level changes of given user from its first event
+func (r UserAggregateRepo) History(userID string) ([]aggregate.HistoryEntry, error) {
+	state := aggregate.NewUser(userID)
+	entries := []aggregate.HistoryEntry{}
+
+	filter := EventFilter{UserID: userID}
+	for {
+		events, err := r.eventRepo.GetEvents(filter, aggregateBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, aggregate.History(state, events)...)
+
+		if len(events) < aggregateBatchSize {
+			break
+		}
+		filter.FromSequence = state.Sequence
+	}
+
+	if state.Version == 0 {
+		return nil, userNotFound()
+	}
+	return entries, nil
+}
+
+// Snapshot saves given state as a new snapshot when at least interval events are applied after the latest one
+// It reports whether a snapshot is saved
+func (r UserAggregateRepo) Snapshot(state *aggregate.User) (bool, error) {
+	latest, err := r.snapshots.Latest(state.ID)
+	if err != nil {
+		return false, err
+	}
+	applied := state.Version
+	if latest != nil {
+		applied -= latest.Version
+	}
+	if applied < r.interval {
+		return false, nil
+	}
+
+	if err := r.snapshots.Save(state); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rebuild replays all events of given user ignoring its snapshots and replaces them with a single snapshot
+// It is used when the snapshots are stale, e.g. after the fold logic is changed
+func (r UserAggregateRepo) Rebuild(userID string) (*aggregate.User, error) {
+	state, err := r.replay(aggregate.NewUser(userID))
+	if err != nil {
+		return nil, err
+	}
+	if state.Version == 0 {
+		return nil, userNotFound()
+	}
+
+	if _, err := r.snapshots.Delete(userID); err != nil {
+		return nil, err
+	}
+	if err := r.snapshots.Save(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// replay applies the events after the sequence of given state
+func (r UserAggregateRepo) replay(state *aggregate.User) (*aggregate.User, error) {
+	return r.replayUntil(state, func(event *pb.Events) bool { return true })
+}
+
+// replayUntil applies the events after the sequence of given state until apply returns false
+func (r UserAggregateRepo) replayUntil(state *aggregate.User, apply func(event *pb.Events) bool) (*aggregate.User, error) {
+	filter := EventFilter{UserID: state.ID, FromSequence: state.Sequence}
+	for {
+		events, err := r.eventRepo.GetEvents(filter, aggregateBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range events {
+			if !apply(event) {
+				return state, nil
+			}
+			state.Apply(event)
+		}
+
+		if len(events) < aggregateBatchSize {
+			return state, nil
+		}
+		filter.FromSequence = state.Sequence
+	}
+}
+
+func userNotFound() *apperror.Error {
+	return apperror.New(apperror.NotFound, apperror.ReasonUserNotFound, "user not found")
+}
+
+// NewUserAggregateRepo returns the repository which snapshots the users every interval events
+// DefaultSnapshotInterval is used when interval is not positive
+func NewUserAggregateRepo(eventRepo EventRepository, snapshots SnapshotRepository, interval int, log *log.Entry) UserAggregateRepository {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	return &UserAggregateRepo{
+		eventRepo: eventRepo,
+		snapshots: snapshots,
+		interval:  interval,
+		log:       log,
+	}
+}
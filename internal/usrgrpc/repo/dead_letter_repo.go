@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type DeadLetterRepository interface {
+	Add(event *pb.Events, appErr *apperror.Error) error
+	RecordAttempt(id string, appErr *apperror.Error) error
+	List(limit int, offset int) ([]model.DeadLetter, int64, error)
+	Get(id string) (*model.DeadLetter, *pb.Events, error)
+	Delete(id string) error
+	Count() (int64, error)
+}
+
+type DeadLetterRepo struct {
+	db  *gorm.DB
+	log *log.Entry
+}
+
+// Add persists the failed event with its error
+// When the event is already dead-lettered its attempts are increased and the error is replaced
+// Passwords are cleared before the event is stored, the update payload is masked so that the retry knows a password was changed
+func (r DeadLetterRepo) Add(event *pb.Events, appErr *apperror.Error) error {
+	if event.CorrelationId == "" {
+		event.CorrelationId = uuid.New().String()
+	}
+
+	stored := proto.Clone(event).(*pb.Events)
+	if update := stored.GetUpdateUser(); update != nil {
+		util.FillUpdateMask(update)
+	}
+	util.ClearPasswords(stored)
+
+	payload, err := proto.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	record := model.DeadLetter{
+		ID:            event.CorrelationId,
+		AggregateId:   event.AggregateId,
+		AggregateType: int32(event.AggregateType),
+		EventName:     int32(event.EventName),
+		InternalId:    event.InternalId,
+		EventDate:     event.EventDate,
+		Payload:       payload,
+		Reason:        appErr.Reason,
+		Error:         appErr.Error(),
+		Attempts:      1,
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts":   gorm.Expr("dead_letters.attempts + 1"),
+			"reason":     record.Reason,
+			"error":      record.Error,
+			"updated_at": time.Now(),
+		}),
+	}).Create(&record).Error
+}
+
+// RecordAttempt increases the attempts of the dead letter after a failed retry
+func (r DeadLetterRepo) RecordAttempt(id string, appErr *apperror.Error) error {
+	return r.db.Model(&model.DeadLetter{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"reason":     appErr.Reason,
+		"error":      appErr.Error(),
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// List returns the dead letters from the oldest one and their total count
+func (r DeadLetterRepo) List(limit int, offset int) ([]model.DeadLetter, int64, error) {
+	var records []model.DeadLetter
+	var total int64
+
+	if err := r.db.Model(&model.DeadLetter{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := r.db.Omit("payload").Order("created_at asc").Limit(limit).Offset(offset).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// Get returns the dead letter and its original event
+func (r DeadLetterRepo) Get(id string) (*model.DeadLetter, *pb.Events, error) {
+	var record model.DeadLetter
+	if err := r.db.Where("id = ?", id).First(&record).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var event pb.Events
+	if err := proto.Unmarshal(record.Payload, &event); err != nil {
+		return nil, nil, err
+	}
+
+	return &record, &event, nil
+}
+
+// Delete removes the dead letter, gorm.ErrRecordNotFound is returned when it doesn't exist
+func (r DeadLetterRepo) Delete(id string) error {
+	tx := r.db.Where("id = ?", id).Delete(&model.DeadLetter{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Count returns the number of the dead letters
+func (r DeadLetterRepo) Count() (int64, error) {
+	var total int64
+	err := r.db.Model(&model.DeadLetter{}).Count(&total).Error
+	return total, err
+}
+
+func NewDeadLetterRepo(db *gorm.DB, log *log.Entry) DeadLetterRepository {
+	return &DeadLetterRepo{
+		db:  db,
+		log: log,
+	}
+}
@@ -0,0 +1,151 @@
+package repo
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// EventFilter is representation of the event store query
+// FromSequence is exclusive, only the events after it are returned
+type EventFilter struct {
+	FromSequence int64
+	EventNames   []pb.EventName
+	UserID       string
+}
+
+// eventBatchSize is the number of events which are read at once while scrubbing
+const eventBatchSize = 500
+
+type EventRepository interface {
+	Append(event *pb.Events) (*pb.Events, error)
+	GetEvents(filter EventFilter, limit int) ([]*pb.Events, error)
+	GetUserIDs() ([]string, error)
+	LastSequence() (int64, error)
+	Scrub(userID string) (int64, error)
+}
+
+type Eventrepo struct {
+	db  *gorm.DB
+	log *log.Entry
+}
+
+// Append persists the given event and returns it with the assigned sequence
+func (r Eventrepo) Append(event *pb.Events) (*pb.Events, error) {
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	record := model.Event{
+		AggregateId:   event.AggregateId,
+		AggregateType: int32(event.AggregateType),
+		EventName:     int32(event.EventName),
+		InternalId:    event.InternalId,
+		EventDate:     event.EventDate,
+		Payload:       payload,
+	}
+
+	if err := r.db.Create(&record).Error; err != nil {
+		return nil, err
+	}
+
+	stored := proto.Clone(event).(*pb.Events)
+	stored.Sequence = record.Sequence
+	return stored, nil
+}
+
+// GetEvents returns the events which are matched with given filter in sequence order
+// Events are upcast to the current schema version
+func (r Eventrepo) GetEvents(filter EventFilter, limit int) ([]*pb.Events, error) {
+	var records []model.Event
+
+	tx := r.db.Where("sequence > ?", filter.FromSequence)
+	if len(filter.EventNames) > 0 {
+		names := make([]int32, 0, len(filter.EventNames))
+		for _, name := range filter.EventNames {
+			names = append(names, int32(name))
+		}
+		tx = tx.Where("event_name IN ?", names)
+	}
+	if filter.UserID != "" {
+		tx = tx.Where("internal_id = ?", filter.UserID)
+	}
+
+	if err := tx.Order("sequence asc").Limit(limit).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	events := make([]*pb.Events, 0, len(records))
+	for _, record := range records {
+		var event pb.Events
+		if err := proto.Unmarshal(record.Payload, &event); err != nil {
+			return nil, err
+		}
+		// Stored events keep their version, consumers always get the current one
+		if err := schema.Default.Upcast(&event); err != nil {
+			return nil, err
+		}
+		event.Sequence = record.Sequence
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// GetUserIDs returns the ids of the users which have events
+func (r Eventrepo) GetUserIDs() ([]string, error) {
+	var ids []string
+	err := r.db.Model(&model.Event{}).Where("internal_id <> ''").Distinct().Order("internal_id").Pluck("internal_id", &ids).Error
+	return ids, err
+}
+
+// LastSequence returns the sequence of the latest event, 0 when there is no event
+func (r Eventrepo) LastSequence() (int64, error) {
+	var last int64
+	err := r.db.Model(&model.Event{}).Select("COALESCE(MAX(sequence), 0)").Scan(&last).Error
+	return last, err
+}
+
+// Scrub replaces the personal data in the stored events of the user and returns the number of the scrubbed events
+// Events are stored with the current schema version after they are scrubbed
+func (r Eventrepo) Scrub(userID string) (int64, error) {
+	var scrubbed int64
+	var batch []model.Event
+
+	err := r.db.Where("internal_id = ?", userID).Order("sequence asc").FindInBatches(&batch, eventBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, record := range batch {
+			var event pb.Events
+			if err := proto.Unmarshal(record.Payload, &event); err != nil {
+				return err
+			}
+			if err := schema.Default.Upcast(&event); err != nil {
+				return err
+			}
+			util.ScrubPayload(&event, userID)
+
+			payload, err := proto.Marshal(&event)
+			if err != nil {
+				return err
+			}
+			if err := r.db.Model(&model.Event{}).Where("sequence = ?", record.Sequence).Update("payload", payload).Error; err != nil {
+				return err
+			}
+			scrubbed++
+		}
+		return nil
+	}).Error
+
+	return scrubbed, err
+}
+
+func NewEventRepo(db *gorm.DB, log *log.Entry) EventRepository {
+	return &Eventrepo{
+		db:  db,
+		log: log,
+	}
+}
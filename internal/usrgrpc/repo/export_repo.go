@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ExportRepository interface {
+	Create(userID string) (*model.Export, error)
+	Get(id string) (*model.Export, error)
+	Complete(id string, archive []byte) error
+	Fail(id string, reason string) error
+	Reusable(userID string, since time.Time, aliveSince time.Time) (*model.Export, error)
+	Heartbeat(id string) error
+	Expire(id string) error
+	FailStale(before time.Time, reason string) (int64, error)
+	DeleteFinished(before time.Time) (int64, error)
+}
+
+// lastHeartbeat is the time the export was last known to be built, exports of the older versions don't have a heartbeat
+const lastHeartbeat = "COALESCE(heartbeat_at, created_at)"
+
+type ExportRepo struct {
+	db  *gorm.DB
+	log *log.Entry
+}
+
+// Create adds a pending export of given user
+func (r ExportRepo) Create(userID string) (*model.Export, error) {
+	now := time.Now()
+	export := model.Export{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Status:      model.ExportPending,
+		HeartbeatAt: &now,
+	}
+	if err := r.db.Create(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Get returns the export with its archive, gorm.ErrRecordNotFound is returned when it doesn't exist
+func (r ExportRepo) Get(id string) (*model.Export, error) {
+	var export model.Export
+	if err := r.db.Where("id = ?", id).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Complete stores the archive of the export
+func (r ExportRepo) Complete(id string, archive []byte) error {
+	return r.finish(id, map[string]interface{}{"status": model.ExportDone, "archive": archive})
+}
+
+// Fail marks the export as failed with given reason
+func (r ExportRepo) Fail(id string, reason string) error {
+	return r.finish(id, map[string]interface{}{"status": model.ExportFailed, "error": reason})
+}
+
+// Reusable returns the latest export of given user which is done since given time, or pending with a heartbeat since aliveSince
+// nil is returned when there is none, archive of the export is not loaded
+func (r ExportRepo) Reusable(userID string, since time.Time, aliveSince time.Time) (*model.Export, error) {
+	var exports []model.Export
+	err := r.db.Omit("archive").
+		Where("user_id = ? AND ((status = ? AND "+lastHeartbeat+" >= ?) OR (status = ? AND completed_at >= ?))", userID, model.ExportPending, aliveSince, model.ExportDone, since).
+		Order("created_at desc").Limit(1).Find(&exports).Error
+	if err != nil || len(exports) == 0 {
+		return nil, err
+	}
+	return &exports[0], nil
+}
+
+// Heartbeat renews the heartbeat of the pending export, so that it isn't failed as interrupted
+func (r ExportRepo) Heartbeat(id string) error {
+	return r.db.Model(&model.Export{}).Where("id = ? AND status = ?", id, model.ExportPending).Update("heartbeat_at", time.Now()).Error
+}
+
+// Expire removes the archive of the export, it is called once the archive is downloaded
+func (r ExportRepo) Expire(id string) error {
+	return r.db.Model(&model.Export{}).Where("id = ?", id).Updates(map[string]interface{}{"status": model.ExportExpired, "archive": nil}).Error
+}
+
+// FailStale marks the pending exports whose last heartbeat is before given time as failed and returns their count
+// Their builds are lost, e.g. the service which was building them is restarted
+func (r ExportRepo) FailStale(before time.Time, reason string) (int64, error) {
+	result := r.db.Model(&model.Export{}).Where("status = ? AND "+lastHeartbeat+" < ?", model.ExportPending, before).
+		Updates(map[string]interface{}{"status": model.ExportFailed, "error": reason, "completed_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteFinished deletes the exports which are finished before given time and returns their count
+func (r ExportRepo) DeleteFinished(before time.Time) (int64, error) {
+	result := r.db.Where("status <> ? AND completed_at < ?", model.ExportPending, before).Delete(&model.Export{})
+	return result.RowsAffected, result.Error
+}
+
+func (r ExportRepo) finish(id string, fields map[string]interface{}) error {
+	fields["completed_at"] = time.Now()
+	return r.db.Model(&model.Export{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func NewExportRepo(db *gorm.DB, log *log.Entry) ExportRepository {
+	return &ExportRepo{
+		db:  db,
+		log: log,
+	}
+}
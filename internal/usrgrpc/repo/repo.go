@@ -1,154 +1,40 @@
 package repo
 
 import (
-	"errors"
-	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
-	"github.com/cemayan/faceit-technical-test/pkg/common"
-	"github.com/google/uuid"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
-	"math"
 )
 
-type GrpcUserRepository interface {
-	GetAllUser(pagination common.Pagination) (*common.Pagination, error)
-	CreateUser(user *model.User) (*model.User, error)
-	UpdateUser(id string, user *dto.UpdateUser) error
-	DeleteUser(id string) error
-	GetUserByID(id string) (*model.User, error)
-	hashPassword(password string) (string, error)
-	paginate(value interface{}, pagination *common.Pagination, db *gorm.DB) func(db *gorm.DB) *gorm.DB
-}
-
-type GrpcUserrepo struct {
-	db  *gorm.DB
-	log *log.Entry
-}
-
-func (r GrpcUserrepo) paginate(value interface{}, pagination *common.Pagination, db *gorm.DB) func(db *gorm.DB) *gorm.DB {
-	var totalRows int64
-	db.Model(value).Count(&totalRows)
-
-	pagination.TotalRows = totalRows
-	totalPages := int(math.Ceil(float64(totalRows) / float64(pagination.Limit)))
-	pagination.TotalPages = totalPages
-
-	return func(db *gorm.DB) *gorm.DB {
-		return db.Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Order(pagination.GetSort())
-	}
-}
-
-func (r GrpcUserrepo) hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
-
-func (r GrpcUserrepo) GetAllUser(pagination common.Pagination) (*common.Pagination, error) {
-	var users []model.User
-
-	if pagination.CQuery != "" && pagination.CValue != "" {
-		conditionQuery := pagination.CQuery
-		conditionValue := pagination.CValue
-		tx := r.db.Scopes(r.paginate(users, &pagination, r.db)).Debug().Where(conditionQuery, conditionValue).Find(&users)
-		pagination.Rows = users
-		return &pagination, tx.Error
-	} else if pagination.CQuery == "" && pagination.CValue == "" {
-		tx := r.db.Scopes(r.paginate(users, &pagination, r.db)).Find(&users)
-		pagination.Rows = users
-		return &pagination, tx.Error
-	} else {
-		return nil, nil
-	}
-
-}
-
-func (r GrpcUserrepo) UpdateUser(id string, userDTO *dto.UpdateUser) error {
-
-	user, err := r.GetUserByID(id)
-
-	if user == nil || err != nil {
-		return err
-	}
-
-	if userDTO.Password != "" {
-		hash, _ := r.hashPassword(userDTO.Password)
-		user.Password = hash
-	}
-
-	if userDTO.NickName != "" {
-		user.NickName = userDTO.NickName
-	}
-
-	if userDTO.Email != "" {
-		user.Email = userDTO.Email
-	}
-	if userDTO.FirstName != "" {
-		user.FirstName = userDTO.FirstName
-	}
-	if userDTO.LastName != "" {
-		user.FirstName = userDTO.FirstName
-	}
-	if userDTO.Country != "" {
-		user.Country = userDTO.Country
-	}
-
-	tx := r.db.Save(user)
-	return tx.Error
-}
-
-func (r GrpcUserrepo) DeleteUser(id string) error {
-	user, err := r.GetUserByID(id)
-
-	if err != nil {
-		return err
-	}
-
-	tx := r.db.Delete(user)
-	return tx.Error
-}
-
-func (r GrpcUserrepo) CreateUser(user *model.User) (*model.User, error) {
-
-	hash, err := r.hashPassword(user.Password)
-	if err != nil {
-		return nil, err
-	}
-
-	user.Password = hash
-
-	if err := r.db.Create(user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
+// EventStoreScrub returns the scrub func of the user repository which scrubs the personal data of the user from the events
+// Snapshots, data exports and dead letters of the user are removed, they are rebuilt from the scrubbed events when needed
+// Events of a purged user are deleted, so its history and past states can't be rebuilt anymore
+func EventStoreScrub(log *log.Entry) domain.ScrubFunc {
+	return func(tx *gorm.DB, id string, receipt *erasure.Receipt) error {
+		// the tables of gRPC event server are not migrated by every storage backend
+		if !tx.Migrator().HasTable(&model.Event{}) {
+			return nil
 		}
-		return nil, err
-	}
-	return user, nil
-}
-
-// GetUserByID returns user based on given id
-func (r GrpcUserrepo) GetUserByID(id string) (*model.User, error) {
-	var user model.User
-	_id, err := uuid.Parse(id)
-
-	if err != nil {
-		return nil, err
-	}
 
-	if err := r.db.Where("id = ?", _id).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
+		if receipt.Purge {
+			deleted := tx.Where("internal_id = ?", id).Delete(&model.Event{})
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			receipt.EventsScrubbed = deleted.RowsAffected
+		} else {
+			var err error
+			if receipt.EventsScrubbed, err = NewEventRepo(tx, log).Scrub(id); err != nil {
+				return err
+			}
 		}
-		return nil, err
-	}
-
-	return &user, nil
-}
-
-func NewGrpcUserRepo(db *gorm.DB, log *log.Entry) GrpcUserRepository {
-	return &GrpcUserrepo{
-		db:  db,
-		log: log,
+		for _, record := range []interface{}{&model.Snapshot{}, &model.Export{}} {
+			if err := tx.Where("user_id = ?", id).Delete(record).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("internal_id = ?", id).Delete(&model.DeadLetter{}).Error
 	}
 }
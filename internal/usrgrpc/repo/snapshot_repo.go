@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/aggregate"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SnapshotRepository interface {
+	Save(state *aggregate.User) error
+	Latest(userID string) (*aggregate.User, error)
+	LatestAsOf(userID string, eventDate int64) (*aggregate.User, error)
+	Delete(userID string) (int64, error)
+	Compact() (int64, error)
+}
+
+type SnapshotRepo struct {
+	db  *gorm.DB
+	log *log.Entry
+}
+
+// Save persists the given state, saving the same sequence twice keeps the first one
+func (r SnapshotRepo) Save(state *aggregate.User) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	record := model.Snapshot{
+		UserID:    state.ID,
+		Sequence:  state.Sequence,
+		EventDate: state.EventDate,
+		Version:   state.Version,
+		State:     data,
+	}
+
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error
+}
+
+// Latest returns the state of the latest snapshot of given user, it is nil when the user has no snapshot
+func (r SnapshotRepo) Latest(userID string) (*aggregate.User, error) {
+	return r.latest(r.db.Where("user_id = ?", userID))
+}
+
+// LatestAsOf returns the state of the latest snapshot of given user which is taken at or before eventDate
+func (r SnapshotRepo) LatestAsOf(userID string, eventDate int64) (*aggregate.User, error) {
+	return r.latest(r.db.Where("user_id = ? AND event_date <= ?", userID, eventDate))
+}
+
+func (r SnapshotRepo) latest(tx *gorm.DB) (*aggregate.User, error) {
+	var record model.Snapshot
+	err := tx.Order("sequence desc").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state aggregate.User
+	if err := json.Unmarshal(record.State, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Delete removes the snapshots of given user, all snapshots are removed when userID is empty
+func (r SnapshotRepo) Delete(userID string) (int64, error) {
+	tx := r.db.Session(&gorm.Session{AllowGlobalUpdate: true})
+	if userID != "" {
+		tx = tx.Where("user_id = ?", userID)
+	}
+	result := tx.Delete(&model.Snapshot{})
+	return result.RowsAffected, result.Error
+}
+
+// Compact removes all snapshots except the latest one of each user
+func (r SnapshotRepo) Compact() (int64, error) {
+	result := r.db.Exec("DELETE FROM snapshots s WHERE s.sequence < (SELECT MAX(l.sequence) FROM snapshots l WHERE l.user_id = s.user_id)")
+	return result.RowsAffected, result.Error
+}
+
+func NewSnapshotRepo(db *gorm.DB, log *log.Entry) SnapshotRepository {
+	return &SnapshotRepo{
+		db:  db,
+		log: log,
+	}
+}
@@ -1,15 +1,23 @@
 package router
 
 import (
+	"context"
 	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/database"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/export"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
-	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/storage"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/swagger"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
@@ -23,25 +31,79 @@ func grpcPrometheusHandler() http.Handler {
 // SetupGrpcRoutes creates the fiber's routes
 // api/v1 is root group.
 // Before the reach services interface is configured
-func SetupGrpcRoutes(app *fiber.App, _log *log.Entry, client pb.EventGrpcServiceClient, configs *user.AppConfig) {
+// Users are read through userCache when it is not nil
+// The returned invalidator is told about the users which are changed by gRPC event server, it is nil when their reads don't need it
+func SetupGrpcRoutes(app *fiber.App, _log *log.Entry, events eventclient.EventClient, health eventclient.HealthChecker, userCache cache.Cache, configs *user.AppConfig) domain.UserInvalidator {
 
-	api := app.Group("/api", logger.New())
+	api := app.Group("/api", requestid.New(), logger.New())
 	v1 := api.Group("/v1")
 
 	v1.Get("/metrics", adaptor.HTTPHandler(grpcPrometheusHandler()))
 
 	v1.Get("/swagger/*", swagger.HandlerDefault) // default
 
-	userRepo := repo.NewGrpcUserRepo(database.DB, _log)
+	userRepo := domain.NewBackendUserRepo(storage.Backend(&configs.Storage), database.DB, database.Reads, repo.EventStoreScrub(_log), _log)
+	if userCache != nil {
+		userRepo = domain.NewCachedUserRepo(userRepo, userCache, configs.Cache.TTL, _log)
+	}
+	changes, _ := userRepo.(domain.UserInvalidator)
+	eventRepo := repo.NewEventRepo(database.DB, _log)
+	snapshotRepo := repo.NewSnapshotRepo(database.DB, _log)
+	aggregateRepo := repo.NewUserAggregateRepo(eventRepo, snapshotRepo, configs.Grpc.SNAPSHOT_INTERVAL, _log)
+	auditRepo := audit.NewRepository(database.DB, _log)
+	exportRepo := repo.NewExportRepo(database.DB, _log)
 
 	var validate = validator.New()
+	apperror.UseJSONFieldNames(validate)
 
-	var userSvc = service.NewGrpcUserService(userRepo, validate, client, _log, configs)
+	var userSvc = service.NewGrpcUserService(domain.NewUserService(userRepo, validate, _log), changes, aggregateRepo, validate, events, health, _log, configs)
+
+	v1.Get("/health", userSvc.HealthCheck)
+	v1.Get("/ready", userSvc.ReadinessCheck)
 
 	userGroup := v1.Group("/user")
 	userGroup.Get("/", userSvc.GetAllUser)
 	userGroup.Get("/:id", userSvc.GetUser)
+	userGroup.Get("/:id/history", userSvc.GetUserHistory)
 	userGroup.Post("/", userSvc.CreateUser)
 	userGroup.Put("/:id", userSvc.UpdateUser)
 	userGroup.Delete("/:id", userSvc.DeleteUser)
+	userGroup.Post("/:id/erase", userSvc.EraseUser)
+	userGroup.Put("/:id/password", userSvc.ChangePassword)
+	userGroup.Put("/:id/email", userSvc.ChangeEmail)
+	userGroup.Post("/:id/suspend", userSvc.SuspendUser)
+	userGroup.Post("/:id/reactivate", userSvc.ReactivateUser)
+	userGroup.Post("/:id/restore", userSvc.RestoreUser)
+	userGroup.Put("/:id/role", userSvc.ChangeRole)
+
+	exporter := export.NewJobExporter(export.NewArchiveBuilder(userRepo, eventRepo, auditRepo), exportRepo, configs.Grpc.EXPORT_TTL, _log)
+	go exporter.Run(context.Background())
+	var exportSvc = service.NewExportService(userRepo, exportRepo, exporter, _log)
+
+	userGroup.Get("/:id/export", exportSvc.ExportUser)
+	userGroup.Get("/:id/export/:exportId", exportSvc.GetExport)
+	userGroup.Get("/:id/export/:exportId/download", exportSvc.DownloadExport)
+
+	deadLetterRepo := repo.NewDeadLetterRepo(database.DB, _log)
+	var deadLetterSvc = service.NewDeadLetterService(deadLetterRepo, events, _log, configs)
+
+	dlqGroup := v1.Group("/admin/dlq")
+	dlqGroup.Get("/", deadLetterSvc.ListDeadLetters)
+	dlqGroup.Get("/:id", deadLetterSvc.GetDeadLetter)
+	dlqGroup.Post("/:id/retry", deadLetterSvc.RetryDeadLetter)
+	dlqGroup.Delete("/:id", deadLetterSvc.DiscardDeadLetter)
+
+	var auditSvc = service.NewAuditService(auditRepo, _log)
+
+	auditGroup := v1.Group("/admin/audit")
+	auditGroup.Get("/", auditSvc.ListAuditLog)
+	auditGroup.Get("/export", auditSvc.ExportAuditLog)
+
+	var trashSvc = service.NewTrashService(userRepo, _log)
+
+	trashGroup := v1.Group("/admin/trash")
+	trashGroup.Get("/", trashSvc.ListTrash)
+	trashGroup.Post("/:id/restore", userSvc.RestoreUser)
+
+	return changes
 }
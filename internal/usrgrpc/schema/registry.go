@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"fmt"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+)
+
+// CurrentVersion is the schema version of the events which are produced by this release
+// Version 1 carries the JSON event_data, version 2 carries the typed payload
+const CurrentVersion int32 = 2
+
+// An Upcaster transforms the event from its version to the next one
+type Upcaster func(event *pb.Events) error
+
+type Registry interface {
+	Register(eventName pb.EventName, fromVersion int32, upcaster Upcaster)
+	Upcast(event *pb.Events) error
+}
+
+type upcasterKey struct {
+	eventName   pb.EventName
+	fromVersion int32
+}
+
+// An UpcasterRegistry keeps the upcasters of every event name and version
+// When an event name has no upcaster for a version its payload shape didn't change in that version
+type UpcasterRegistry struct {
+	upcasters map[upcasterKey]Upcaster
+}
+
+// Register adds the upcaster which transforms the events from fromVersion to fromVersion+1
+func (r *UpcasterRegistry) Register(eventName pb.EventName, fromVersion int32, upcaster Upcaster) {
+	r.upcasters[upcasterKey{eventName: eventName, fromVersion: fromVersion}] = upcaster
+}
+
+// Upcast transforms the event to CurrentVersion step by step
+// Events from a newer release are rejected since they can't be read safely
+func (r *UpcasterRegistry) Upcast(event *pb.Events) error {
+	version := VersionOf(event)
+	if version > CurrentVersion {
+		return fmt.Errorf("unsupported schema version %d of %s, current version is %d", version, event.EventName.String(), CurrentVersion)
+	}
+
+	for ; version < CurrentVersion; version++ {
+		upcaster, ok := r.upcasters[upcasterKey{eventName: event.EventName, fromVersion: version}]
+		if !ok {
+			continue
+		}
+		if err := upcaster(event); err != nil {
+			return fmt.Errorf("upcasting %s from version %d: %w", event.EventName.String(), version, err)
+		}
+	}
+
+	event.SchemaVersion = CurrentVersion
+	return nil
+}
+
+// VersionOf returns the schema version of the event
+// Events without a version are version 1 when they carry only event_data, otherwise they already have the typed payload
+func VersionOf(event *pb.Events) int32 {
+	if event.SchemaVersion != 0 {
+		return event.SchemaVersion
+	}
+	if event.Payload == nil && len(event.EventData) > 0 {
+		return 1
+	}
+	return 2
+}
+
+// NewRegistry returns the registry with the upcasters of every historical version
+func NewRegistry() Registry {
+	registry := &UpcasterRegistry{upcasters: map[upcasterKey]Upcaster{}}
+	registerV1Upcasters(registry)
+	return registry
+}
+
+// Default is the registry which is used when replaying and consuming the events
+var Default = NewRegistry()
@@ -0,0 +1,37 @@
+package schema
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+)
+
+// registerV1Upcasters registers the conversions of the legacy JSON event_data into the typed payload
+func registerV1Upcasters(registry Registry) {
+	registry.Register(pb.EventName_USER_CREATED, 1, func(event *pb.Events) error {
+		var user domain.User
+		if err := json.Unmarshal(event.EventData, &user); err != nil {
+			return err
+		}
+		event.Payload = &pb.Events_CreateUser{CreateUser: util.NewCreateUserPayload(&user)}
+		event.EventData = nil
+		return nil
+	})
+
+	registry.Register(pb.EventName_USER_UPDATED, 1, func(event *pb.Events) error {
+		var user domain.User
+		if err := json.Unmarshal(event.EventData, &user); err != nil {
+			return err
+		}
+		event.Payload = &pb.Events_UpdateUser{UpdateUser: util.NewUpdateUserPayload(event.InternalId, &user)}
+		event.EventData = nil
+		return nil
+	})
+
+	registry.Register(pb.EventName_USER_DELETED, 1, func(event *pb.Events) error {
+		event.Payload = &pb.Events_DeleteUser{DeleteUser: &pb.DeleteUser{Id: event.InternalId}}
+		event.EventData = nil
+		return nil
+	})
+}
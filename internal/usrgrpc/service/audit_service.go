@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+type AuditService interface {
+	ListAuditLog(c *fiber.Ctx) error
+	ExportAuditLog(c *fiber.Ctx) error
+}
+
+// An AuditSvc lets the admins query the audit log which is written by all services
+type AuditSvc struct {
+	repository audit.Repository
+	log        *log.Entry
+}
+
+// auditQuery is representation of the audit log filters in the query string
+// From and To are RFC3339 timestamps, dates or unix seconds
+type auditQuery struct {
+	UserID    string `query:"user_id"`
+	ActorID   string `query:"actor_id"`
+	Action    string `query:"action"`
+	RequestID string `query:"request_id"`
+	From      string `query:"from"`
+	To        string `query:"to"`
+}
+
+// ListAuditLog returns the audit entries from the newest one
+// @Summary  ListAuditLog
+// @Param    user_id    query string false "user_id"
+// @Param    actor_id   query string false "actor_id"
+// @Param    action     query string false "action such as USER_UPDATED"
+// @Param    request_id query string false "request_id"
+// @Param    from       query string false "from (inclusive)"
+// @Param    to         query string false "to (exclusive)"
+// @Param    limit      query number false "limit"
+// @Param    page       query number false "page"
+// @Tags     Audit
+// @Router   /admin/audit [get]
+func (s AuditSvc) ListAuditLog(c *fiber.Ctx) error {
+	filter, err := s.filter(c)
+	if err != nil {
+		return errorResponse(c, s.log, "ListAuditLog", err)
+	}
+
+	var pagination common.Pagination
+	if err := c.QueryParser(&pagination); err != nil {
+		return errorResponse(c, s.log, "ListAuditLog", apperror.Malformed(err))
+	}
+	if pagination.GetLimit() > 100 {
+		pagination.Limit = 100
+	}
+
+	entries, total, err := s.repository.List(filter, pagination.GetLimit(), pagination.GetOffset())
+	if err != nil {
+		return errorResponse(c, s.log, "ListAuditLog", err)
+	}
+
+	items := make([]audit.EntryData, 0, len(entries))
+	for _, entry := range entries {
+		data, err := entry.Data()
+		if err != nil {
+			return errorResponse(c, s.log, "ListAuditLog", err)
+		}
+		items = append(items, data)
+	}
+
+	pagination.Rows = items
+	pagination.TotalRows = total
+	pagination.TotalPages = int(math.Ceil(float64(total) / float64(pagination.GetLimit())))
+
+	return c.JSON(&model.Response{
+		Data:       pagination,
+		StatusCode: 200,
+	})
+}
+
+// ExportAuditLog streams the matched audit entries as NDJSON from the oldest one
+// @Summary  ExportAuditLog
+// @Param    user_id    query string false "user_id"
+// @Param    actor_id   query string false "actor_id"
+// @Param    action     query string false "action such as USER_UPDATED"
+// @Param    request_id query string false "request_id"
+// @Param    from       query string false "from (inclusive)"
+// @Param    to         query string false "to (exclusive)"
+// @Tags     Audit
+// @Produce  application/x-ndjson
+// @Router   /admin/audit/export [get]
+func (s AuditSvc) ExportAuditLog(c *fiber.Ctx) error {
+	filter, err := s.filter(c)
+	if err != nil {
+		return errorResponse(c, s.log, "ExportAuditLog", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.ndjson"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		err := s.repository.Export(filter, func(entry audit.Entry) error {
+			data, err := entry.Data()
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(data); err != nil {
+				return err
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			s.log.WithFields(log.Fields{"method": "ExportAuditLog"}).Errorf("An error occured while exporting %s \n", err.Error())
+		}
+	})
+	return nil
+}
+
+// filter returns the audit log filter of the query string
+func (s AuditSvc) filter(c *fiber.Ctx) (audit.Filter, error) {
+	var query auditQuery
+	if err := c.QueryParser(&query); err != nil {
+		return audit.Filter{}, apperror.Malformed(err)
+	}
+
+	filter := audit.Filter{
+		UserID:    query.UserID,
+		ActorID:   query.ActorID,
+		Action:    query.Action,
+		RequestID: query.RequestID,
+	}
+
+	var err error
+	if filter.From, err = parseTime("from", query.From); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTime("to", query.To); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseTime returns the time of given query value, empty values are zero
+func parseTime(field string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := util.ParseAsOf(value)
+	if err != nil {
+		return parsed, &apperror.Error{
+			Kind:       apperror.InvalidArgument,
+			Reason:     apperror.ReasonInvalidArgument,
+			Message:    "invalid " + field,
+			Violations: []apperror.FieldViolation{{Field: field, Description: "must be a RFC3339 timestamp, a date or unix seconds"}},
+			Err:        err,
+		}
+	}
+	return parsed, nil
+}
+
+func NewAuditService(rep audit.Repository, log *log.Entry) AuditService {
+	return &AuditSvc{
+		repository: rep,
+		log:        log,
+	}
+}
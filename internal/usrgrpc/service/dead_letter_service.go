@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"math"
+)
+
+type DeadLetterService interface {
+	ListDeadLetters(c *fiber.Ctx) error
+	GetDeadLetter(c *fiber.Ctx) error
+	RetryDeadLetter(c *fiber.Ctx) error
+	DiscardDeadLetter(c *fiber.Ctx) error
+}
+
+// A DeadLetterSvc lets the admins inspect the events which couldn't be processed by gRPC event server
+// Retried events are sent to gRPC event server again with their original correlation id
+type DeadLetterSvc struct {
+	repository repo.DeadLetterRepository
+	events     eventclient.EventClient
+	log        *log.Entry
+	configs    *user.AppConfig
+}
+
+// ListDeadLetters returns the dead letters from the oldest one
+// @Summary  ListDeadLetters
+// @Param    limit query number false "limit"
+// @Param    page  query number false "page"
+// @Tags     DeadLetter
+// @Router   /admin/dlq [get]
+func (s DeadLetterSvc) ListDeadLetters(c *fiber.Ctx) error {
+	var pagination common.Pagination
+	if err := c.QueryParser(&pagination); err != nil {
+		return errorResponse(c, s.log, "ListDeadLetters", apperror.Malformed(err))
+	}
+	if pagination.GetLimit() > 100 {
+		pagination.Limit = 100
+	}
+
+	records, total, err := s.repository.List(pagination.GetLimit(), pagination.GetOffset())
+	if err != nil {
+		return errorResponse(c, s.log, "ListDeadLetters", err)
+	}
+
+	items := make([]model.DeadLetterData, 0, len(records))
+	for _, record := range records {
+		items = append(items, toDeadLetterData(&record, nil))
+	}
+
+	pagination.Rows = items
+	pagination.TotalRows = total
+	pagination.TotalPages = int(math.Ceil(float64(total) / float64(pagination.GetLimit())))
+
+	return c.JSON(&model.Response{
+		Data:       pagination,
+		StatusCode: 200,
+	})
+}
+
+// GetDeadLetter returns the dead letter with its original event
+// @Summary  GetDeadLetter
+// @Param    id path string true "id"
+// @Tags     DeadLetter
+// @Router   /admin/dlq/{id} [get]
+func (s DeadLetterSvc) GetDeadLetter(c *fiber.Ctx) error {
+	record, event, err := s.repository.Get(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, s.log, "GetDeadLetter", notFound(err))
+	}
+
+	return c.JSON(&model.Response{
+		Data:       toDeadLetterData(record, event),
+		StatusCode: 200,
+	})
+}
+
+// RetryDeadLetter sends the event to gRPC event server again
+// Dead letter is removed when the event succeeds, otherwise its attempts are increased
+// Passwords are not stored with the dead letters, so the events which set a password are retried only with a resupplied one
+// @Summary  RetryDeadLetter
+// @Param    id path string true "id"
+// @Param    payload body model.RetryDeadLetter false "password of the event"
+// @Tags     DeadLetter
+// @Router   /admin/dlq/{id}/retry [post]
+func (s DeadLetterSvc) RetryDeadLetter(c *fiber.Ctx) error {
+	var payload model.RetryDeadLetter
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return errorResponse(c, s.log, "RetryDeadLetter", apperror.Malformed(err))
+		}
+	}
+
+	id := c.Params("id")
+	_, event, err := s.repository.Get(id)
+	if err != nil {
+		return errorResponse(c, s.log, "RetryDeadLetter", notFound(err))
+	}
+
+	if util.RequiresPassword(event) {
+		if payload.Password == "" {
+			return errorResponse(c, s.log, "RetryDeadLetter", &apperror.Error{
+				Kind:       apperror.InvalidArgument,
+				Reason:     apperror.ReasonInvalidArgument,
+				Message:    "password must be resupplied to retry the event",
+				Violations: []apperror.FieldViolation{{Field: "password", Description: "is required, passwords are not stored with the dead letters"}},
+			})
+		}
+		util.SetPassword(event, payload.Password)
+	}
+
+	timeout := s.configs.Grpc.CREATE_TIMEOUT
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+	defer cancel()
+
+	recv, err := s.events.Send(ctx, event)
+	if err == nil {
+		err = responseError(recv)
+	}
+	if err != nil {
+		// Server failures are counted by gRPC event server when it dead-letters the event again
+		appErr := apperror.Wrap(err)
+		if appErr.Kind != apperror.Internal && appErr.Kind != apperror.Unavailable {
+			if recordErr := s.repository.RecordAttempt(id, appErr); recordErr != nil {
+				s.log.WithFields(log.Fields{"method": "RetryDeadLetter"}).Errorf("Attempt couldn't be recorded %s \n", recordErr.Error())
+			}
+		}
+		return errorResponse(c, s.log, "RetryDeadLetter", appErr)
+	}
+
+	if err := s.repository.Delete(id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errorResponse(c, s.log, "RetryDeadLetter", err)
+	}
+
+	s.log.WithFields(log.Fields{"method": "RetryDeadLetter"}).Infof("Dead letter is processed %v \n", id)
+	return c.JSON(&model.Response{
+		Message:    "Event processed!",
+		StatusCode: 200,
+	})
+}
+
+// DiscardDeadLetter removes the dead letter without processing it
+// @Summary  DiscardDeadLetter
+// @Param    id path string true "id"
+// @Tags     DeadLetter
+// @Router   /admin/dlq/{id} [delete]
+func (s DeadLetterSvc) DiscardDeadLetter(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := s.repository.Delete(id); err != nil {
+		return errorResponse(c, s.log, "DiscardDeadLetter", notFound(err))
+	}
+
+	s.log.WithFields(log.Fields{"method": "DiscardDeadLetter"}).Infof("Dead letter is discarded %v \n", id)
+	return c.JSON(&model.Response{
+		Message:    "Dead letter discarded!",
+		StatusCode: 200,
+	})
+}
+
+// notFound returns the not found error of the dead letters instead of the one of the users
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperror.New(apperror.NotFound, apperror.ReasonDeadLetterNotFound, "dead letter not found")
+	}
+	return err
+}
+
+// toDeadLetterData returns the response of the dead letter, personal data of the event is redacted
+func toDeadLetterData(record *model.DeadLetter, event *pb.Events) model.DeadLetterData {
+	data := model.DeadLetterData{
+		ID:          record.ID,
+		EventName:   pb.EventName(record.EventName).String(),
+		AggregateId: record.AggregateId,
+		InternalId:  record.InternalId,
+		Reason:      record.Reason,
+		Error:       record.Error,
+		Attempts:    record.Attempts,
+		CreatedAt:   record.CreatedAt,
+		UpdatedAt:   record.UpdatedAt,
+	}
+
+	if event != nil {
+		data.PasswordRequired = util.RequiresPassword(event)
+		if raw, err := protojson.Marshal(redact(event)); err == nil {
+			data.Event = json.RawMessage(raw)
+		}
+	}
+	return data
+}
+
+// redacted replaces the personal data in the dead letter responses
+const redacted = "[REDACTED]"
+
+// redact returns a copy of the event whose email, names, password and client address are replaced
+// Nickname and the other fields are kept so that the admins can still tell the events apart
+func redact(event *pb.Events) *pb.Events {
+	event = proto.Clone(event).(*pb.Events)
+	hide := func(value *string) {
+		if *value != "" {
+			*value = redacted
+		}
+	}
+
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser:
+		hide(&payload.CreateUser.Email)
+		hide(&payload.CreateUser.Password)
+		hide(&payload.CreateUser.FirstName)
+		hide(&payload.CreateUser.LastName)
+	case *pb.Events_UpdateUser:
+		hide(&payload.UpdateUser.Email)
+		hide(&payload.UpdateUser.Password)
+		hide(&payload.UpdateUser.FirstName)
+		hide(&payload.UpdateUser.LastName)
+	case *pb.Events_ChangeEmail:
+		hide(&payload.ChangeEmail.Email)
+	case *pb.Events_ChangePassword:
+		hide(&payload.ChangePassword.Password)
+	}
+
+	event.EventData = nil
+	if event.Meta != nil {
+		hide(&event.Meta.Ip)
+		hide(&event.Meta.UserAgent)
+	}
+	return event
+}
+
+// registerDepthGauge exports the number of the dead letters, it is read on every scrape
+func registerDepthGauge(repository repo.DeadLetterRepository, _log *log.Entry) {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "usrgrpc_dead_letter_depth",
+		Help: "Number of the events which couldn't be processed by gRPC event server",
+	}, func() float64 {
+		total, err := repository.Count()
+		if err != nil {
+			_log.WithFields(log.Fields{"method": "registerDepthGauge"}).Errorf("Dead letters couldn't be counted %s \n", err.Error())
+			return 0
+		}
+		return float64(total)
+	})
+
+	if err := prometheus.Register(gauge); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &alreadyRegistered) {
+			_log.WithFields(log.Fields{"method": "registerDepthGauge"}).Errorf("Gauge couldn't be registered %s \n", err.Error())
+		}
+	}
+}
+
+func NewDeadLetterService(rep repo.DeadLetterRepository, events eventclient.EventClient, log *log.Entry, configs *user.AppConfig) DeadLetterService {
+	registerDepthGauge(rep, log)
+	return &DeadLetterSvc{
+		repository: rep,
+		events:     events,
+		log:        log,
+		configs:    configs,
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/export"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type ExportService interface {
+	ExportUser(c *fiber.Ctx) error
+	GetExport(c *fiber.Ctx) error
+	DownloadExport(c *fiber.Ctx) error
+}
+
+// An ExportSvc answers the subject-access requests with an archive of all records of a user
+type ExportSvc struct {
+	users    domain.UserRepository
+	jobs     repo.ExportRepository
+	exporter export.Exporter
+	log      *log.Entry
+}
+
+// ExportUser starts the data export of the user, the archive is generated in the background
+// The response contains the export whose status can be followed until it is downloadable
+// @Summary  ExportUser
+// @Param    id path string true "id"
+// @Tags     Export
+// @Router   /{id}/export [get]
+func (s ExportSvc) ExportUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return errorResponse(c, s.log, "ExportUser", apperror.InvalidID(id))
+	}
+
+	if _, err := s.users.GetUserByID(id); err != nil {
+		return errorResponse(c, s.log, "ExportUser", err)
+	}
+
+	job, err := s.exporter.Start(id)
+	if err != nil {
+		return errorResponse(c, s.log, "ExportUser", err)
+	}
+
+	data := s.data(c, job)
+	c.Location(fmt.Sprintf("%s/%s", c.Path(), job.ID))
+	return c.Status(fiber.StatusAccepted).JSON(&model.Response{
+		Message:    "Export started!",
+		Data:       data,
+		StatusCode: fiber.StatusAccepted,
+	})
+}
+
+// GetExport returns the status of the data export
+// @Summary  GetExport
+// @Param    id       path string true "id"
+// @Param    exportId path string true "exportId"
+// @Tags     Export
+// @Router   /{id}/export/{exportId} [get]
+func (s ExportSvc) GetExport(c *fiber.Ctx) error {
+	job, err := s.job(c)
+	if err != nil {
+		return errorResponse(c, s.log, "GetExport", err)
+	}
+
+	return c.JSON(&model.Response{
+		Data:       s.data(c, job),
+		StatusCode: 200,
+	})
+}
+
+// DownloadExport returns the zip archive of a finished data export, the archive can only be downloaded once
+// @Summary  DownloadExport
+// @Param    id       path string true "id"
+// @Param    exportId path string true "exportId"
+// @Tags     Export
+// @Produce  application/zip
+// @Router   /{id}/export/{exportId}/download [get]
+func (s ExportSvc) DownloadExport(c *fiber.Ctx) error {
+	job, err := s.job(c)
+	if err != nil {
+		return errorResponse(c, s.log, "DownloadExport", err)
+	}
+
+	if job.Status != model.ExportDone {
+		return errorResponse(c, s.log, "DownloadExport", apperror.New(apperror.FailedPrecondition, apperror.ReasonExportNotReady, "export is "+job.Status))
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-%s.zip"`, job.UserID))
+	if err := c.Send(job.Archive); err != nil {
+		return err
+	}
+
+	// the archive contains the personal data of the user, it is not kept after it is downloaded
+	if err := s.jobs.Expire(job.ID); err != nil {
+		s.log.WithFields(log.Fields{"method": "DownloadExport"}).Errorf("An error occurred when expiring the export %s", err.Error())
+	}
+	return nil
+}
+
+// job returns the export in the path, exports of the other users are not found
+func (s ExportSvc) job(c *fiber.Ctx) (*model.Export, error) {
+	notFound := apperror.New(apperror.NotFound, apperror.ReasonExportNotFound, "export not found")
+
+	job, err := s.jobs.Get(c.Params("exportId"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != c.Params("id") {
+		return nil, notFound
+	}
+	return job, nil
+}
+
+func (s ExportSvc) data(c *fiber.Ctx, job *model.Export) model.ExportData {
+	data := model.ExportData{
+		ID:          job.ID,
+		UserID:      job.UserID,
+		Status:      job.Status,
+		Error:       job.Error,
+		Size:        len(job.Archive),
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+	}
+	if job.Status == model.ExportDone {
+		data.DownloadURL = fmt.Sprintf("%s/api/v1/user/%s/export/%s/download", c.BaseURL(), job.UserID, job.ID)
+	}
+	return data
+}
+
+func NewExportService(users domain.UserRepository, jobs repo.ExportRepository, exporter export.Exporter, log *log.Entry) ExportService {
+	return &ExportSvc{
+		users:    users,
+		jobs:     jobs,
+		exporter: exporter,
+		log:      log,
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+type TrashService interface {
+	ListTrash(c *fiber.Ctx) error
+}
+
+// A TrashSvc lets the admins see the deleted users before they are purged
+type TrashSvc struct {
+	repository domain.UserRepository
+	log        *log.Entry
+}
+
+// ListTrash returns the deleted users from the most recently deleted one
+// @Summary  ListTrash
+// @Param    limit query number false "limit"
+// @Param    page  query number false "page"
+// @Tags     Trash
+// @Router   /admin/trash [get]
+func (s TrashSvc) ListTrash(c *fiber.Ctx) error {
+	var pagination common.Pagination
+	if err := c.QueryParser(&pagination); err != nil {
+		return errorResponse(c, s.log, "ListTrash", apperror.Malformed(err))
+	}
+	if pagination.GetLimit() > 100 {
+		pagination.Limit = 100
+	}
+
+	result, err := s.repository.GetDeletedUsers(pagination)
+	if err != nil {
+		return errorResponse(c, s.log, "ListTrash", err)
+	}
+
+	users, _ := result.Rows.([]domain.User)
+	items := make([]domain.DeletedUserData, 0, len(users))
+	for _, user := range users {
+		items = append(items, domain.DeletedUserData{
+			UserData: domain.UserData{
+				ID:        user.ID,
+				NickName:  user.NickName,
+				Email:     user.Email,
+				FirstName: user.FirstName,
+				LastName:  user.LastName,
+				Country:   user.Country,
+				Suspended: user.Suspended,
+				Role:      user.Role,
+			},
+			DeletedAt: user.Deleted.Time,
+		})
+	}
+	result.Rows = items
+
+	return c.JSON(&model.Response{
+		Data:       result,
+		StatusCode: 200,
+	})
+}
+
+func NewTrashService(rep domain.UserRepository, log *log.Entry) TrashService {
+	return &TrashSvc{
+		repository: rep,
+		log:        log,
+	}
+}
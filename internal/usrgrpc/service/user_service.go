@@ -5,41 +5,66 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
-	_ "github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
 	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
 	pb "github.com/cemayan/faceit-technical-test/protos/event"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/status"
+	"time"
 )
 
+// defaultTimeout is used when the deadline of the operation is not configured
+const defaultTimeout = 5 * time.Second
+
+// readinessTimeout is the deadline of the health check of gRPC event server
+const readinessTimeout = time.Second
+
 type GrpcUserService interface {
 	HashPassword(password string) (string, error)
 	GetUser(c *fiber.Ctx) error
+	GetUserHistory(c *fiber.Ctx) error
 	GetAllUser(c *fiber.Ctx) error
 	CreateUser(c *fiber.Ctx) error
 	UpdateUser(c *fiber.Ctx) error
 	DeleteUser(c *fiber.Ctx) error
+	ChangePassword(c *fiber.Ctx) error
+	ChangeEmail(c *fiber.Ctx) error
+	SuspendUser(c *fiber.Ctx) error
+	ReactivateUser(c *fiber.Ctx) error
+	RestoreUser(c *fiber.Ctx) error
+	EraseUser(c *fiber.Ctx) error
+	ChangeRole(c *fiber.Ctx) error
+	HealthCheck(c *fiber.Ctx) error
+	ReadinessCheck(c *fiber.Ctx) error
 }
 
 // A GrpcUserSvc  contains the required dependencies for this service
 type GrpcUserSvc struct {
-	repository repo.GrpcUserRepository
+	users      domain.UserService
+	changes    domain.UserInvalidator
+	aggregates repo.UserAggregateRepository
 	validate   *validator.Validate
 	log        *log.Entry
-	grpcClient pb.EventGrpcServiceClient
+	events     eventclient.EventClient
+	health     eventclient.HealthChecker
 	configs    *user.AppConfig
 }
 
 // HashPassword returns encrypted password based on given password
 func (s GrpcUserSvc) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return domain.HashPassword(password)
 }
 
 // GetAllUser returns filtered users based on given payload
@@ -56,21 +81,13 @@ func (s GrpcUserSvc) GetAllUser(c *fiber.Ctx) error {
 	var pagination common.Pagination
 	err := c.QueryParser(&pagination)
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "GetAllUser"}).Errorf(fmt.Sprintf("An error occured %s \n", err))
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("An error occured %s", err),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, "GetAllUser", apperror.Malformed(err))
 	}
 
-	result, err := s.repository.GetAllUser(pagination)
+	result, err := s.users.ListUsers(pagination)
 
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "GetAllUser"}).Errorf(fmt.Sprintf("An error occured %s \n", err))
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("An error occured %s", err),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, "GetAllUser", err)
 	}
 
 	return c.JSON(&model.Response{
@@ -80,217 +97,174 @@ func (s GrpcUserSvc) GetAllUser(c *fiber.Ctx) error {
 }
 
 // HealthCheck returns 200 with body
+// It returns 503 while the circuit breaker in front of gRPC event server is open
 func (s GrpcUserSvc) HealthCheck(c *fiber.Ctx) error {
-	return c.Status(fiber.StatusOK).JSON("UP!")
+	state := s.events.State()
+	if state == eventclient.Open {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "DOWN!", "circuit": state.String()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "UP!", "circuit": state.String()})
+}
+
+// ReadinessCheck returns 200 when gRPC event server reports that it is serving
+func (s GrpcUserSvc) ReadinessCheck(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), readinessTimeout)
+	defer cancel()
+
+	if err := s.health.Serving(ctx); err != nil {
+		s.log.WithFields(log.Fields{"method": "ReadinessCheck"}).Errorf("gRPC event server is not ready %s \n", err.Error())
+		return c.Status(fiber.StatusServiceUnavailable).JSON("NOT READY!")
+	}
+	return c.Status(fiber.StatusOK).JSON("READY!")
 }
 
 // GetUser returns user based on given id.
+// When asOf is given the user is rebuilt from its events as it was at that time
 // @Summary  GetUser
-// @Param    id path string true "id"
+// @Param    id   path  string true  "id"
+// @Param    asOf query string false "RFC3339 timestamp, date or unix seconds"
 // @Tags     User
 // @Router   /{id} [get]
 func (s GrpcUserSvc) GetUser(c *fiber.Ctx) error {
 
 	id := c.Params("id")
-	userModel, err := s.repository.GetUserByID(id)
-	if userModel == nil || err != nil {
-		s.log.WithFields(log.Fields{"method": "GetUser"}).Errorf("No user found with %s \n", id)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("No user found with %v", id),
-			StatusCode: 400,
-		})
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, "GetUser", apperror.InvalidID(id))
+	}
+
+	if value := c.Query("asOf"); value != "" {
+		asOf, err := util.ParseAsOf(value)
+		if err != nil {
+			return s.errorResponse(c, "GetUser", apperror.InvalidAsOf(value))
+		}
+
+		state, err := s.aggregates.LoadAsOf(id, asOf)
+		if err != nil {
+			return s.errorResponse(c, "GetUser", err)
+		}
+		return c.JSON(model.Response{StatusCode: 200, Data: state})
+	}
+
+	userModel, err := s.users.GetUser(id)
+	if err != nil {
+		return s.errorResponse(c, "GetUser", err)
 	}
 
 	return c.JSON(model.Response{
 		StatusCode: 200,
-		Data: model.UserData{
-			ID:        userModel.ID,
-			NickName:  userModel.NickName,
-			Email:     userModel.Email,
-			FirstName: userModel.FirstName,
-			LastName:  userModel.LastName,
-			Country:   userModel.Country,
-		},
+		Data:       userModel.Data(),
 	})
 }
 
+// GetUserHistory returns the field-level changes of the user with their dates and actors
+// @Summary  GetUserHistory
+// @Param    id path string true "id"
+// @Tags     User
+// @Router   /{id}/history [get]
+func (s GrpcUserSvc) GetUserHistory(c *fiber.Ctx) error {
+
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, "GetUserHistory", apperror.InvalidID(id))
+	}
+
+	entries, err := s.aggregates.History(id)
+	if err != nil {
+		return s.errorResponse(c, "GetUserHistory", err)
+	}
+
+	return c.JSON(model.Response{StatusCode: 200, Data: entries})
+}
+
 // CreateUser creates new user based on given payload
 // While user is creating password is encrypted then it is assigned as a password
 // @Summary  CreateUser
-// @Param    request body model.User true "query params"
+// @Param    request body domain.User true "query params"
 // @Tags     User
 // @Router   / [post]
 func (s GrpcUserSvc) CreateUser(c *fiber.Ctx) error {
 
-	userReq := new(model.User)
+	userReq := new(domain.User)
 	if err := c.BodyParser(userReq); err != nil {
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Review your input %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("Review your input %s", err),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, "CreateUser", apperror.Malformed(err))
 	}
 
-	err := s.validate.Struct(userReq)
-
+	err := s.users.Validate(userReq)
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Review your payload %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("Review your input %s", err),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, "CreateUser", err)
 	}
 
-	handleEvent, err := s.grpcClient.HandleEvent(context.Background())
-	if err != nil {
-		return err
-	}
+	ctx, cancel := s.withTimeout(c, s.configs.Grpc.CREATE_TIMEOUT)
+	defer cancel()
 
-	event := &pb.Events{
+	recv, err := s.send(c, ctx, false, &pb.Events{
 		AggregateId:   uuid.New().String(),
 		AggregateType: 0,
-		EventData:     c.Body(),
 		EventDate:     util.GetTime(),
 		EventName:     pb.EventName_USER_CREATED,
-	}
-	err = handleEvent.Send(event)
+		Payload:       &pb.Events_CreateUser{CreateUser: util.NewCreateUserPayload(userReq)},
+	})
 	if err != nil {
-		if err != nil {
-			s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("An error occured  %s \n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-		}
+		return s.errorResponse(c, "CreateUser", err)
 	}
 
-	recv, err := handleEvent.Recv()
+	userResp, err := s.responseUser(recv)
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("An error occured  %s \n", err)
-		return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-			Message:    err.Error(),
-			StatusCode: 400,
-		})
-	} else {
-
-		if recv.StatusCode >= 400 {
-			s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("An error occured %s \n", string(recv.Data))
-			return c.Status(int(recv.StatusCode)).JSON(&model.Response{
-				Data:       string(recv.Data),
-				Message:    recv.Message,
-				StatusCode: int(recv.StatusCode),
-			})
-		}
-
-		var userResp model.User
-		err = json.Unmarshal(recv.Data, &userResp)
-		if err != nil {
-			s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Couldn't unmarshall to recv.Data %s \n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-		}
+		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Couldn't unmarshall to recv.Data %s \n", err)
+		return s.errorResponse(c, "CreateUser", err)
+	}
 
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Infof("User created %v \n", userResp)
-		return c.Status(fiber.StatusCreated).JSON(&model.Response{
-			Message: "User created!",
-			Data: model.UserData{
-				ID:        userResp.ID,
-				NickName:  userResp.NickName,
-				Email:     userResp.Email,
-				FirstName: userResp.FirstName,
-				LastName:  userResp.LastName,
-				Country:   userResp.Country,
-			},
-			StatusCode: 201,
-		})
+	// the user is created by gRPC event server, its reads are sent to the primary until the replicas have it
+	if s.changes != nil {
+		s.changes.Invalidate(userResp.ID.String())
 	}
+
+	s.log.WithFields(log.Fields{"method": "CreateUser"}).Infof("User created %v \n", userResp)
+	return c.Status(fiber.StatusCreated).JSON(&model.Response{
+		Message:    "User created!",
+		Data:       userResp,
+		StatusCode: 201,
+	})
 }
 
 // UpdateUser return updated user based on given payload
 // @Summary  UpdateUser
 // @Param    id      path string         true "id"
-// @Param    request body dto.UpdateUser true "query params"
+// @Param    request body domain.UpdateUser true "query params"
 // @Tags     User
 // @Router   /{id} [put]
 func (s GrpcUserSvc) UpdateUser(c *fiber.Ctx) error {
 
-	userReq := new(model.User)
+	userReq := new(domain.User)
 	if err := c.BodyParser(userReq); err != nil {
-		s.log.WithFields(log.Fields{"method": "CreateUser"}).Errorf("Review your input %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			Message:    fmt.Sprintf("Review your input %s", err),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, "UpdateUser", apperror.Malformed(err))
 	}
 
 	id := c.Params("id")
-	if id == "" {
-		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("Review your query param %s \n", id)
-		return c.Status(fiber.StatusBadRequest).JSON(model.Response{
-			StatusCode: 400,
-			Message:    fmt.Sprintf("Review your id %v", id),
-		})
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, "UpdateUser", apperror.InvalidID(id))
 	}
 
-	handleEvent, err := s.grpcClient.HandleEvent(context.Background())
-	if err != nil {
-		return err
-	}
+	ctx, cancel := s.withTimeout(c, s.configs.Grpc.UPDATE_TIMEOUT)
+	defer cancel()
 
-	event := &pb.Events{
+	_, err := s.send(c, ctx, true, &pb.Events{
 		AggregateId:   uuid.New().String(),
 		AggregateType: 0,
-		EventData:     c.Body(),
 		EventDate:     util.GetTime(),
 		EventName:     pb.EventName_USER_UPDATED,
 		InternalId:    id,
-	}
-	err = handleEvent.Send(event)
-	if err != nil {
-		if err != nil {
-			s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("An error occured  %s \n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-				Message:    err.Error(),
-				StatusCode: 400,
-			})
-		}
-	}
-
-	recv, err := handleEvent.Recv()
+		Payload:       &pb.Events_UpdateUser{UpdateUser: util.NewUpdateUserPayload(id, userReq)},
+	})
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("An error occured  %s \n", err)
-		return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-			Message:    err.Error(),
-			StatusCode: 400,
-		})
-	} else {
-
-		if recv.StatusCode >= 400 {
-			s.log.WithFields(log.Fields{"method": "UpdateUser"}).Errorf("An error occured %s \n", string(recv.Data))
-
-			if string(recv.Data) != "" {
-				return c.Status(int(recv.StatusCode)).JSON(&model.Response{
-					Data:       string(recv.Data),
-					Message:    recv.Message,
-					StatusCode: int(recv.StatusCode),
-				})
-			} else {
-				return c.Status(int(recv.StatusCode)).JSON(&model.Response{
-					Message:    recv.Message,
-					StatusCode: int(recv.StatusCode),
-				})
-			}
-		}
-
-		s.log.WithFields(log.Fields{"method": "UpdateUser"}).Infof("User successfully updated \n")
-		return c.Status(fiber.StatusOK).JSON(&model.Response{
-			Message:    "User updated!",
-			StatusCode: 200,
-		})
+		return s.errorResponse(c, "UpdateUser", err)
 	}
 
+	s.log.WithFields(log.Fields{"method": "UpdateUser"}).Infof("User successfully updated \n")
+	return c.Status(fiber.StatusOK).JSON(&model.Response{
+		Message:    "User updated!",
+		StatusCode: 200,
+	})
 }
 
 // DeleteUser removes  the user based on given payload
@@ -301,69 +275,315 @@ func (s GrpcUserSvc) UpdateUser(c *fiber.Ctx) error {
 func (s GrpcUserSvc) DeleteUser(c *fiber.Ctx) error {
 
 	id := c.Params("id")
-
-	handleEvent, err := s.grpcClient.HandleEvent(context.Background())
-	if err != nil {
-		s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("An error occured \n")
-		return err
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, "DeleteUser", apperror.InvalidID(id))
 	}
 
-	event := &pb.Events{
+	ctx, cancel := s.withTimeout(c, s.configs.Grpc.DELETE_TIMEOUT)
+	defer cancel()
+
+	_, err := s.send(c, ctx, true, &pb.Events{
 		AggregateId:   uuid.New().String(),
 		AggregateType: 0,
-		EventData:     c.Body(),
 		EventDate:     util.GetTime(),
 		EventName:     pb.EventName_USER_DELETED,
 		InternalId:    id,
+		Payload:       &pb.Events_DeleteUser{DeleteUser: &pb.DeleteUser{Id: id}},
+	})
+	if err != nil {
+		return s.errorResponse(c, "DeleteUser", err)
+	}
+
+	s.log.WithFields(log.Fields{"method": "DeleteUser"}).Infof("User successfully deleted %v \n", id)
+	return c.Status(fiber.StatusOK).JSON(&model.Response{
+		Message:    "User successfully deleted!",
+		StatusCode: 200,
+	})
+}
+
+// EraseUser irreversibly anonymizes the user and scrubs its personal data, response contains the erasure receipt
+// @Summary  EraseUser
+// @Param    id path string true "id"
+// @Tags     User
+// @Router   /{id}/erase [post]
+func (s GrpcUserSvc) EraseUser(c *fiber.Ctx) error {
+
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, "EraseUser", apperror.InvalidID(id))
+	}
+
+	ctx, cancel := s.withTimeout(c, s.configs.Grpc.DELETE_TIMEOUT)
+	defer cancel()
+
+	recv, err := s.send(c, ctx, true, &pb.Events{
+		AggregateId: uuid.New().String(),
+		EventDate:   util.GetTime(),
+		EventName:   pb.EventName_USER_ERASED,
+		InternalId:  id,
+		Payload:     &pb.Events_EraseUser{EraseUser: &pb.EraseUser{Id: id}},
+	})
+	if err != nil {
+		return s.errorResponse(c, "EraseUser", err)
+	}
+
+	var receipt erasure.Receipt
+	if err := json.Unmarshal(recv.Data, &receipt); err != nil {
+		return s.errorResponse(c, "EraseUser", err)
+	}
+
+	s.log.WithFields(log.Fields{"method": "EraseUser"}).Infof("User successfully erased %v \n", id)
+	return c.Status(fiber.StatusOK).JSON(&model.Response{
+		Message:    "User successfully erased!",
+		Data:       receipt,
+		StatusCode: 200,
+	})
+}
+
+// ChangePassword replaces the password of the user
+// @Summary  ChangePassword
+// @Param    id      path string             true "id"
+// @Param    request body dto.ChangePassword true "query params"
+// @Tags     User
+// @Router   /{id}/password [put]
+func (s GrpcUserSvc) ChangePassword(c *fiber.Ctx) error {
+	req := new(dto.ChangePassword)
+	if err := s.parseBody(c, req); err != nil {
+		return s.errorResponse(c, "ChangePassword", err)
+	}
+
+	id := c.Params("id")
+	return s.sendLifecycle(c, "ChangePassword", id, true, "Password changed!", &pb.Events{
+		EventName: pb.EventName_USER_PASSWORD_CHANGED,
+		Payload:   &pb.Events_ChangePassword{ChangePassword: &pb.ChangePassword{Id: id, Password: req.Password}},
+	})
+}
+
+// ChangeEmail replaces the email of the user
+// @Summary  ChangeEmail
+// @Param    id      path string          true "id"
+// @Param    request body dto.ChangeEmail true "query params"
+// @Tags     User
+// @Router   /{id}/email [put]
+func (s GrpcUserSvc) ChangeEmail(c *fiber.Ctx) error {
+	req := new(dto.ChangeEmail)
+	if err := s.parseBody(c, req); err != nil {
+		return s.errorResponse(c, "ChangeEmail", err)
+	}
+
+	id := c.Params("id")
+	return s.sendLifecycle(c, "ChangeEmail", id, true, "Email changed!", &pb.Events{
+		EventName: pb.EventName_USER_EMAIL_CHANGED,
+		Payload:   &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Id: id, Email: req.Email}},
+	})
+}
+
+// SuspendUser suspends an active user
+// @Summary  SuspendUser
+// @Param    id      path string          true "id"
+// @Param    request body dto.SuspendUser false "query params"
+// @Tags     User
+// @Router   /{id}/suspend [post]
+func (s GrpcUserSvc) SuspendUser(c *fiber.Ctx) error {
+	req := new(dto.SuspendUser)
+	if len(c.Body()) > 0 {
+		if err := s.parseBody(c, req); err != nil {
+			return s.errorResponse(c, "SuspendUser", err)
+		}
+	}
+
+	id := c.Params("id")
+	return s.sendLifecycle(c, "SuspendUser", id, false, "User suspended!", &pb.Events{
+		EventName: pb.EventName_USER_SUSPENDED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: id, Reason: req.Reason}},
+	})
+}
+
+// ReactivateUser reactivates a suspended user
+// @Summary  ReactivateUser
+// @Param    id path string true "id"
+// @Tags     User
+// @Router   /{id}/reactivate [post]
+func (s GrpcUserSvc) ReactivateUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+	return s.sendLifecycle(c, "ReactivateUser", id, false, "User reactivated!", &pb.Events{
+		EventName: pb.EventName_USER_REACTIVATED,
+		Payload:   &pb.Events_ReactivateUser{ReactivateUser: &pb.ReactivateUser{Id: id}},
+	})
+}
+
+// RestoreUser brings back a deleted user
+// @Summary  RestoreUser
+// @Param    id path string true "id"
+// @Tags     User
+// @Router   /{id}/restore [post]
+func (s GrpcUserSvc) RestoreUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+	return s.sendLifecycle(c, "RestoreUser", id, false, "User restored!", &pb.Events{
+		EventName: pb.EventName_USER_RESTORED,
+		Payload:   &pb.Events_RestoreUser{RestoreUser: &pb.RestoreUser{Id: id}},
+	})
+}
+
+// ChangeRole replaces the role of the user
+// @Summary  ChangeRole
+// @Param    id      path string         true "id"
+// @Param    request body dto.ChangeRole true "query params"
+// @Tags     User
+// @Router   /{id}/role [put]
+func (s GrpcUserSvc) ChangeRole(c *fiber.Ctx) error {
+	req := new(dto.ChangeRole)
+	if err := s.parseBody(c, req); err != nil {
+		return s.errorResponse(c, "ChangeRole", err)
+	}
+
+	id := c.Params("id")
+	return s.sendLifecycle(c, "ChangeRole", id, true, "Role changed!", &pb.Events{
+		EventName: pb.EventName_USER_ROLE_CHANGED,
+		Payload:   &pb.Events_ChangeRole{ChangeRole: &pb.ChangeRole{Id: id, Role: util.ToProtoRole(req.Role)}},
+	})
+}
+
+// parseBody parses and validates the request body
+func (s GrpcUserSvc) parseBody(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		return apperror.Malformed(err)
 	}
-	err = handleEvent.Send(event)
+	return s.validate.Struct(req)
+}
+
+// sendLifecycle sends the lifecycle event of the user and returns the changed user
+// Events which set a value are idempotent, so they are retried
+func (s GrpcUserSvc) sendLifecycle(c *fiber.Ctx, method string, id string, idempotent bool, message string, event *pb.Events) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return s.errorResponse(c, method, apperror.InvalidID(id))
+	}
+
+	ctx, cancel := s.withTimeout(c, s.configs.Grpc.UPDATE_TIMEOUT)
+	defer cancel()
+
+	event.AggregateId = uuid.New().String()
+	event.EventDate = util.GetTime()
+	event.InternalId = id
+
+	recv, err := s.send(c, ctx, idempotent, event)
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("An error occured  %s \n", err)
-		return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-			Message:    err.Error(),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, method, err)
 	}
 
-	recv, err := handleEvent.Recv()
+	userResp, err := s.responseUser(recv)
 	if err != nil {
-		s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("An error occured  %s \n", err)
-		return c.Status(fiber.StatusBadRequest).JSON(&model.Response{
-			Message:    err.Error(),
-			StatusCode: 400,
-		})
+		return s.errorResponse(c, method, err)
+	}
+
+	s.log.WithFields(log.Fields{"method": method}).Infof("%s %v \n", message, id)
+	return c.Status(fiber.StatusOK).JSON(&model.Response{
+		Message:    message,
+		Data:       userResp,
+		StatusCode: 200,
+	})
+}
+
+// withTimeout returns the request context with the deadline of the operation
+func (s GrpcUserSvc) withTimeout(c *fiber.Ctx, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(c.UserContext(), timeout)
+}
+
+// send sends the event to gRPC event server over the shared streams and returns its response
+// Idempotent events are retried when gRPC event server is unavailable
+// Failed responses are returned as domain errors which are built from their gRPC status
+// Actor and request meta are sent with the event so that it is recorded in the audit log
+// Changed user is invalidated even when the call fails, the event server may have applied it before the deadline
+func (s GrpcUserSvc) send(c *fiber.Ctx, ctx context.Context, idempotent bool, event *pb.Events) (*pb.Response, error) {
+	event.SchemaVersion = schema.CurrentVersion
+	if s.changes != nil && event.InternalId != "" {
+		defer s.changes.Invalidate(event.InternalId)
+	}
+
+	meta := audit.MetaFromRequest(c)
+	event.Actor = meta.ActorID
+	event.Meta = &pb.RequestMeta{Ip: meta.IP, UserAgent: meta.UserAgent, RequestId: meta.RequestID}
+
+	var recv *pb.Response
+	var err error
+	if idempotent {
+		recv, err = s.events.SendIdempotent(ctx, event)
 	} else {
+		recv, err = s.events.Send(ctx, event)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		if recv.StatusCode >= 400 {
-			s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("An error occured %s \n", string(recv.Data))
-			if string(recv.Data) != "" {
-				return c.Status(int(recv.StatusCode)).JSON(&model.Response{
-					Data:       string(recv.Data),
-					Message:    recv.Message,
-					StatusCode: int(recv.StatusCode),
-				})
-			} else {
-				return c.Status(int(recv.StatusCode)).JSON(&model.Response{
-					Message:    recv.Message,
-					StatusCode: int(recv.StatusCode),
-				})
-			}
-		}
+	if err := responseError(recv); err != nil {
+		return nil, err
+	}
+	return recv, nil
+}
+
+// errorResponse returns the HTTP status code and the body of given error
+func (s GrpcUserSvc) errorResponse(c *fiber.Ctx, method string, err error) error {
+	return errorResponse(c, s.log, method, err)
+}
+
+// responseError returns the domain error which is built from the gRPC status of a failed response
+func responseError(recv *pb.Response) error {
+	if recv.StatusCode < 400 {
+		return nil
+	}
 
-		s.log.WithFields(log.Fields{"method": "DeleteUser"}).Errorf("User successfully deleted %v \n", id)
-		return c.Status(fiber.StatusOK).JSON(&model.Response{
-			Message:    "User successfully deleted!",
-			StatusCode: 200,
-		})
+	if recv.Status != nil {
+		return apperror.FromStatus(status.FromProto(recv.Status))
+	}
+	return &apperror.Error{
+		Kind:    apperror.InvalidArgument,
+		Reason:  apperror.ReasonInvalidArgument,
+		Message: fmt.Sprintf("%s%s", recv.Message, string(recv.Data)),
+	}
+}
+
+// errorResponse returns the HTTP status code and the body of given error
+// Field violations are returned in errors so that clients can show them next to the fields
+func errorResponse(c *fiber.Ctx, _log *log.Entry, method string, err error) error {
+	appErr := apperror.Wrap(err)
+	_log.WithFields(log.Fields{"method": method}).Errorf("An error occured %s \n", appErr.Error())
+
+	return c.Status(appErr.HTTPStatus()).JSON(&model.Response{
+		Message:    appErr.Message,
+		Reason:     appErr.Reason,
+		Errors:     appErr.Violations,
+		StatusCode: appErr.HTTPStatus(),
+	})
+}
+
+// responseUser returns the user in the gRPC response
+// Servers which are not upgraded yet only fill the legacy JSON data
+func (s GrpcUserSvc) responseUser(recv *pb.Response) (domain.UserData, error) {
+	if recv.User != nil {
+		return util.FromProtoUser(recv.User), nil
+	}
+
+	var userResp domain.User
+	err := json.Unmarshal(recv.Data, &userResp)
+	if err != nil {
+		return domain.UserData{}, err
 	}
 
+	return userResp.Data(), nil
 }
 
-func NewGrpcUserService(rep repo.GrpcUserRepository, validate *validator.Validate, grpcClient pb.EventGrpcServiceClient, log *log.Entry, configs *user.AppConfig) GrpcUserService {
+// NewGrpcUserService returns the service, changes is told about the users which are changed by the events, it can be nil
+func NewGrpcUserService(users domain.UserService, changes domain.UserInvalidator, aggregates repo.UserAggregateRepository, validate *validator.Validate, events eventclient.EventClient, health eventclient.HealthChecker, log *log.Entry, configs *user.AppConfig) GrpcUserService {
 	return &GrpcUserSvc{
-		repository: rep,
+		users:      users,
+		changes:    changes,
+		aggregates: aggregates,
 		validate:   validate,
-		grpcClient: grpcClient,
+		events:     events,
+		health:     health,
 		log:        log,
 		configs:    configs,
 	}
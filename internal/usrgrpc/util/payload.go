@@ -0,0 +1,201 @@
+package util
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"strings"
+)
+
+// NewCreateUserPayload returns the typed create payload of given user
+func NewCreateUserPayload(user *domain.User) *pb.CreateUser {
+	return &pb.CreateUser{
+		Nickname:  user.NickName,
+		Email:     user.Email,
+		Password:  user.Password,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Country:   user.Country,
+	}
+}
+
+// NewUpdateUserPayload returns the typed update payload of given user
+// Only the non-empty fields are added to update_mask as the legacy payload did
+func NewUpdateUserPayload(id string, user *domain.User) *pb.UpdateUser {
+	payload := &pb.UpdateUser{
+		Id:        id,
+		Nickname:  user.NickName,
+		Email:     user.Email,
+		Password:  user.Password,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Country:   user.Country,
+	}
+	FillUpdateMask(payload)
+	return payload
+}
+
+// FillUpdateMask sets update_mask of an unmasked payload to its non-empty fields, which are the fields that it changes
+func FillUpdateMask(payload *pb.UpdateUser) {
+	if len(payload.GetUpdateMask().GetPaths()) > 0 {
+		return
+	}
+
+	var paths []string
+	fields := []struct {
+		path  string
+		value string
+	}{
+		{"nickname", payload.Nickname},
+		{"email", payload.Email},
+		{"password", payload.Password},
+		{"first_name", payload.FirstName},
+		{"last_name", payload.LastName},
+		{"country", payload.Country},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			paths = append(paths, field.path)
+		}
+	}
+	payload.UpdateMask = &fieldmaskpb.FieldMask{Paths: paths}
+}
+
+// ClearPasswords removes the plaintext passwords from the payload of the event before it is persisted
+func ClearPasswords(event *pb.Events) {
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser:
+		payload.CreateUser.Password = ""
+	case *pb.Events_UpdateUser:
+		payload.UpdateUser.Password = ""
+	case *pb.Events_ChangePassword:
+		payload.ChangePassword.Password = ""
+	}
+}
+
+// RequiresPassword reports whether the event can't be applied without a password, the update payload must be masked
+func RequiresPassword(event *pb.Events) bool {
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser, *pb.Events_ChangePassword:
+		return true
+	case *pb.Events_UpdateUser:
+		for _, path := range payload.UpdateUser.GetUpdateMask().GetPaths() {
+			if path == "password" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetPassword puts the password into the payload of the event which requires it
+func SetPassword(event *pb.Events, password string) {
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser:
+		payload.CreateUser.Password = password
+	case *pb.Events_UpdateUser:
+		payload.UpdateUser.Password = password
+	case *pb.Events_ChangePassword:
+		payload.ChangePassword.Password = password
+	}
+}
+
+// DerivedEvents returns the specific events of an applied update, so that consumers don't need to diff the users
+// They are derived from the fields which the update changed, password is never put in the events
+// The events carry the actor and the request meta of the update
+func DerivedEvents(update *domain.UpdateUser, userID string, event *pb.Events) []*pb.Events {
+	var events []*pb.Events
+	for _, path := range update.Changed {
+		derived := &pb.Events{
+			AggregateId: uuid.New().String(),
+			EventDate:   GetTime(),
+			InternalId:  userID,
+			Actor:       event.Actor,
+			Meta:        event.Meta,
+		}
+
+		switch path {
+		case "email":
+			derived.EventName = pb.EventName_USER_EMAIL_CHANGED
+			derived.Payload = &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Id: userID, Email: update.Email}}
+		case "password":
+			derived.EventName = pb.EventName_USER_PASSWORD_CHANGED
+			derived.Payload = &pb.Events_ChangePassword{ChangePassword: &pb.ChangePassword{Id: userID}}
+		default:
+			continue
+		}
+		events = append(events, derived)
+	}
+	return events
+}
+
+// ToProtoUser returns the protobuf representation of given user without password
+func ToProtoUser(user *domain.User) *pb.User {
+	return &pb.User{
+		Id:        user.ID.String(),
+		Nickname:  user.NickName,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Country:   user.Country,
+		Suspended: user.Suspended,
+		Role:      ToProtoRole(user.Role),
+	}
+}
+
+// RoleName returns the stored name of given role such as "admin"
+func RoleName(role pb.Role) string {
+	return strings.ToLower(strings.TrimPrefix(role.String(), "ROLE_"))
+}
+
+// ToProtoRole returns the role of given stored name, unknown names are the default role
+func ToProtoRole(name string) pb.Role {
+	return pb.Role(pb.Role_value["ROLE_"+strings.ToUpper(name)])
+}
+
+// FromProtoUser returns the response representation of given protobuf user
+func FromProtoUser(user *pb.User) domain.UserData {
+	id, _ := uuid.Parse(user.Id)
+	return domain.UserData{
+		ID:        id,
+		NickName:  user.Nickname,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Country:   user.Country,
+		Suspended: user.Suspended,
+		Role:      RoleName(user.Role),
+	}
+}
+
+// ScrubPayload replaces the personal data in the payload of the event with the tombstones of the user
+// Request meta is removed as well, actor and the other fields are kept so that the history stays intact
+func ScrubPayload(event *pb.Events, userID string) {
+	nickname, email := erasure.Nickname(userID), erasure.Email(userID)
+
+	switch payload := event.Payload.(type) {
+	case *pb.Events_CreateUser:
+		payload.CreateUser.Nickname = nickname
+		payload.CreateUser.Email = email
+		payload.CreateUser.FirstName = ""
+		payload.CreateUser.LastName = ""
+		payload.CreateUser.Country = ""
+	case *pb.Events_UpdateUser:
+		payload.UpdateUser.Nickname = nickname
+		payload.UpdateUser.Email = email
+		payload.UpdateUser.FirstName = ""
+		payload.UpdateUser.LastName = ""
+		payload.UpdateUser.Country = ""
+	case *pb.Events_ChangeEmail:
+		payload.ChangeEmail.Email = email
+	case *pb.Events_SuspendUser:
+		payload.SuspendUser.Reason = ""
+	}
+
+	event.EventData = nil
+	if event.Meta != nil {
+		event.Meta = &pb.RequestMeta{RequestId: event.Meta.RequestId}
+	}
+}
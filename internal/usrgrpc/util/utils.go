@@ -1,10 +1,10 @@
 package util
 
 import (
-	"github.com/cemayan/faceit-technical-test/internal/user/model"
+	userutil "github.com/cemayan/faceit-technical-test/internal/user/util"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
-	"os"
+	"strconv"
 	"time"
 )
 
@@ -20,27 +20,19 @@ func GetTime() int64 {
 	return now.Unix()
 }
 
-func MigrateDB(db *gorm.DB, log *log.Entry) {
-	if os.Getenv("ENV") == "test" {
-		// ConnectDBForTesting  serves to connect to db for Testing
-		// When DB connection is successful then model migration is started
-		isExist := db.Migrator().HasTable(&model.User{})
-		if isExist {
-			err := db.Migrator().DropTable(&model.User{})
-			if err != nil {
-				return
-			}
-		}
-		err := db.AutoMigrate(&model.User{})
-		if err != nil {
-			return
-		}
-		log.Infoln("Database Migrated")
-	} else {
-		err := db.AutoMigrate(&model.User{})
-		if err != nil {
-			return
-		}
-		log.Infoln("Database Migrated")
+// ParseAsOf returns the point in time of given RFC3339 timestamp, date (2006-01-02, UTC midnight) or unix seconds
+func ParseAsOf(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if asOf, err := time.Parse(time.RFC3339, value); err == nil {
+		return asOf, nil
 	}
+	return time.Parse("2006-01-02", value)
+}
+
+// MigrateDB applies the pending migrations, the services share the database and its migrations
+// The service exits when the database can't be migrated
+func MigrateDB(db *gorm.DB, log *log.Entry) {
+	userutil.MigrateDB(db, log)
 }
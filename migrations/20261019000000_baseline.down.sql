@@ -0,0 +1,7 @@
+-- The baseline adopts the tables which were created by AutoMigrate, reverting it would drop the users which existed before the migrations
+-- Test databases are reset by dropping their tables instead, see migrate.Reset
+DO $$
+BEGIN
+    RAISE EXCEPTION 'the baseline migration can''t be reverted, it owns the tables which existed before the migrations';
+END
+$$;
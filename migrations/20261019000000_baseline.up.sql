@@ -0,0 +1,185 @@
+-- The schema which was created by AutoMigrate
+-- Tables of the existing databases are kept, the columns which were added after they were created are added to them
+
+CREATE TABLE IF NOT EXISTS users (
+    id             uuid PRIMARY KEY,
+    created_at     timestamptz,
+    updated_at     timestamptz,
+    deleted        timestamptz,
+    nick_name      text,
+    email          text,
+    password       text NOT NULL,
+    first_name     text,
+    last_name      text,
+    country        text,
+    suspended      boolean,
+    suspend_reason text,
+    role           text NOT NULL DEFAULT 'user',
+    email_index    text,
+    key_id         text
+);
+
+-- email_index of the existing users is filled when they are encrypted by `usrctl keys rotate`
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS suspended      boolean,
+    ADD COLUMN IF NOT EXISTS suspend_reason text,
+    ADD COLUMN IF NOT EXISTS role           text NOT NULL DEFAULT 'user',
+    ADD COLUMN IF NOT EXISTS email_index    text,
+    ADD COLUMN IF NOT EXISTS key_id         text;
+
+-- The unique indexes which covered the deleted users as well, or the plaintext email
+DROP INDEX IF EXISTS idx_users_nick_name;
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_email_active;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_nick_name_active ON users (nick_name) WHERE deleted IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_index_active ON users (email_index) WHERE deleted IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_key_id ON users (key_id);
+
+CREATE TABLE IF NOT EXISTS events (
+    sequence       bigserial PRIMARY KEY,
+    aggregate_id   text,
+    aggregate_type integer,
+    event_name     integer,
+    internal_id    text,
+    event_date     bigint,
+    payload        bytea,
+    created_at     timestamptz
+);
+
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS aggregate_id   text,
+    ADD COLUMN IF NOT EXISTS aggregate_type integer,
+    ADD COLUMN IF NOT EXISTS event_name     integer,
+    ADD COLUMN IF NOT EXISTS internal_id    text,
+    ADD COLUMN IF NOT EXISTS event_date     bigint,
+    ADD COLUMN IF NOT EXISTS payload        bytea,
+    ADD COLUMN IF NOT EXISTS created_at     timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_events_aggregate_id ON events (aggregate_id);
+CREATE INDEX IF NOT EXISTS idx_events_event_name ON events (event_name);
+CREATE INDEX IF NOT EXISTS idx_events_internal_id ON events (internal_id);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id             text PRIMARY KEY,
+    aggregate_id   text,
+    aggregate_type integer,
+    event_name     integer,
+    internal_id    text,
+    event_date     bigint,
+    payload        bytea,
+    reason         text,
+    error          text,
+    attempts       bigint,
+    created_at     timestamptz,
+    updated_at     timestamptz
+);
+
+ALTER TABLE dead_letters
+    ADD COLUMN IF NOT EXISTS aggregate_id   text,
+    ADD COLUMN IF NOT EXISTS aggregate_type integer,
+    ADD COLUMN IF NOT EXISTS event_name     integer,
+    ADD COLUMN IF NOT EXISTS internal_id    text,
+    ADD COLUMN IF NOT EXISTS event_date     bigint,
+    ADD COLUMN IF NOT EXISTS payload        bytea,
+    ADD COLUMN IF NOT EXISTS reason         text,
+    ADD COLUMN IF NOT EXISTS error          text,
+    ADD COLUMN IF NOT EXISTS attempts       bigint,
+    ADD COLUMN IF NOT EXISTS created_at     timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at     timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_event_name ON dead_letters (event_name);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_created_at ON dead_letters (created_at);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+    id         bigserial PRIMARY KEY,
+    user_id    text,
+    sequence   bigint,
+    event_date bigint,
+    version    bigint,
+    state      bytea,
+    created_at timestamptz
+);
+
+ALTER TABLE snapshots
+    ADD COLUMN IF NOT EXISTS user_id    text,
+    ADD COLUMN IF NOT EXISTS sequence   bigint,
+    ADD COLUMN IF NOT EXISTS event_date bigint,
+    ADD COLUMN IF NOT EXISTS version    bigint,
+    ADD COLUMN IF NOT EXISTS state      bytea,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_snapshots_user_sequence ON snapshots (user_id, sequence);
+CREATE INDEX IF NOT EXISTS idx_snapshots_event_date ON snapshots (event_date);
+
+CREATE TABLE IF NOT EXISTS exports (
+    id           text PRIMARY KEY,
+    user_id      text,
+    status       text,
+    error        text,
+    archive      bytea,
+    created_at   timestamptz,
+    completed_at timestamptz
+);
+
+ALTER TABLE exports
+    ADD COLUMN IF NOT EXISTS user_id      text,
+    ADD COLUMN IF NOT EXISTS status       text,
+    ADD COLUMN IF NOT EXISTS error        text,
+    ADD COLUMN IF NOT EXISTS archive      bytea,
+    ADD COLUMN IF NOT EXISTS created_at   timestamptz,
+    ADD COLUMN IF NOT EXISTS completed_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_exports_user_id ON exports (user_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id         bigserial PRIMARY KEY,
+    action     text,
+    user_id    text,
+    actor_id   text,
+    ip         text,
+    user_agent text,
+    request_id text,
+    source     text,
+    changes    bytea,
+    created_at timestamptz
+);
+
+ALTER TABLE audit_log
+    ADD COLUMN IF NOT EXISTS action     text,
+    ADD COLUMN IF NOT EXISTS user_id    text,
+    ADD COLUMN IF NOT EXISTS actor_id   text,
+    ADD COLUMN IF NOT EXISTS ip         text,
+    ADD COLUMN IF NOT EXISTS user_agent text,
+    ADD COLUMN IF NOT EXISTS request_id text,
+    ADD COLUMN IF NOT EXISTS source     text,
+    ADD COLUMN IF NOT EXISTS changes    bytea,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log (action);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log (user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_request_id ON audit_log (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+CREATE TABLE IF NOT EXISTS erasure_receipts (
+    id              bigserial PRIMARY KEY,
+    user_id         text,
+    actor_id        text,
+    request_id      text,
+    source          text,
+    events_scrubbed bigint,
+    audit_scrubbed  bigint,
+    created_at      timestamptz
+);
+
+ALTER TABLE erasure_receipts
+    ADD COLUMN IF NOT EXISTS user_id         text,
+    ADD COLUMN IF NOT EXISTS actor_id        text,
+    ADD COLUMN IF NOT EXISTS request_id      text,
+    ADD COLUMN IF NOT EXISTS source          text,
+    ADD COLUMN IF NOT EXISTS events_scrubbed bigint,
+    ADD COLUMN IF NOT EXISTS audit_scrubbed  bigint,
+    ADD COLUMN IF NOT EXISTS created_at      timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_erasure_receipts_user_id ON erasure_receipts (user_id);
@@ -0,0 +1,2 @@
+-- Pending exports are failed as interrupted once their heartbeat stops, instead of when any instance starts
+ALTER TABLE exports ADD COLUMN IF NOT EXISTS heartbeat_at timestamptz;
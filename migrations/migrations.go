@@ -0,0 +1,10 @@
+// Package migrations contains the versioned SQL migrations of the database which is shared by the services
+// New migrations are created by `usrctl migrate create <name>`
+package migrations
+
+import "embed"
+
+// FS is the file system of the migration files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,304 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgconn"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Domain is the ErrorInfo domain of the errors which are produced by this project
+const Domain = "faceit-technical-test"
+
+type Kind int
+
+const (
+	Internal Kind = iota
+	NotFound
+	AlreadyExists
+	InvalidArgument
+	Validation
+	Unavailable
+	FailedPrecondition
+)
+
+// Reasons are machine readable causes which are sent in ErrorInfo
+const (
+	ReasonInternal           = "INTERNAL"
+	ReasonUserNotFound       = "USER_NOT_FOUND"
+	ReasonNicknameTaken      = "NICKNAME_TAKEN"
+	ReasonEmailTaken         = "EMAIL_TAKEN"
+	ReasonUserExists         = "USER_ALREADY_EXISTS"
+	ReasonMalformedPayload   = "MALFORMED_PAYLOAD"
+	ReasonInvalidArgument    = "INVALID_ARGUMENT"
+	ReasonValidationFailed   = "VALIDATION_FAILED"
+	ReasonUnavailable        = "UNAVAILABLE"
+	ReasonDeadLetterNotFound = "DEAD_LETTER_NOT_FOUND"
+	ReasonUserSuspended      = "USER_SUSPENDED"
+	ReasonUserNotSuspended   = "USER_NOT_SUSPENDED"
+	ReasonUserNotDeleted     = "USER_NOT_DELETED"
+	ReasonFailedPrecondition = "FAILED_PRECONDITION"
+	ReasonExportNotFound     = "EXPORT_NOT_FOUND"
+	ReasonExportNotReady     = "EXPORT_NOT_READY"
+	ReasonUserErased         = "USER_ERASED"
+	ReasonEventNotRecorded   = "EVENT_NOT_RECORDED"
+)
+
+// FieldViolation is representation of an invalid request field
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// Error is the domain error which is translated to gRPC status and HTTP status code
+type Error struct {
+	Kind       Kind
+	Reason     string
+	Message    string
+	Violations []FieldViolation
+	Err        error
+}
+
+// UniqueViolation is the error of the repositories which are not backed by Postgres when a unique index is violated
+// Index is the name of the index or the columns of it
+type UniqueViolation struct {
+	Index string
+}
+
+func (e *UniqueViolation) Error() string {
+	return fmt.Sprintf("unique index %s is violated", e.Index)
+}
+
+// sqliteUnique is the prefix of the SQLite errors of the unique indexes, the columns of the index follow it
+const sqliteUnique = "UNIQUE constraint failed: "
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err.Error())
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the gRPC code of the error kind
+func (e *Error) Code() codes.Code {
+	switch e.Kind {
+	case NotFound:
+		return codes.NotFound
+	case AlreadyExists:
+		return codes.AlreadyExists
+	case InvalidArgument, Validation:
+		return codes.InvalidArgument
+	case Unavailable:
+		return codes.Unavailable
+	case FailedPrecondition:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// HTTPStatus returns the HTTP status code of the error kind
+func (e *Error) HTTPStatus() int {
+	switch e.Kind {
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists:
+		return http.StatusConflict
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case Validation:
+		return http.StatusUnprocessableEntity
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case FailedPrecondition:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCStatus returns the status with ErrorInfo and BadRequest details
+// status.FromError uses it so that the error can be returned from the gRPC handlers directly
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code(), e.Message)
+
+	info := &errdetails.ErrorInfo{Reason: e.Reason, Domain: Domain}
+	if len(e.Violations) == 0 {
+		withDetails, err := st.WithDetails(info)
+		if err != nil {
+			return st
+		}
+		return withDetails
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range e.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Description,
+		})
+	}
+
+	withDetails, err := st.WithDetails(info, badRequest)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+func New(kind Kind, reason string, message string) *Error {
+	return &Error{Kind: kind, Reason: reason, Message: message}
+}
+
+// Wrap returns the domain error of given error
+// Domain errors are returned as they are, repository errors are mapped to their kinds
+func Wrap(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return FromValidation(validationErrs)
+	}
+
+	var pgErr *pgconn.PgError
+	var uniqueErr *UniqueViolation
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return &Error{Kind: NotFound, Reason: ReasonUserNotFound, Message: "user not found", Err: err}
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return uniqueViolation(pgErr.ConstraintName, err)
+	case errors.As(err, &uniqueErr):
+		return uniqueViolation(uniqueErr.Index, err)
+	case strings.HasPrefix(err.Error(), sqliteUnique):
+		return uniqueViolation(strings.TrimPrefix(err.Error(), sqliteUnique), err)
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return FromStatus(st)
+	}
+
+	return &Error{Kind: Internal, Reason: ReasonInternal, Message: "internal error", Err: err}
+}
+
+// InvalidID returns the error of an id which is not a valid UUID
+func InvalidID(id string) *Error {
+	return &Error{
+		Kind:       InvalidArgument,
+		Reason:     ReasonInvalidArgument,
+		Message:    fmt.Sprintf("invalid user id %q", id),
+		Violations: []FieldViolation{{Field: "id", Description: "must be a valid UUID"}},
+	}
+}
+
+// InvalidAsOf returns the error of a point in time which can not be parsed
+func InvalidAsOf(value string) *Error {
+	return &Error{
+		Kind:       InvalidArgument,
+		Reason:     ReasonInvalidArgument,
+		Message:    fmt.Sprintf("invalid asOf %q", value),
+		Violations: []FieldViolation{{Field: "asOf", Description: "must be a RFC3339 timestamp, a date or unix seconds"}},
+	}
+}
+
+// Malformed returns the error of a payload which can not be decoded
+func Malformed(err error) *Error {
+	return &Error{Kind: InvalidArgument, Reason: ReasonMalformedPayload, Message: "malformed payload", Err: err}
+}
+
+// FromValidation returns the error of the failed validator rules with a violation per field
+func FromValidation(validationErrs validator.ValidationErrors) *Error {
+	appErr := &Error{Kind: Validation, Reason: ReasonValidationFailed, Message: "validation failed"}
+	for _, fieldErr := range validationErrs {
+		appErr.Violations = append(appErr.Violations, FieldViolation{
+			Field:       fieldErr.Field(),
+			Description: fmt.Sprintf("failed on the %s rule", fieldErr.Tag()),
+		})
+	}
+	return appErr
+}
+
+// FromStatus returns the domain error of given gRPC status
+// When ErrorInfo is not given the kind is derived from the status code
+func FromStatus(st *status.Status) *Error {
+	appErr := &Error{Message: st.Message()}
+
+	switch st.Code() {
+	case codes.NotFound:
+		appErr.Kind, appErr.Reason = NotFound, ReasonUserNotFound
+	case codes.AlreadyExists:
+		appErr.Kind, appErr.Reason = AlreadyExists, ReasonUserExists
+	case codes.InvalidArgument:
+		appErr.Kind, appErr.Reason = InvalidArgument, ReasonInvalidArgument
+	case codes.Unavailable, codes.DeadlineExceeded:
+		appErr.Kind, appErr.Reason = Unavailable, ReasonUnavailable
+	case codes.FailedPrecondition:
+		appErr.Kind, appErr.Reason = FailedPrecondition, ReasonFailedPrecondition
+	default:
+		appErr.Kind, appErr.Reason = Internal, ReasonInternal
+	}
+
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			appErr.Reason = d.Reason
+			if d.Reason == ReasonValidationFailed {
+				appErr.Kind = Validation
+			}
+		case *errdetails.BadRequest:
+			for _, violation := range d.FieldViolations {
+				appErr.Violations = append(appErr.Violations, FieldViolation{
+					Field:       violation.Field,
+					Description: violation.Description,
+				})
+			}
+		}
+	}
+
+	return appErr
+}
+
+// uniqueViolation returns the conflict error of the violated unique index
+func uniqueViolation(index string, err error) *Error {
+	appErr := &Error{Kind: AlreadyExists, Reason: ReasonUserExists, Message: "user already exists", Err: err}
+
+	switch {
+	case strings.Contains(index, "nick_name"):
+		appErr.Reason = ReasonNicknameTaken
+		appErr.Message = "nickname is already taken"
+		appErr.Violations = []FieldViolation{{Field: "nickname", Description: "must be unique"}}
+	case strings.Contains(index, "email"):
+		appErr.Reason = ReasonEmailTaken
+		appErr.Message = "email is already taken"
+		appErr.Violations = []FieldViolation{{Field: "email", Description: "must be unique"}}
+	}
+
+	return appErr
+}
+
+// UseJSONFieldNames makes the validator report the json names of the fields
+// Field violations then match the payload which is sent by the client
+func UseJSONFieldNames(validate *validator.Validate) {
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// Redacted replaces the values of the secret fields
+const Redacted = "[REDACTED]"
+
+// Actions of the user service, the event based services use the names of their events
+const (
+	ActionUserCreated = "USER_CREATED"
+	ActionUserUpdated = "USER_UPDATED"
+	ActionUserDeleted = "USER_DELETED"
+	ActionUserPurged  = "USER_PURGED"
+	ActionUserErased  = "USER_ERASED"
+)
+
+// ErrAppendOnly is returned when an audit entry is changed or removed
+var ErrAppendOnly = errors.New("audit log is append-only")
+
+// secretFields are the fields whose values are never written to the audit log
+var secretFields = map[string]bool{
+	"password": true,
+}
+
+// Change is the change of a single field
+type Change struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// Meta describes who made the change and from where
+type Meta struct {
+	ActorID   string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// Entry is a record of the append-only audit log
+// Changes is the JSON form of the redacted changes
+type Entry struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Action    string    `gorm:"index" json:"action"`
+	UserID    string    `gorm:"index" json:"user_id"`
+	ActorID   string    `gorm:"index" json:"actor_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `gorm:"index" json:"request_id"`
+	Source    string    `json:"source"`
+	Changes   []byte    `json:"-"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// BeforeUpdate prevents the changes of the recorded entries
+func (e *Entry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAppendOnly
+}
+
+// BeforeDelete prevents the removal of the recorded entries
+func (e *Entry) BeforeDelete(tx *gorm.DB) error {
+	return ErrAppendOnly
+}
+
+// EntryData is the response representation of an audit entry
+type EntryData struct {
+	Entry
+	Changes []Change `json:"changes"`
+}
+
+// NewEntry returns the entry of given action, secret values in changes are redacted
+func NewEntry(action string, userID string, source string, meta Meta, changes []Change) (*Entry, error) {
+	data, err := json.Marshal(Redact(changes))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Action:    action,
+		UserID:    userID,
+		ActorID:   meta.ActorID,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		RequestID: meta.RequestID,
+		Source:    source,
+		Changes:   data,
+	}, nil
+}
+
+// Data returns the response representation of the entry
+func (e Entry) Data() (EntryData, error) {
+	data := EntryData{Entry: e, Changes: []Change{}}
+	if len(e.Changes) == 0 {
+		return data, nil
+	}
+	err := json.Unmarshal(e.Changes, &data.Changes)
+	return data, err
+}
+
+// Redact returns the changes whose secret values are replaced with Redacted
+func Redact(changes []Change) []Change {
+	redacted := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		if secretFields[change.Field] {
+			change.From, change.To = Redacted, Redacted
+		}
+		redacted = append(redacted, change)
+	}
+	return redacted
+}
+
+// ScrubEntry returns the JSON form of the changes of the entry whose personal data values are replaced with erasure.Erased
+func ScrubEntry(entry Entry) ([]byte, error) {
+	data, err := entry.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, change := range data.Changes {
+		if erasure.IsField(change.Field) {
+			data.Changes[i].From, data.Changes[i].To = scrubValue(change.From), scrubValue(change.To)
+		}
+	}
+	return json.Marshal(data.Changes)
+}
+
+func scrubValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return erasure.Erased
+}
+
+// Diff returns the changed fields between two field sets, a missing set is a created or removed record
+func Diff(before map[string]string, after map[string]string) []Change {
+	fields := make(map[string]bool)
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, field := range names {
+		if before[field] != after[field] {
+			changes = append(changes, Change{Field: field, From: before[field], To: after[field]})
+		}
+	}
+	return changes
+}
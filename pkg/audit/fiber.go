@@ -0,0 +1,25 @@
+package audit
+
+import "github.com/gofiber/fiber/v2"
+
+// Headers which are recorded in the audit log
+const (
+	ActorHeader     = "X-Actor"
+	RequestIDHeader = fiber.HeaderXRequestID
+)
+
+// MetaFromRequest returns the audit meta of the request
+// Request id is set by the requestid middleware when the client doesn't send one
+func MetaFromRequest(c *fiber.Ctx) Meta {
+	requestID := c.Get(RequestIDHeader)
+	if id, ok := c.Locals("requestid").(string); ok && requestID == "" {
+		requestID = id
+	}
+
+	return Meta{
+		ActorID:   c.Get(ActorHeader),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		RequestID: requestID,
+	}
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// exportBatchSize is the number of entries which are read at once while exporting
+const exportBatchSize = 500
+
+// Filter is representation of the audit log query, empty fields are not filtered
+type Filter struct {
+	UserID    string
+	ActorID   string
+	Action    string
+	RequestID string
+	From      time.Time
+	To        time.Time
+}
+
+type Repository interface {
+	Append(entry *Entry) error
+	List(filter Filter, limit int, offset int) ([]Entry, int64, error)
+	Export(filter Filter, fn func(entry Entry) error) error
+	Scrub(userID string) (int64, error)
+}
+
+type Repo struct {
+	db  *gorm.DB
+	log *log.Entry
+}
+
+// Append records the entry, entries are never changed after they are recorded
+func (r Repo) Append(entry *Entry) error {
+	return r.db.Create(entry).Error
+}
+
+// List returns the matched entries from the newest one and their total count
+func (r Repo) List(filter Filter, limit int, offset int) ([]Entry, int64, error) {
+	var entries []Entry
+	var total int64
+
+	if err := r.query(filter).Model(&Entry{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := r.query(filter).Order("id desc").Limit(limit).Offset(offset).Find(&entries).Error
+	return entries, total, err
+}
+
+// Export passes the matched entries to fn from the oldest one, it stops at the first error of fn
+func (r Repo) Export(filter Filter, fn func(entry Entry) error) error {
+	var batch []Entry
+	return r.query(filter).Order("id asc").FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Scrub removes the personal data of the user from its entries and returns the number of the changed entries
+// It is the only change which is allowed on the recorded entries, so it skips the append-only hooks
+func (r Repo) Scrub(userID string) (int64, error) {
+	var entries []Entry
+	if err := r.db.Where("user_id = ?", userID).Order("id asc").Find(&entries).Error; err != nil {
+		return 0, err
+	}
+
+	var scrubbed int64
+	for _, entry := range entries {
+		changes, err := ScrubEntry(entry)
+		if err != nil {
+			return scrubbed, err
+		}
+
+		err = r.db.Session(&gorm.Session{SkipHooks: true}).Model(&Entry{}).Where("id = ?", entry.ID).
+			Updates(map[string]interface{}{"changes": changes, "ip": "", "user_agent": ""}).Error
+		if err != nil {
+			return scrubbed, err
+		}
+		scrubbed++
+	}
+	return scrubbed, nil
+}
+
+func (r Repo) query(filter Filter) *gorm.DB {
+	tx := r.db
+	if filter.UserID != "" {
+		tx = tx.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ActorID != "" {
+		tx = tx.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.RequestID != "" {
+		tx = tx.Where("request_id = ?", filter.RequestID)
+	}
+	if !filter.From.IsZero() {
+		tx = tx.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		tx = tx.Where("created_at < ?", filter.To)
+	}
+	return tx
+}
+
+func NewRepository(db *gorm.DB, log *log.Entry) Repository {
+	return &Repo{
+		db:  db,
+		log: log,
+	}
+}
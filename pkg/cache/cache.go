@@ -0,0 +1,36 @@
+// Package cache contains the caches which are used in front of the repositories
+package cache
+
+import (
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"time"
+)
+
+// Cache backends
+// LRU keeps the entries in the memory of the process, it is the local stand-in of a distributed cache
+const (
+	LRU = "lru"
+)
+
+// Cache is the contract of the caches, a distributed cache (e.g. Redis) is plugged in by implementing it
+// Values are opaque bytes so that they can be sent over the network, Get reports a miss with false
+// Errors are returned when the cache can't be reached, callers are expected to fall back to the source
+type Cache interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(keys ...string) error
+}
+
+// New returns the cache of the configured backend, it returns nil when the cache is disabled
+func New(configs *common.Cache) (Cache, error) {
+	if configs.SIZE <= 0 {
+		return nil, nil
+	}
+
+	switch configs.BACKEND {
+	case "", LRU:
+		return NewLRU(configs.SIZE), nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", configs.BACKEND)
+}
@@ -1,14 +1,91 @@
 package common
 
+import "time"
+
+// Postgresql contains the settings of the primary database and its read replicas
+// REPLICAS serve the reads of the users, they are connected with the credentials of the primary
+// Reads of a user are sent to the primary for STICKY_WINDOW after it is written, so that the writer reads its own writes
+// Replicas which lag more than MAX_REPLICA_LAG are skipped, the lag is checked every LAG_INTERVAL
+// SSL_MODE is the sslmode of the connections, disable when it is empty
+// MAX_OPEN_CONNS, MAX_IDLE_CONNS, CONN_MAX_LIFETIME and CONN_MAX_IDLE_TIME are the settings of each pool, 0 keeps the database/sql defaults
+// STATEMENT_TIMEOUT aborts the statements which run longer, 0 keeps the server default
+// Connection is retried CONNECT_RETRIES times on startup, the wait starts from CONNECT_BACKOFF and doubles after every attempt
 type Postgresql struct {
-	HOST     string
-	PORT     string
-	USER     string
-	PASSWORD string
-	NAME     string
+	HOST               string
+	PORT               string
+	USER               string
+	PASSWORD           string
+	NAME               string
+	REPLICAS           []PostgresReplica
+	STICKY_WINDOW      time.Duration
+	MAX_REPLICA_LAG    time.Duration
+	LAG_INTERVAL       time.Duration
+	SSL_MODE           string
+	MAX_OPEN_CONNS     int
+	MAX_IDLE_CONNS     int
+	CONN_MAX_LIFETIME  time.Duration
+	CONN_MAX_IDLE_TIME time.Duration
+	STATEMENT_TIMEOUT  time.Duration
+	CONNECT_RETRIES    int
+	CONNECT_BACKOFF    time.Duration
 }
 
-type Grpc struct {
-	ADDR string
+// PostgresReplica is the address of a read replica
+type PostgresReplica struct {
+	HOST string
 	PORT string
 }
+
+// Grpc contains the gRPC server settings
+// WORKERS is the number of workers which process the events of a stream, 0 or 1 processes them one by one
+// STREAMS is the number of long-lived event streams which are opened by the clients
+// CREATE_TIMEOUT, UPDATE_TIMEOUT and DELETE_TIMEOUT are the deadlines of the calls including their retries
+// RETRIES is the number of retries of the idempotent calls (update, delete)
+// Circuit breaker opens after BREAKER_FAILURES consecutive failures and lets a trial call through after BREAKER_COOLDOWN
+// REFLECTION registers the server reflection, HEALTH_INTERVAL is the interval of the database checks of the health service
+// GATEWAY_PORT is the port of the REST gateway, it is not started when it is empty
+// SNAPSHOT_INTERVAL is the number of events of a user between two snapshots of its aggregate
+// Deleted users are purged TRASH_RETENTION_DAYS after they are deleted, it is checked every PURGE_INTERVAL, 0 days disables it
+// Data exports are deleted EXPORT_TTL after they are finished, their archives are removed once they are downloaded
+type Grpc struct {
+	ADDR                 string
+	PORT                 string
+	WORKERS              int
+	STREAMS              int
+	CREATE_TIMEOUT       time.Duration
+	UPDATE_TIMEOUT       time.Duration
+	DELETE_TIMEOUT       time.Duration
+	RETRIES              int
+	BREAKER_FAILURES     int
+	BREAKER_COOLDOWN     time.Duration
+	REFLECTION           bool
+	HEALTH_INTERVAL      time.Duration
+	GATEWAY_PORT         string
+	SNAPSHOT_INTERVAL    int
+	TRASH_RETENTION_DAYS int
+	PURGE_INTERVAL       time.Duration
+	EXPORT_TTL           time.Duration
+}
+
+// Encryption contains the settings of the field-level encryption
+// KEY_FILE is the local key file of fieldcrypt.FileKeyProvider, fields are stored in plaintext when it is empty
+type Encryption struct {
+	KEY_FILE string
+}
+
+// Cache contains the settings of the user cache
+// BACKEND is lru, SIZE is the number of the cached users, 0 disables the cache
+// TTL bounds how long a user can be served after it is changed by a process which can't invalidate this cache
+type Cache struct {
+	BACKEND string
+	SIZE    int
+	TTL     time.Duration
+}
+
+// Storage contains the settings of the storage backend
+// BACKEND is postgres, sqlite or memory, postgres is used when it is empty
+// SQLITE_PATH is the database file of the sqlite backend
+type Storage struct {
+	BACKEND     string
+	SQLITE_PATH string
+}
@@ -5,8 +5,12 @@ import "strings"
 type SortingColumnName int64
 type SortingColumnType int64
 
+// Email can't be sorted while it is encrypted, the users are listed with an error then
 const (
 	Country SortingColumnName = iota
+	NickName
+	Email
+	CreatedAt
 )
 
 const (
@@ -19,16 +23,18 @@ const (
 // SType represents sorting type in DB such as ASC,DESC
 // ConditionQuery represents  query in DB such as "country = ?"
 // ConditionValue represents  query value in DB such as "UK"
+// Conditions represents exact match filters which are built by the server, column names must not come from user input
 type Pagination struct {
-	Limit      int               `json:"limit,omitempty"`
-	Page       int               `json:"page,omitempty"`
-	SColumn    SortingColumnName `json:"sColumn,omitempty"`
-	SType      SortingColumnType `json:"sType,omitempty"`
-	CQuery     string            `json:"cQuery,omitempty"`
-	CValue     string            `json:"cVal,omitempty"`
-	TotalRows  int64             `json:"total_rows"`
-	TotalPages int               `json:"total_pages"`
-	Rows       interface{}       `json:"rows"`
+	Limit      int                    `json:"limit,omitempty"`
+	Page       int                    `json:"page,omitempty"`
+	SColumn    SortingColumnName      `json:"sColumn,omitempty"`
+	SType      SortingColumnType      `json:"sType,omitempty"`
+	CQuery     string                 `json:"cQuery,omitempty"`
+	CValue     string                 `json:"cVal,omitempty"`
+	Conditions map[string]interface{} `json:"-"`
+	TotalRows  int64                  `json:"total_rows"`
+	TotalPages int                    `json:"total_pages"`
+	Rows       interface{}            `json:"rows"`
 }
 
 func (p *Pagination) GetOffset() int {
@@ -57,6 +63,12 @@ func (p *Pagination) GetSort() string {
 	switch p.SColumn {
 	case Country:
 		sb.WriteString("country ")
+	case NickName:
+		sb.WriteString("nick_name ")
+	case Email:
+		sb.WriteString("email ")
+	case CreatedAt:
+		sb.WriteString("created_at ")
 	}
 
 	switch p.SType {
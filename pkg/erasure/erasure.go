@@ -0,0 +1,54 @@
+package erasure
+
+import (
+	"fmt"
+	"time"
+)
+
+// tombstoneDomain is a reserved domain, so the tombstone emails can never be delivered
+const tombstoneDomain = "erased.invalid"
+
+// Fields are the personal data fields of a user which are anonymized
+var Fields = []string{"nickname", "email", "first_name", "last_name", "country"}
+
+// Erased replaces the personal data in the audit log
+const Erased = "[ERASED]"
+
+// Receipt is the proof of an erasure, it doesn't contain any personal data
+// The counts are the number of the events and the audit entries which are scrubbed
+// Purge is set when the user is removed permanently, its events are deleted instead of scrubbed
+type Receipt struct {
+	ID             uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         string    `gorm:"index" json:"user_id"`
+	ActorID        string    `json:"actor_id"`
+	RequestID      string    `json:"request_id"`
+	Source         string    `json:"source"`
+	EventsScrubbed int64     `json:"events_scrubbed"`
+	AuditScrubbed  int64     `json:"audit_scrubbed"`
+	CreatedAt      time.Time `json:"created_at"`
+	Purge          bool      `gorm:"-" json:"-"`
+}
+
+func (Receipt) TableName() string {
+	return "erasure_receipts"
+}
+
+// Nickname returns the tombstone nickname of given user
+func Nickname(userID string) string {
+	return "erased-" + userID
+}
+
+// Email returns the tombstone email of given user
+func Email(userID string) string {
+	return fmt.Sprintf("%s@%s", userID, tombstoneDomain)
+}
+
+// IsField reports whether the field is a personal data field
+func IsField(field string) bool {
+	for _, name := range Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// prefix marks the encrypted values, values without it are plaintext which are stored before the encryption
+const prefix = "enc:v1:"
+
+// ErrMalformed is returned when an encrypted value can not be parsed
+var ErrMalformed = errors.New("malformed encrypted value")
+
+// provider is the key provider of the serializer, the values are stored as they are when it is nil
+var provider KeyProvider
+
+// Use sets the key provider of the encrypted fields
+func Use(keys KeyProvider) {
+	provider = keys
+}
+
+// Enabled reports whether the fields are encrypted
+func Enabled() bool {
+	return provider != nil
+}
+
+// CurrentKeyID returns the id of the key which encrypts the new values, it is empty when the encryption is disabled
+func CurrentKeyID() string {
+	if provider == nil {
+		return ""
+	}
+	return provider.CurrentKeyID()
+}
+
+// Encrypt returns the envelope of the value, the value is encrypted with a new data key which is encrypted with the current key
+// The envelope is "enc:v1:<key id>:<encrypted data key>:<encrypted value>"
+func Encrypt(keys KeyProvider, value string) (string, error) {
+	keyID := keys.CurrentKeyID()
+	kek, err := keys.Key(keyID)
+	if err != nil {
+		return "", err
+	}
+
+	dek := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(kek, dek)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dek, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + strings.Join([]string{
+		keyID,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt returns the value of the envelope, plaintext values are returned as they are
+func Decrypt(keys KeyProvider, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	if keys == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+
+	kek, err := keys.Key(parts[0])
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dek, err := open(kek, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether the stored value is an envelope
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// BlindIndex returns the deterministic HMAC of the normalized value, so equal values can be found without decrypting them
+func BlindIndex(value string) string {
+	var key []byte
+	if provider != nil {
+		key = provider.IndexKey()
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts the data with AES-GCM, the nonce is put in front of the ciphertext
+func seal(key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
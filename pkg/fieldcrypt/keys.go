@@ -0,0 +1,108 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// keySize is the size of the AES-256 keys
+const keySize = 32
+
+// ErrUnknownKey is returned when a value is encrypted with a key which the provider doesn't have
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider returns the key encryption keys by their ids
+// CurrentKeyID is used for the new values, the older keys are kept to decrypt the values which are not rotated yet
+type KeyProvider interface {
+	CurrentKeyID() string
+	Key(id string) ([]byte, error)
+	IndexKey() []byte
+}
+
+// keyFile is representation of the key file, keys are base64 encoded 32 byte keys
+type keyFile struct {
+	Current  string            `json:"current"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// A FileKeyProvider keeps the keys which are read from a local JSON file
+type FileKeyProvider struct {
+	current  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+func (p FileKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+func (p FileKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+func (p FileKeyProvider) IndexKey() []byte {
+	return p.indexKey
+}
+
+// NewFileKeyProvider returns the provider of the keys in given file
+// The index key must never change, otherwise the blind indexes of the stored values don't match anymore
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	provider := FileKeyProvider{current: file.Current, keys: make(map[string][]byte, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		if provider.keys[id], err = decodeKey(encoded); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+	}
+	if _, ok := provider.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, file.Current)
+	}
+	if provider.indexKey, err = decodeKey(file.IndexKey); err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+
+	return provider, nil
+}
+
+// Setup uses the keys in given file, the encryption is disabled when path is empty
+func Setup(path string) error {
+	if path == "" {
+		Use(nil)
+		return nil
+	}
+
+	keys, err := NewFileKeyProvider(path)
+	if err != nil {
+		return err
+	}
+	Use(keys)
+	return nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("must be %d bytes", keySize)
+	}
+	return key, nil
+}
@@ -0,0 +1,50 @@
+package fieldcrypt
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+}
+
+// Serializer encrypts the string fields which are tagged with `gorm:"serializer:encrypted"`
+// Fields are stored as they are while the encryption is disabled, plaintext values are read as they are
+type Serializer struct{}
+
+// Scan decrypts the stored value into the field
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch value := dbValue.(type) {
+	case nil:
+	case string:
+		stored = value
+	case []byte:
+		stored = string(value)
+	default:
+		return fmt.Errorf("unsupported encrypted value %T", dbValue)
+	}
+
+	value, err := Decrypt(provider, stored)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", field.Name, err)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+// Value encrypts the field with the current key
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %s: only strings can be encrypted", field.Name)
+	}
+	if provider == nil || value == "" {
+		return value, nil
+	}
+	return Encrypt(provider, value)
+}
@@ -0,0 +1,203 @@
+// Package memstore filters, sorts and paginates the rows of the in-memory repositories like their SQL counterparts
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedQuery is returned for the condition queries which are not in the form of "<column> <operator> ?"
+var ErrUnsupportedQuery = errors.New("unsupported condition query")
+
+var conditionQuery = regexp.MustCompile(`(?i)^\s*([a-z_][a-z0-9_]*)\s*(=|!=|<>|<=|>=|<|>|like|ilike)\s*\?\s*$`)
+
+// Columns returns the values of the columns of a row by their names
+type Columns[T any] func(row T) map[string]interface{}
+
+// Page returns the rows on the page of pagination which match its conditions, in its sort order
+// TotalRows and TotalPages of pagination are set as the SQL repositories do
+func Page[T any](rows []T, pagination *common.Pagination, columns Columns[T]) ([]T, error) {
+	matched := make([]T, 0, len(rows))
+	for _, row := range rows {
+		ok, err := matches(columns(row), pagination)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, row)
+		}
+	}
+
+	column, desc := parseSort(pagination.GetSort())
+	sort.SliceStable(matched, func(i, j int) bool {
+		c := compare(columns(matched[i])[column], columns(matched[j])[column])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	pagination.TotalRows = int64(len(matched))
+	pagination.TotalPages = int(math.Ceil(float64(len(matched)) / float64(pagination.GetLimit())))
+
+	offset := pagination.GetOffset()
+	if offset >= len(matched) {
+		return []T{}, nil
+	}
+	end := offset + pagination.GetLimit()
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[offset:end], nil
+}
+
+// matches reports whether the row matches the exact match conditions and the condition query of pagination
+func matches(row map[string]interface{}, pagination *common.Pagination) (bool, error) {
+	for column, value := range pagination.Conditions {
+		actual, ok := row[column]
+		if !ok {
+			return false, fmt.Errorf("%w: unknown column %q", ErrUnsupportedQuery, column)
+		}
+		if compare(actual, value) != 0 {
+			return false, nil
+		}
+	}
+
+	if pagination.CQuery == "" {
+		return true, nil
+	}
+
+	match := conditionQuery.FindStringSubmatch(pagination.CQuery)
+	if match == nil {
+		return false, fmt.Errorf("%w: %q", ErrUnsupportedQuery, pagination.CQuery)
+	}
+	actual, ok := row[strings.ToLower(match[1])]
+	if !ok {
+		return false, fmt.Errorf("%w: unknown column %q", ErrUnsupportedQuery, match[1])
+	}
+
+	switch op := strings.ToLower(match[2]); op {
+	case "like", "ilike":
+		return like(fmt.Sprint(actual), pagination.CValue, op == "ilike"), nil
+	default:
+		c := compare(actual, pagination.CValue)
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "!=", "<>":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+}
+
+// compare compares the column value with given value, the value is converted to the type of the column when it is a string
+func compare(actual interface{}, value interface{}) int {
+	if s, ok := value.(string); ok {
+		value = convert(actual, s)
+	}
+
+	switch a := actual.(type) {
+	case string:
+		return strings.Compare(a, fmt.Sprint(value))
+	case time.Time:
+		b, _ := value.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		default:
+			return 0
+		}
+	case bool:
+		b, _ := value.(bool)
+		switch {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		default:
+			return 1
+		}
+	case int64:
+		b, _ := value.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(actual), fmt.Sprint(value))
+}
+
+// convert parses given string as the type of the column value
+func convert(actual interface{}, value string) interface{} {
+	switch actual.(type) {
+	case time.Time:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+		if t, err := time.Parse("2006-01-02", value); err == nil {
+			return t
+		}
+	case bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case int64:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return value
+}
+
+// like reports whether the value matches the SQL LIKE pattern
+func like(value string, pattern string, caseInsensitive bool) bool {
+	var sb strings.Builder
+	if caseInsensitive {
+		sb.WriteString("(?is)")
+	} else {
+		sb.WriteString("(?s)")
+	}
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String()).MatchString(value)
+}
+
+// parseSort returns the column and the direction of the sort clause of common.Pagination
+func parseSort(clause string) (string, bool) {
+	fields := strings.Fields(clause)
+	if len(fields) == 0 {
+		return "", false
+	}
+	return fields[0], len(fields) > 1 && strings.EqualFold(fields[1], "desc")
+}
@@ -0,0 +1,191 @@
+package migrate
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io/fs"
+	"time"
+)
+
+// lockKey is the key of the advisory lock which is held while migrating
+// Services which start at the same time wait for each other instead of applying the same migration twice
+const lockKey int64 = 4_601_300_045
+
+// AppliedMigration is a record of the schema_migrations table
+type AppliedMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (AppliedMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status is the state of a migration
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+type Migrator interface {
+	Up(steps int) ([]Migration, error)
+	Down(steps int) ([]Migration, error)
+	Status() ([]Status, error)
+	Reset() error
+}
+
+// A SQLMigrator applies the SQL migrations of a file system, each of them in its own transaction
+type SQLMigrator struct {
+	db   *gorm.DB
+	fsys fs.FS
+	log  *log.Entry
+}
+
+// Up applies at most steps pending migrations in version order and returns them, all of them when steps is 0
+func (m SQLMigrator) Up(steps int) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(func(tx *gorm.DB, migrations []Migration, done map[int64]AppliedMigration) error {
+		for _, migration := range migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				return nil
+			}
+
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&AppliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			m.log.WithFields(log.Fields{"method": "Up"}).Infof("Migration %d_%s is applied", migration.Version, migration.Name)
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts at most steps applied migrations from the latest one and returns them, all of them when steps is 0
+func (m SQLMigrator) Down(steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.locked(func(tx *gorm.DB, migrations []Migration, done map[int64]AppliedMigration) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			migration := migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if steps > 0 && len(reverted) == steps {
+				return nil
+			}
+
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&AppliedMigration{}, migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			m.log.WithFields(log.Fields{"method": "Down"}).Infof("Migration %d_%s is reverted", migration.Version, migration.Name)
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status returns all migrations with the time they were applied
+// Applied versions which don't have a file anymore are returned as well
+func (m SQLMigrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.locked(func(tx *gorm.DB, migrations []Migration, done map[int64]AppliedMigration) error {
+		for _, migration := range migrations {
+			status := Status{Version: migration.Version, Name: migration.Name}
+			if record, ok := done[migration.Version]; ok {
+				appliedAt := record.AppliedAt
+				status.AppliedAt = &appliedAt
+				delete(done, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, record := range done {
+			appliedAt := record.AppliedAt
+			statuses = append(statuses, Status{Version: record.Version, Name: record.Name + " (missing)", AppliedAt: &appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Reset drops every table of the current schema, schema_migrations included, so that all migrations are pending again
+// It deletes all data, it is only meant for the test databases
+func (m SQLMigrator) Reset() error {
+	return m.locked(func(tx *gorm.DB, migrations []Migration, done map[int64]AppliedMigration) error {
+		var tables []string
+		if err := tx.Raw("SELECT tablename FROM pg_tables WHERE schemaname = current_schema()").Scan(&tables).Error; err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %q CASCADE", table)).Error; err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+		}
+		m.log.WithFields(log.Fields{"method": "Reset"}).Infof("%d tables are dropped", len(tables))
+		return nil
+	})
+}
+
+// locked runs fn on a single connection which holds the advisory lock
+// fn gets the migrations and the applied ones by their versions
+func (m SQLMigrator) locked(fn func(tx *gorm.DB, migrations []Migration, done map[int64]AppliedMigration) error) error {
+	migrations, err := Load(m.fsys)
+	if err != nil {
+		return err
+	}
+
+	return m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+			return err
+		}
+		defer func() {
+			if err := conn.Exec("SELECT pg_advisory_unlock(?)", lockKey).Error; err != nil {
+				m.log.WithFields(log.Fields{"method": "locked"}).Errorf("Advisory lock couldn't be released %s", err.Error())
+			}
+		}()
+
+		if err := conn.AutoMigrate(&AppliedMigration{}); err != nil {
+			return err
+		}
+
+		var records []AppliedMigration
+		if err := conn.Order("version").Find(&records).Error; err != nil {
+			return err
+		}
+		done := make(map[int64]AppliedMigration, len(records))
+		for _, record := range records {
+			done[record.Version] = record
+		}
+
+		return fn(conn, migrations, done)
+	})
+}
+
+// New returns the migrator of the migrations in the root of fsys
+func New(db *gorm.DB, fsys fs.FS, log *log.Entry) Migrator {
+	return &SQLMigrator{
+		db:   db,
+		fsys: fsys,
+		log:  log,
+	}
+}
@@ -11,8 +11,15 @@ import (
 	"time"
 )
 
+// Defaults of the connection settings which are not configured
+const (
+	defaultSSLMode        = "disable"
+	defaultConnectBackoff = time.Second
+	maxConnectBackoff     = 30 * time.Second
+)
+
 type DBHandler interface {
-	New() *gorm.DB
+	New() (*gorm.DB, error)
 }
 
 type DBService struct {
@@ -21,11 +28,49 @@ type DBService struct {
 }
 
 // New  serves to connect to db
-// When DB connection is successful then model migration is started
-func (d DBService) New() *gorm.DB {
+// Connection is retried with backoff so that the services can be started before Postgres is ready
+// Last error is returned when the retries run out, callers decide whether they can run without the database
+func (d DBService) New() (*gorm.DB, error) {
+
+	db, err := connect(d.configs, d.configs.HOST, d.configs.PORT, d._log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	d._log.WithFields(log.Fields{"service": "database"}).Println("Connection Opened to Database")
+
+	return db, nil
+}
+
+// connect opens the database on given host and retries CONNECT_RETRIES times while it can't be reached
+func connect(configs *common.Postgresql, host string, port string, _log *log.Entry) (*gorm.DB, error) {
+	backoff := configs.CONNECT_BACKOFF
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		db, err := open(configs, host, port, _log)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= configs.CONNECT_RETRIES {
+			return nil, err
+		}
+
+		_log.WithFields(log.Fields{"service": "database"}).Warnf("Database %s:%s is not reachable, retrying in %v, attempt %d: %v", host, port, backoff, attempt+1, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// open connects to the database on given host with the credentials and the pool settings of configs
+func open(configs *common.Postgresql, host string, port string, _log *log.Entry) (*gorm.DB, error) {
 
 	newLogger := logger.New(
-		d._log.Logger, // io writer
+		_log.Logger, // io writer
 		logger.Config{
 			SlowThreshold:             time.Second,   // Slow SQL threshold
 			LogLevel:                  logger.Silent, // Log level
@@ -35,21 +80,66 @@ func (d DBService) New() *gorm.DB {
 	)
 
 	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN: fmt.Sprintf("host=%s port=%s  user=%s password=%s  dbname=%s sslmode=disable ",
-			d.configs.HOST,
-			d.configs.PORT,
-			d.configs.USER,
-			d.configs.PASSWORD,
-			d.configs.NAME),
+		DSN: dsn(configs, host, port),
 	}), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		// gorm returns the opened pool when its ping fails, it is closed so that the retries don't leak it
+		closeDB(db)
+		return nil, err
+	}
 
+	sqlDB, err := db.DB()
 	if err != nil {
-		panic("failed to connect database")
+		closeDB(db)
+		return nil, err
+	}
+	// Zero settings keep the database/sql defaults, SetMaxIdleConns(0) would turn the idle connections off
+	if configs.MAX_OPEN_CONNS > 0 {
+		sqlDB.SetMaxOpenConns(configs.MAX_OPEN_CONNS)
+	}
+	if configs.MAX_IDLE_CONNS > 0 {
+		sqlDB.SetMaxIdleConns(configs.MAX_IDLE_CONNS)
+	}
+	if configs.CONN_MAX_LIFETIME > 0 {
+		sqlDB.SetConnMaxLifetime(configs.CONN_MAX_LIFETIME)
+	}
+	if configs.CONN_MAX_IDLE_TIME > 0 {
+		sqlDB.SetConnMaxIdleTime(configs.CONN_MAX_IDLE_TIME)
 	}
 
-	d._log.WithFields(log.Fields{"service": "database"}).Println("Connection Opened to Database")
+	return db, nil
+}
 
-	return db
+// closeDB closes the connection pool of db, db can be nil
+func closeDB(db *gorm.DB) {
+	if db == nil || db.ConnPool == nil {
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
+
+// dsn returns the connection string of the database on given host
+// Statement timeout is sent as a runtime parameter of the connections, 0 leaves the server default
+func dsn(configs *common.Postgresql, host string, port string) string {
+	sslMode := configs.SSL_MODE
+	if sslMode == "" {
+		sslMode = defaultSSLMode
+	}
+
+	str := fmt.Sprintf("host=%s port=%s  user=%s password=%s  dbname=%s sslmode=%s ",
+		host,
+		port,
+		configs.USER,
+		configs.PASSWORD,
+		configs.NAME,
+		sslMode)
+
+	if configs.STATEMENT_TIMEOUT > 0 {
+		str += fmt.Sprintf("statement_timeout=%d ", configs.STATEMENT_TIMEOUT.Milliseconds())
+	}
+	return str
 }
 
 func NewDBHandler(configs *common.Postgresql, _log *log.Entry) DBHandler {
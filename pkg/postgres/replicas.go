@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PrimaryPool is the name of the pool of the primary database in the metrics
+const PrimaryPool = "primary"
+
+// Defaults of the replica settings which are not configured
+const (
+	defaultStickyWindow  = 5 * time.Second
+	defaultMaxReplicaLag = 5 * time.Second
+	defaultLagInterval   = 5 * time.Second
+)
+
+// replayLagQuery returns the replay lag of a replica in seconds
+// An idle replica has no new transaction to replay, it isn't lagging when it has replayed everything it received
+const replayLagQuery = `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+
+var (
+	poolReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_pool_reads_total",
+		Help: "Number of the reads which are routed to the pool",
+	}, []string{"pool"})
+	replicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "db_replica_lag_seconds",
+		Help: "Replay lag of the replica, it is +Inf while the lag can't be read",
+	}, []string{"pool"})
+)
+
+// ReadRouter picks the database which serves a read
+// Key is the id of the read record, reads of the records which are written recently are served by the primary
+// Empty key stands for the reads of many records, they are served by the primary after any write
+type ReadRouter interface {
+	Reader(key string) *gorm.DB
+	Written(keys ...string)
+	CheckLag()
+	Watch(ctx context.Context)
+}
+
+// LagProbe returns the replication lag of the replica
+type LagProbe func(db *gorm.DB) (time.Duration, error)
+
+// ReplayLag reads the replay lag of a Postgres replica
+func ReplayLag(db *gorm.DB) (time.Duration, error) {
+	var seconds float64
+	if err := db.Raw(replayLagQuery).Scan(&seconds).Error; err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Pool is a named database
+type Pool struct {
+	Name string
+	DB   *gorm.DB
+}
+
+type replica struct {
+	Pool
+	// lag is stored in nanoseconds, replicas are skipped until their lag is read
+	lag int64
+}
+
+// A ReplicaRouter sends the reads to the replicas in turn and the writes are left to the primary
+// Replicas which lag more than maxLag are skipped, the reads fall back to the primary when every replica lags
+type ReplicaRouter struct {
+	primary  *gorm.DB
+	replicas []*replica
+	probe    LagProbe
+	sticky   time.Duration
+	maxLag   time.Duration
+	interval time.Duration
+	next     uint32
+
+	mu        sync.Mutex
+	writes    map[string]time.Time
+	lastWrite time.Time
+	swept     time.Time
+
+	log *log.Entry
+}
+
+// Reader returns a replica unless the record of key is written in the sticky window or every replica lags
+func (r *ReplicaRouter) Reader(key string) *gorm.DB {
+	if len(r.replicas) == 0 || r.recentlyWritten(key) {
+		poolReads.WithLabelValues(PrimaryPool).Inc()
+		return r.primary
+	}
+
+	start := atomic.AddUint32(&r.next, 1)
+	for i := range r.replicas {
+		candidate := r.replicas[(int(start)+i)%len(r.replicas)]
+		if time.Duration(atomic.LoadInt64(&candidate.lag)) <= r.maxLag {
+			poolReads.WithLabelValues(candidate.Name).Inc()
+			return candidate.DB
+		}
+	}
+
+	poolReads.WithLabelValues(PrimaryPool).Inc()
+	return r.primary
+}
+
+// Written records the write of the records, they are read from the primary during the sticky window
+// Every read is served by the primary when there is no replica, the writes aren't recorded then
+func (r *ReplicaRouter) Written(keys ...string) {
+	if len(r.replicas) == 0 {
+		return
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.swept) >= r.sticky {
+		r.forget(now)
+	}
+	r.lastWrite = now
+	for _, key := range keys {
+		r.writes[key] = now
+	}
+}
+
+// CheckLag reads the lag of the replicas, the replicas whose lag can't be read are skipped until the next check
+// The writes which are older than the sticky window are forgotten
+func (r *ReplicaRouter) CheckLag() {
+	for _, replica := range r.replicas {
+		lag, err := r.probe(replica.DB)
+		if err != nil {
+			r.log.WithFields(log.Fields{"method": "CheckLag"}).Warnf("Lag of the replica %s can't be read: %v", replica.Name, err)
+			atomic.StoreInt64(&replica.lag, math.MaxInt64)
+			replicaLag.WithLabelValues(replica.Name).Set(math.Inf(1))
+			continue
+		}
+		atomic.StoreInt64(&replica.lag, int64(lag))
+		replicaLag.WithLabelValues(replica.Name).Set(lag.Seconds())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.forget(time.Now())
+}
+
+// Watch checks the lag of the replicas until ctx is done
+func (r *ReplicaRouter) Watch(ctx context.Context) {
+	if len(r.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.CheckLag()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *ReplicaRouter) recentlyWritten(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writtenAt := r.lastWrite
+	if key != "" {
+		writtenAt = r.writes[key]
+	}
+	if writtenAt.IsZero() {
+		return false
+	}
+	if time.Since(writtenAt) >= r.sticky {
+		delete(r.writes, key)
+		return false
+	}
+	return true
+}
+
+// forget removes the writes which are older than the sticky window, r.mu must be held
+func (r *ReplicaRouter) forget(now time.Time) {
+	r.swept = now
+	for key, writtenAt := range r.writes {
+		if now.Sub(writtenAt) >= r.sticky {
+			delete(r.writes, key)
+		}
+	}
+}
+
+// registerPool exports the connection pool statistics of the database
+func registerPool(name string, db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	// Pools are registered once per process, a second router shares the metrics of the first one
+	_ = prometheus.Register(collectors.NewDBStatsCollector(sqlDB, name))
+}
+
+// NewReplicaRouter returns the router of the reads to the replicas, the replicas are skipped until CheckLag reads their lag
+// Zero durations are replaced with their defaults
+func NewReplicaRouter(primary *gorm.DB, replicas []Pool, probe LagProbe, configs *common.Postgresql, _log *log.Entry) ReadRouter {
+	router := &ReplicaRouter{
+		primary:  primary,
+		probe:    probe,
+		sticky:   configs.STICKY_WINDOW,
+		maxLag:   configs.MAX_REPLICA_LAG,
+		interval: configs.LAG_INTERVAL,
+		writes:   make(map[string]time.Time),
+		log:      _log,
+	}
+	if router.sticky <= 0 {
+		router.sticky = defaultStickyWindow
+	}
+	if router.maxLag <= 0 {
+		router.maxLag = defaultMaxReplicaLag
+	}
+	if router.interval <= 0 {
+		router.interval = defaultLagInterval
+	}
+
+	registerPool(PrimaryPool, primary)
+	for _, pool := range replicas {
+		registerPool(pool.Name, pool.DB)
+		router.replicas = append(router.replicas, &replica{Pool: pool, lag: math.MaxInt64})
+	}
+	return router
+}
+
+// NewReadRouter connects to the configured replicas of the primary database
+func NewReadRouter(primary *gorm.DB, configs *common.Postgresql, _log *log.Entry) (ReadRouter, error) {
+	var replicas []Pool
+	for i, address := range configs.REPLICAS {
+		db, err := connect(configs, address.HOST, address.PORT, _log)
+		if err != nil {
+			return nil, fmt.Errorf("replica %s:%s: %w", address.HOST, address.PORT, err)
+		}
+		replicas = append(replicas, Pool{Name: fmt.Sprintf("replica_%d", i), DB: db})
+	}
+
+	if len(replicas) > 0 {
+		_log.WithFields(log.Fields{"service": "database"}).Printf("Connections Opened to %d Replicas", len(replicas))
+	}
+	return NewReplicaRouter(primary, replicas, ReplayLag, configs, _log), nil
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"time"
+)
+
+// memory is the DSN of a database which lives as long as its connection
+const memory = ":memory:"
+
+type DBService struct {
+	path string
+	_log *log.Entry
+}
+
+// New serves to open the SQLite database, it is kept in memory when the path is empty
+// A single connection is used, SQLite serializes the writes anyway and an in-memory database is bound to its connection
+func (d DBService) New() (*gorm.DB, error) {
+
+	newLogger := logger.New(
+		d._log.Logger, // io writer
+		logger.Config{
+			SlowThreshold:             time.Second,   // Slow SQL threshold
+			LogLevel:                  logger.Silent, // Log level
+			IgnoreRecordNotFoundError: true,          // Ignore ErrRecordNotFound error for logger
+			Colorful:                  false,         // Disable color
+		},
+	)
+
+	dsn := memory
+	if d.path != "" {
+		dsn = d.path + "?_busy_timeout=5000"
+	}
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+	sqlDB.SetConnMaxIdleTime(0)
+
+	d._log.WithFields(log.Fields{"service": "database"}).Println("Connection Opened to SQLite Database")
+
+	return db, nil
+}
+
+// NewDBHandler returns the handler of the SQLite database of given path
+func NewDBHandler(path string, _log *log.Entry) postgres.DBHandler {
+	return &DBService{path: path, _log: _log}
+}
@@ -0,0 +1,48 @@
+// Package storage selects the storage backend of the services
+package storage
+
+import (
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	"github.com/cemayan/faceit-technical-test/pkg/sqlite"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Storage backends
+// Memory keeps the users in the memory of the process and the other records in an in-memory SQLite database
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+	Memory   = "memory"
+)
+
+// Backend returns the configured backend, Postgres when it is not set
+func Backend(configs *common.Storage) string {
+	if configs.BACKEND == "" {
+		return Postgres
+	}
+	return configs.BACKEND
+}
+
+// NewDBHandler returns the database handler of the configured backend
+func NewDBHandler(configs *common.Storage, pgConfigs *common.Postgresql, _log *log.Entry) (postgres.DBHandler, error) {
+	switch Backend(configs) {
+	case Postgres:
+		return postgres.NewDBHandler(pgConfigs, _log), nil
+	case SQLite:
+		return sqlite.NewDBHandler(configs.SQLITE_PATH, _log), nil
+	case Memory:
+		return sqlite.NewDBHandler("", _log), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", configs.BACKEND)
+}
+
+// NewReadRouter returns the router of the reads, only Postgres has replicas and the other backends read from primary
+func NewReadRouter(configs *common.Storage, pgConfigs *common.Postgresql, primary *gorm.DB, _log *log.Entry) (postgres.ReadRouter, error) {
+	if Backend(configs) == Postgres {
+		return postgres.NewReadRouter(primary, pgConfigs, _log)
+	}
+	return postgres.NewReplicaRouter(primary, nil, nil, pgConfigs, _log), nil
+}
@@ -23,6 +23,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type EventGrpcServiceClient interface {
 	HandleEvent(ctx context.Context, opts ...grpc.CallOption) (EventGrpcService_HandleEventClient, error)
+	SubscribeUserEvents(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (EventGrpcService_SubscribeUserEventsClient, error)
 }
 
 type eventGrpcServiceClient struct {
@@ -64,11 +65,44 @@ func (x *eventGrpcServiceHandleEventClient) Recv() (*Response, error) {
 	return m, nil
 }
 
+func (c *eventGrpcServiceClient) SubscribeUserEvents(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (EventGrpcService_SubscribeUserEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EventGrpcService_ServiceDesc.Streams[1], "/protos.EventGrpcService/SubscribeUserEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventGrpcServiceSubscribeUserEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EventGrpcService_SubscribeUserEventsClient interface {
+	Recv() (*Events, error)
+	grpc.ClientStream
+}
+
+type eventGrpcServiceSubscribeUserEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *eventGrpcServiceSubscribeUserEventsClient) Recv() (*Events, error) {
+	m := new(Events)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // EventGrpcServiceServer is the server API for EventGrpcService service.
 // All implementations must embed UnimplementedEventGrpcServiceServer
 // for forward compatibility
 type EventGrpcServiceServer interface {
 	HandleEvent(EventGrpcService_HandleEventServer) error
+	SubscribeUserEvents(*SubscribeRequest, EventGrpcService_SubscribeUserEventsServer) error
 	mustEmbedUnimplementedEventGrpcServiceServer()
 }
 
@@ -79,6 +113,9 @@ type UnimplementedEventGrpcServiceServer struct {
 func (UnimplementedEventGrpcServiceServer) HandleEvent(EventGrpcService_HandleEventServer) error {
 	return status.Errorf(codes.Unimplemented, "method HandleEvent not implemented")
 }
+func (UnimplementedEventGrpcServiceServer) SubscribeUserEvents(*SubscribeRequest, EventGrpcService_SubscribeUserEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeUserEvents not implemented")
+}
 func (UnimplementedEventGrpcServiceServer) mustEmbedUnimplementedEventGrpcServiceServer() {}
 
 // UnsafeEventGrpcServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -118,6 +155,27 @@ func (x *eventGrpcServiceHandleEventServer) Recv() (*Events, error) {
 	return m, nil
 }
 
+func _EventGrpcService_SubscribeUserEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventGrpcServiceServer).SubscribeUserEvents(m, &eventGrpcServiceSubscribeUserEventsServer{stream})
+}
+
+type EventGrpcService_SubscribeUserEventsServer interface {
+	Send(*Events) error
+	grpc.ServerStream
+}
+
+type eventGrpcServiceSubscribeUserEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *eventGrpcServiceSubscribeUserEventsServer) Send(m *Events) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // EventGrpcService_ServiceDesc is the grpc.ServiceDesc for EventGrpcService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -132,6 +190,11 @@ var EventGrpcService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "SubscribeUserEvents",
+			Handler:       _EventGrpcService_SubscribeUserEvents_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "protos/event/event.proto",
 }
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"errors"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+)
+
+func TestAppError_NotFound(t *testing.T) {
+	appErr := apperror.Wrap(gorm.ErrRecordNotFound)
+
+	assert.Equal(t, apperror.NotFound, appErr.Kind)
+	assert.Equal(t, http.StatusNotFound, appErr.HTTPStatus())
+	assert.Equal(t, codes.NotFound, appErr.GRPCStatus().Code())
+}
+
+func TestAppError_UniqueViolation(t *testing.T) {
+	appErr := apperror.Wrap(&pgconn.PgError{Code: "23505", ConstraintName: "idx_users_email"})
+
+	assert.Equal(t, apperror.AlreadyExists, appErr.Kind)
+	assert.Equal(t, apperror.ReasonEmailTaken, appErr.Reason)
+	assert.Equal(t, http.StatusConflict, appErr.HTTPStatus())
+}
+
+func TestAppError_Validation(t *testing.T) {
+	type payload struct {
+		NickName string `json:"nickname" validate:"required"`
+	}
+
+	validate := validator.New()
+	apperror.UseJSONFieldNames(validate)
+
+	appErr := apperror.Wrap(validate.Struct(payload{}))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, appErr.HTTPStatus())
+	assert.Equal(t, "nickname", appErr.Violations[0].Field)
+}
+
+func TestAppError_StatusRoundTrip(t *testing.T) {
+	appErr := &apperror.Error{
+		Kind:       apperror.Validation,
+		Reason:     apperror.ReasonValidationFailed,
+		Message:    "validation failed",
+		Violations: []apperror.FieldViolation{{Field: "email", Description: "failed on the email rule"}},
+	}
+
+	st := status.FromProto(appErr.GRPCStatus().Proto())
+	converted := apperror.FromStatus(st)
+
+	assert.Equal(t, apperror.Validation, converted.Kind)
+	assert.Equal(t, appErr.Violations, converted.Violations)
+	assert.Equal(t, http.StatusUnprocessableEntity, converted.HTTPStatus())
+}
+
+func TestAppError_Internal(t *testing.T) {
+	appErr := apperror.Wrap(errors.New("boom"))
+
+	assert.Equal(t, apperror.Internal, appErr.Kind)
+	assert.Equal(t, http.StatusInternalServerError, appErr.HTTPStatus())
+}
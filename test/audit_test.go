@@ -0,0 +1,241 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"net/http/httptest"
+	"testing"
+)
+
+// memoryAuditLog keeps the audit entries in memory, filters are applied only by user id
+type memoryAuditLog struct {
+	entries []audit.Entry
+}
+
+func (l *memoryAuditLog) Append(entry *audit.Entry) error {
+	entry.ID = uint64(len(l.entries) + 1)
+	l.entries = append(l.entries, *entry)
+	return nil
+}
+
+func (l *memoryAuditLog) List(filter audit.Filter, limit int, offset int) ([]audit.Entry, int64, error) {
+	var matched []audit.Entry
+	_ = l.Export(filter, func(entry audit.Entry) error {
+		matched = append(matched, entry)
+		return nil
+	})
+	return matched, int64(len(matched)), nil
+}
+
+func (l *memoryAuditLog) Export(filter audit.Filter, fn func(entry audit.Entry) error) error {
+	for _, entry := range l.entries {
+		if filter.UserID != "" && entry.UserID != filter.UserID {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *memoryAuditLog) Scrub(userID string) (int64, error) {
+	var scrubbed int64
+	for i, entry := range l.entries {
+		if entry.UserID != userID {
+			continue
+		}
+		changes, err := audit.ScrubEntry(entry)
+		if err != nil {
+			return scrubbed, err
+		}
+		l.entries[i].Changes, l.entries[i].IP, l.entries[i].UserAgent = changes, "", ""
+		scrubbed++
+	}
+	return scrubbed, nil
+}
+
+func auditChanges(t *testing.T, entry audit.Entry) []audit.Change {
+	data, err := entry.Data()
+	assert.NoError(t, err)
+	return data.Changes
+}
+
+func TestAudit_DiffAndRedact(t *testing.T) {
+	changes := audit.Diff(
+		map[string]string{"nickname": "nick", "password": "hash1", "country": "UK"},
+		map[string]string{"nickname": "new", "password": "hash2", "country": "UK"},
+	)
+
+	entry, err := audit.NewEntry(audit.ActionUserUpdated, "1", "user", audit.Meta{ActorID: "admin", IP: "10.0.0.1"}, changes)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", entry.ActorID)
+	assert.NotContains(t, string(entry.Changes), "hash")
+	assert.Equal(t, []audit.Change{
+		{Field: "nickname", From: "nick", To: "new"},
+		{Field: "password", From: audit.Redacted, To: audit.Redacted},
+	}, auditChanges(t, *entry))
+
+	// deleted record
+	assert.Equal(t, []audit.Change{{Field: "nickname", From: "nick"}}, audit.Diff(map[string]string{"nickname": "nick"}, nil))
+}
+
+func TestAudit_AppendOnly(t *testing.T) {
+	entry := &audit.Entry{}
+	assert.ErrorIs(t, entry.BeforeUpdate(nil), audit.ErrAppendOnly)
+	assert.ErrorIs(t, entry.BeforeDelete(nil), audit.ErrAppendOnly)
+}
+
+func TestRecorder_WritesAuditLog(t *testing.T) {
+	store := &memoryEventStore{}
+	auditLog := &memoryAuditLog{}
+	aggregates := repo.NewUserAggregateRepo(store, &memorySnapshots{}, 0, log.NewEntry(log.New()))
+	recorder := handler.NewEventRecorder(store, broker.NewEventBroker(), aggregates, auditLog, log.New())
+	meta := &pb.RequestMeta{Ip: "10.0.0.1", UserAgent: "curl", RequestId: "req-1"}
+
+	recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_CREATED,
+		Actor:     "signup",
+		Meta:      meta,
+		Payload:   &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "nick", Email: "nick@mail.com", Password: "secret"}},
+	}, "1")
+	recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_UPDATED,
+		Actor:     "admin",
+		Meta:      meta,
+		Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+			Email:      "new@mail.com",
+			Password:   "secret2",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email", "password"}},
+		}},
+	}, "1")
+	// derived from the update above
+	recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_EMAIL_CHANGED,
+		Actor:     "admin",
+		Payload:   &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Email: "new@mail.com"}},
+	}, "1")
+	recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_PASSWORD_CHANGED,
+		Actor:     "admin",
+		Payload:   &pb.Events_ChangePassword{ChangePassword: &pb.ChangePassword{Password: "secret3"}},
+	}, "1")
+	recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_ROLE_CHANGED,
+		Actor:     "admin",
+		Payload:   &pb.Events_ChangeRole{ChangeRole: &pb.ChangeRole{Role: pb.Role_ROLE_ADMIN}},
+	}, "1")
+
+	assert.Len(t, auditLog.entries, 4)
+
+	created := auditLog.entries[0]
+	assert.Equal(t, "USER_CREATED", created.Action)
+	assert.Equal(t, "1", created.UserID)
+	assert.Equal(t, "signup", created.ActorID)
+	assert.Equal(t, "10.0.0.1", created.IP)
+	assert.Equal(t, "curl", created.UserAgent)
+	assert.Equal(t, "req-1", created.RequestID)
+	assert.Equal(t, "grpcsrv", created.Source)
+	assert.Contains(t, auditChanges(t, created), audit.Change{Field: "nickname", To: "nick"})
+
+	assert.Equal(t, []audit.Change{{Field: "email", From: "nick@mail.com", To: "new@mail.com"}}, auditChanges(t, auditLog.entries[1]))
+	assert.Equal(t, []audit.Change{{Field: "password", From: audit.Redacted, To: audit.Redacted}}, auditChanges(t, auditLog.entries[2]))
+	assert.Equal(t, []audit.Change{{Field: "role", From: "user", To: "admin"}}, auditChanges(t, auditLog.entries[3]))
+
+	for _, entry := range auditLog.entries {
+		assert.NotContains(t, string(entry.Changes), "secret")
+	}
+}
+
+func TestUserGrpcServer_StampsRequestMeta(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	recorder := &capturingRecorder{}
+	userServer := handler.NewUserGrpcServer(userService(userRepo), recorder, log.New())
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-actor", "admin",
+		"x-request-id", "req-1",
+		"grpcgateway-user-agent", "curl",
+		"x-forwarded-for", "10.0.0.1, 10.0.0.2",
+	))
+	_, err := userServer.SuspendUser(ctx, &pb.SuspendUser{Id: userRepo.user.ID.String(), Reason: "spam"})
+	assert.NoError(t, err)
+
+	event := recorder.events[0]
+	assert.Equal(t, "admin", event.Actor)
+	assert.Equal(t, "10.0.0.1", event.Meta.Ip)
+	assert.Equal(t, "curl", event.Meta.UserAgent)
+	assert.Equal(t, "req-1", event.Meta.RequestId)
+}
+
+func TestAuditService_Export(t *testing.T) {
+	auditLog := &memoryAuditLog{}
+	for _, userID := range []string{"1", "2", "1"} {
+		entry, _ := audit.NewEntry(audit.ActionUserUpdated, userID, "user", audit.Meta{ActorID: "admin"}, []audit.Change{{Field: "password", From: "a", To: "b"}})
+		_ = auditLog.Append(entry)
+	}
+
+	app := fiber.New()
+	auditSvc := service.NewAuditService(auditLog, log.NewEntry(log.New()))
+	app.Get("/admin/audit", auditSvc.ListAuditLog)
+	app.Get("/admin/audit/export", auditSvc.ExportAuditLog)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin/audit/export?user_id=1", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var lines []audit.EntryData
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var data audit.EntryData
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &data))
+		lines = append(lines, data)
+	}
+	assert.Len(t, lines, 2)
+	assert.Equal(t, uint64(3), lines[1].ID)
+	assert.Equal(t, audit.Redacted, lines[1].Changes[0].To)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/admin/audit?from=yesterday", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestRecorder_LoadsUserOnce(t *testing.T) {
+	store := &memoryEventStore{}
+	snapshots := &memorySnapshots{}
+	aggregates := repo.NewUserAggregateRepo(store, snapshots, 3, log.NewEntry(log.New()))
+	recorder := handler.NewEventRecorder(store, broker.NewEventBroker(), aggregates, &memoryAuditLog{}, log.New())
+
+	assert.NoError(t, recorder.Record(&pb.Events{
+		EventName: pb.EventName_USER_CREATED,
+		Payload:   &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "nick", Email: "nick@mail.com"}},
+	}, "1"))
+	for i := 0; i < 2; i++ {
+		store.reads = 0
+		assert.NoError(t, recorder.Record(&pb.Events{
+			EventName: pb.EventName_USER_ROLE_CHANGED,
+			Payload:   &pb.Events_ChangeRole{ChangeRole: &pb.ChangeRole{Role: pb.Role_ROLE_ADMIN}},
+		}, "1"))
+		// only the events before the recorded one are read, once
+		assert.Equal(t, i+1, store.reads)
+	}
+
+	// the snapshot is the state after the last event
+	assert.Len(t, snapshots.snapshots, 1)
+	assert.Equal(t, 3, snapshots.snapshots[0].Version)
+	assert.Equal(t, "admin", snapshots.snapshots[0].Role)
+	assert.Equal(t, int64(3), snapshots.snapshots[0].Sequence)
+}
@@ -0,0 +1,270 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/pkg/cache"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+	"io"
+	"testing"
+	"time"
+)
+
+// countingUserRepo counts the users which are read from the repository
+// afterRead is called once a user is read, before it is returned to the cache
+type countingUserRepo struct {
+	domain.UserRepository
+	reads     int
+	afterRead func()
+}
+
+func (r *countingUserRepo) GetUserByID(id string) (*domain.User, error) {
+	r.reads++
+	user, err := r.UserRepository.GetUserByID(id)
+	if r.afterRead != nil {
+		r.afterRead()
+	}
+	return user, err
+}
+
+// brokenCache fails every call
+type brokenCache struct{}
+
+func (brokenCache) Get(key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (brokenCache) Set(key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (brokenCache) Delete(keys ...string) error {
+	return errors.New("connection refused")
+}
+
+func newCachedRepo(c cache.Cache) (domain.CachedUserRepository, *countingUserRepo) {
+	users := &countingUserRepo{UserRepository: domain.NewMemoryUserRepo(nil, nil, log.NewEntry(log.New()))}
+	return domain.NewCachedUserRepo(users, c, time.Minute, log.NewEntry(log.New())), users
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := cache.NewLRU(2)
+	require.NoError(t, c.Set("a", []byte("1"), 0))
+	require.NoError(t, c.Set("b", []byte("2"), 0))
+
+	_, ok, _ := c.Get("a")
+	assert.True(t, ok)
+	require.NoError(t, c.Set("c", []byte("3"), 0))
+
+	_, ok, _ = c.Get("b")
+	assert.False(t, ok)
+	value, ok, _ := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	require.NoError(t, c.Delete("a", "missing"))
+	_, ok, _ = c.Get("a")
+	assert.False(t, ok)
+}
+
+func TestLRUCacheExpiresEntries(t *testing.T) {
+	c := cache.NewLRU(10)
+	require.NoError(t, c.Set("a", []byte("1"), 20*time.Millisecond))
+	require.NoError(t, c.Set("b", []byte("2"), 0))
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, ok, _ := c.Get("a")
+	assert.False(t, ok)
+	_, ok, _ = c.Get("b")
+	assert.True(t, ok)
+}
+
+func TestCacheNew(t *testing.T) {
+	c, err := cache.New(&common.Cache{SIZE: 0})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = cache.New(&common.Cache{SIZE: 10})
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+
+	_, err = cache.New(&common.Cache{BACKEND: "redis", SIZE: 10})
+	assert.Error(t, err)
+}
+
+func TestCachedUserRepoReadsThrough(t *testing.T) {
+	r, users := newCachedRepo(cache.NewLRU(10))
+	user := createUser(t, r, "alice", "UK")
+	id := user.ID.String()
+
+	for i := 0; i < 3; i++ {
+		found, err := r.GetUserByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", found.NickName)
+		assert.Equal(t, "hash", found.Password)
+	}
+	assert.Equal(t, 1, users.reads)
+
+	_, err := r.GetUserByID("9b2b6b1e-7d1c-4d5e-9c3f-1f1f1f1f1f1f")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	_, err = r.GetUserByID("9b2b6b1e-7d1c-4d5e-9c3f-1f1f1f1f1f1f")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	assert.Equal(t, 3, users.reads)
+}
+
+func TestCachedUserRepoInvalidatesOnWrites(t *testing.T) {
+	r, users := newCachedRepo(cache.NewLRU(10))
+	user := createUser(t, r, "alice", "UK")
+	id := user.ID.String()
+
+	writes := []func() error{
+		func() error { _, err := r.UpdateUser(id, &domain.UpdateUser{Country: "DE"}); return err },
+		func() error { _, err := r.ChangeEmail(id, "alice@new.com"); return err },
+		func() error { _, err := r.ChangePassword(id, "new-hash"); return err },
+		func() error { _, err := r.ChangeRole(id, domain.RoleAdmin); return err },
+		func() error { _, err := r.SuspendUser(id, "spam"); return err },
+		func() error { _, err := r.ReactivateUser(id); return err },
+	}
+
+	for i, write := range writes {
+		_, err := r.GetUserByID(id)
+		require.NoError(t, err)
+		require.NoError(t, write())
+		_, err = r.GetUserByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, i+2, users.reads)
+	}
+
+	found, err := r.GetUserByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "DE", found.Country)
+	assert.Equal(t, "alice@new.com", found.Email)
+	assert.Equal(t, domain.RoleAdmin, found.Role)
+
+	require.NoError(t, r.DeleteUser(id))
+	_, err = r.GetUserByID(id)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func TestCachedUserRepoDoesNotCacheReadsRacingWrites(t *testing.T) {
+	r, users := newCachedRepo(cache.NewLRU(10))
+	id := createUser(t, r, "alice", "UK").ID.String()
+
+	// the user is updated after it is read but before it is cached
+	users.afterRead = func() {
+		users.afterRead = nil
+		_, err := r.UpdateUser(id, &domain.UpdateUser{Country: "DE"})
+		require.NoError(t, err)
+	}
+	stale, err := r.GetUserByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "UK", stale.Country)
+
+	found, err := r.GetUserByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "DE", found.Country)
+	assert.Equal(t, 2, users.reads)
+}
+
+func TestCachedUserRepoInvalidate(t *testing.T) {
+	c := cache.NewLRU(10)
+	r, users := newCachedRepo(c)
+	user := createUser(t, r, "alice", "UK")
+	id := user.ID.String()
+
+	_, err := r.GetUserByID(id)
+	require.NoError(t, err)
+
+	// Another process evicts the user through the shared cache
+	domain.NewUserInvalidator(c, log.NewEntry(log.New())).Invalidate(id)
+
+	_, err = r.GetUserByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users.reads)
+}
+
+func TestCachedUserRepoFallsBackWhenCacheFails(t *testing.T) {
+	r, users := newCachedRepo(brokenCache{})
+	user := createUser(t, r, "alice", "UK")
+
+	found, err := r.GetUserByID(user.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "alice", found.NickName)
+	assert.Equal(t, 1, users.reads)
+
+	_, err = r.UpdateUser(user.ID.String(), &domain.UpdateUser{Country: "DE"})
+	assert.NoError(t, err)
+}
+
+// recordingInvalidator records the invalidated users
+type recordingInvalidator struct {
+	ids []string
+}
+
+func (i *recordingInvalidator) Invalidate(ids ...string) {
+	i.ids = append(i.ids, ids...)
+}
+
+// scriptedSubscription returns its events and then fails
+type scriptedSubscription struct {
+	pb.EventGrpcService_SubscribeUserEventsClient
+	events []*pb.Events
+}
+
+func (s *scriptedSubscription) Recv() (*pb.Events, error) {
+	if len(s.events) == 0 {
+		return nil, io.EOF
+	}
+	event := s.events[0]
+	s.events = s.events[1:]
+	return event, nil
+}
+
+// subscriptionClient serves the first subscription with events, the next one stops the listener
+type subscriptionClient struct {
+	pb.EventGrpcServiceClient
+	events   []*pb.Events
+	requests []*pb.SubscribeRequest
+	cancel   context.CancelFunc
+}
+
+func (c *subscriptionClient) SubscribeUserEvents(ctx context.Context, in *pb.SubscribeRequest, opts ...grpc.CallOption) (pb.EventGrpcService_SubscribeUserEventsClient, error) {
+	c.requests = append(c.requests, in)
+	if len(c.requests) > 1 {
+		c.cancel()
+		return nil, ctx.Err()
+	}
+	return &scriptedSubscription{events: c.events}, nil
+}
+
+func TestInvalidationListenerResumesBelowHighestSequence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &subscriptionClient{
+		events: []*pb.Events{
+			{InternalId: "a", Sequence: 11},
+			{Sequence: 12},
+			{InternalId: "b", Sequence: 400},
+			{InternalId: "c", Sequence: 300},
+		},
+		cancel: cancel,
+	}
+	invalidator := &recordingInvalidator{}
+
+	eventclient.NewInvalidationListener(client, invalidator, log.NewEntry(log.New())).Run(ctx, 10)
+
+	assert.Equal(t, []string{"a", "b", "c"}, invalidator.ids)
+	require.Len(t, client.requests, 2)
+	assert.Equal(t, int64(10), client.requests[0].FromSequence)
+	assert.Equal(t, int64(400-256), client.requests[1].FromSequence)
+}
@@ -0,0 +1,260 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	userutil "github.com/cemayan/faceit-technical-test/internal/user/util"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	"github.com/cemayan/faceit-technical-test/pkg/sqlite"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// conformanceDB returns a migrated SQLite database in a temporary file
+func conformanceDB(t *testing.T) *gorm.DB {
+	logger := log.New().WithFields(log.Fields{"service": "conformance"})
+	db, err := sqlite.NewDBHandler(filepath.Join(t.TempDir(), "users.db"), logger).New()
+	require.NoError(t, err)
+	userutil.MigrateDB(db, logger)
+	return db
+}
+
+// userBackends are the implementations of domain.UserRepository which must pass the conformance suite
+func userBackends() map[string]func(t *testing.T) domain.UserRepository {
+	logger := log.New().WithFields(log.Fields{"service": "conformance"})
+	return map[string]func(t *testing.T) domain.UserRepository{
+		"memory": func(t *testing.T) domain.UserRepository {
+			return domain.NewMemoryUserRepo(conformanceDB(t), repo.EventStoreScrub(logger), logger)
+		},
+		"sqlite": func(t *testing.T) domain.UserRepository {
+			return domain.NewUserRepo(conformanceDB(t), repo.EventStoreScrub(logger), logger)
+		},
+	}
+}
+
+// fastHashes lowers the bcrypt cost of the passwords during the test
+func fastHashes(t *testing.T) {
+	cost := domain.HashCost
+	domain.HashCost = bcrypt.MinCost
+	t.Cleanup(func() { domain.HashCost = cost })
+}
+
+func reasonOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return apperror.Wrap(err).Reason
+}
+
+func createUser(t *testing.T, r domain.UserRepository, nickname string, country string) *domain.User {
+	user, err := r.CreateUser(&domain.User{NickName: nickname, Email: nickname + "@mail.com", Password: "hash", Country: country})
+	require.NoError(t, err)
+	return user
+}
+
+func usersOf(t *testing.T, page *common.Pagination) []string {
+	require.NotNil(t, page)
+	var nicknames []string
+	switch rows := page.Rows.(type) {
+	case []domain.User:
+		for _, user := range rows {
+			nicknames = append(nicknames, user.NickName)
+		}
+	default:
+		t.Fatalf("unexpected rows %T", page.Rows)
+	}
+	return nicknames
+}
+
+func TestUserRepoConformance(t *testing.T) {
+	for name, newRepo := range userBackends() {
+		newRepo := newRepo
+
+		t.Run(name+"/CreateAndGet", func(t *testing.T) {
+			r := newRepo(t)
+			user := createUser(t, r, "alice", "UK")
+
+			assert.Equal(t, "hash", user.Password)
+			assert.Equal(t, domain.RoleUser, user.Role)
+
+			found, err := r.GetUserByID(user.ID.String())
+			require.NoError(t, err)
+			assert.Equal(t, "alice", found.NickName)
+			assert.Equal(t, "alice@mail.com", found.Email)
+			assert.Equal(t, "UK", found.Country)
+
+			_, err = r.GetUserByID(user.ID.String()[:8])
+			assert.Error(t, err)
+			_, err = r.GetUserByID("9b2b6b1e-7d1c-4d5e-9c3f-1f1f1f1f1f1f")
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		})
+
+		t.Run(name+"/UniqueAmongActiveUsers", func(t *testing.T) {
+			r := newRepo(t)
+			first := createUser(t, r, "alice", "UK")
+
+			_, err := r.CreateUser(&domain.User{NickName: "alice", Email: "other@mail.com", Password: "hash"})
+			assert.Equal(t, apperror.ReasonNicknameTaken, reasonOf(err))
+			_, err = r.CreateUser(&domain.User{NickName: "other", Email: "Alice@mail.com", Password: "hash"})
+			assert.Equal(t, apperror.ReasonEmailTaken, reasonOf(err))
+
+			assert.NoError(t, r.DeleteUser(first.ID.String()))
+			_, err = r.CreateUser(&domain.User{NickName: "alice", Email: "other@mail.com", Password: "hash"})
+			require.NoError(t, err)
+
+			_, err = r.RestoreUser(first.ID.String())
+			assert.Equal(t, apperror.ReasonNicknameTaken, reasonOf(err))
+		})
+
+		t.Run(name+"/FilterSortPaginate", func(t *testing.T) {
+			r := newRepo(t)
+			for i, country := range []string{"UK", "DE", "UK", "FR", "UK"} {
+				createUser(t, r, fmt.Sprintf("user%d", i), country)
+			}
+
+			page, err := r.GetAllUser(common.Pagination{Limit: 2, Page: 2, SColumn: common.NickName, SType: common.DESC})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"user2", "user1"}, usersOf(t, page))
+			assert.Equal(t, int64(5), page.TotalRows)
+			assert.Equal(t, 3, page.TotalPages)
+
+			page, err = r.GetAllUser(common.Pagination{CQuery: "country = ?", CValue: "UK", SColumn: common.NickName})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"user0", "user2", "user4"}, usersOf(t, page))
+			assert.Equal(t, int64(3), page.TotalRows)
+
+			page, err = r.GetAllUser(common.Pagination{CQuery: "nick_name LIKE ?", CValue: "user_", SColumn: common.Country, Limit: 1, Page: 5})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"user4"}, usersOf(t, page))
+
+			page, err = r.GetAllUser(common.Pagination{Conditions: map[string]interface{}{"country": "DE"}})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"user1"}, usersOf(t, page))
+
+			page, err = r.GetAllUser(common.Pagination{CQuery: "country = ?"})
+			assert.NoError(t, err)
+			assert.Nil(t, page)
+		})
+
+		t.Run(name+"/Update", func(t *testing.T) {
+			r := newRepo(t)
+			user := createUser(t, r, "alice", "UK")
+			createUser(t, r, "bob", "UK")
+
+			updated, err := r.UpdateUser(user.ID.String(), &domain.UpdateUser{Country: "DE", FirstName: "", UpdateMask: []string{"country", "first_name"}})
+			require.NoError(t, err)
+			assert.Equal(t, "DE", updated.Country)
+
+			updated, err = r.UpdateUser(user.ID.String(), &domain.UpdateUser{LastName: "Smith"})
+			require.NoError(t, err)
+			assert.Equal(t, "Smith", updated.LastName)
+			assert.Empty(t, updated.FirstName)
+
+			found, err := r.GetUserByID(user.ID.String())
+			require.NoError(t, err)
+			assert.Equal(t, "DE", found.Country)
+			assert.Equal(t, "Smith", found.LastName)
+
+			_, err = r.UpdateUser(user.ID.String(), &domain.UpdateUser{NickName: "bob"})
+			assert.Equal(t, apperror.ReasonNicknameTaken, reasonOf(err))
+
+			changed, err := r.ChangeEmail(user.ID.String(), "alice@new.com")
+			require.NoError(t, err)
+			assert.Equal(t, "alice@new.com", changed.Email)
+
+			changed, err = r.ChangePassword(user.ID.String(), "new-hash")
+			require.NoError(t, err)
+			assert.Equal(t, "new-hash", changed.Password)
+		})
+
+		t.Run(name+"/Lifecycle", func(t *testing.T) {
+			r := newRepo(t)
+			user := createUser(t, r, "alice", "UK")
+			id := user.ID.String()
+
+			suspended, err := r.SuspendUser(id, "spam")
+			require.NoError(t, err)
+			assert.True(t, suspended.Suspended)
+
+			_, err = r.UpdateUser(id, &domain.UpdateUser{Country: "DE"})
+			assert.Equal(t, apperror.ReasonUserSuspended, reasonOf(err))
+			_, err = r.ChangeEmail(id, "alice@new.com")
+			assert.Equal(t, apperror.ReasonUserSuspended, reasonOf(err))
+
+			reactivated, err := r.ReactivateUser(id)
+			require.NoError(t, err)
+			assert.False(t, reactivated.Suspended)
+			assert.Empty(t, reactivated.SuspendReason)
+
+			_, err = r.ReactivateUser(id)
+			assert.Equal(t, apperror.ReasonUserNotSuspended, reasonOf(err))
+
+			admin, err := r.ChangeRole(id, "admin")
+			require.NoError(t, err)
+			assert.Equal(t, "admin", admin.Role)
+		})
+
+		t.Run(name+"/Trash", func(t *testing.T) {
+			r := newRepo(t)
+			alice := createUser(t, r, "alice", "UK")
+			bob := createUser(t, r, "bob", "UK")
+
+			assert.NoError(t, r.DeleteUser(alice.ID.String()))
+			_, err := r.GetUserByID(alice.ID.String())
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+			page, err := r.GetAllUser(common.Pagination{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bob"}, usersOf(t, page))
+
+			time.Sleep(5 * time.Millisecond)
+			assert.NoError(t, r.DeleteUser(bob.ID.String()))
+
+			page, err = r.GetDeletedUsers(common.Pagination{Limit: 1})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bob"}, usersOf(t, page))
+			assert.Equal(t, int64(2), page.TotalRows)
+			assert.Equal(t, 2, page.TotalPages)
+
+			restored, err := r.RestoreUser(bob.ID.String())
+			require.NoError(t, err)
+			assert.False(t, restored.Deleted.Valid)
+			_, err = r.RestoreUser(bob.ID.String())
+			assert.Equal(t, apperror.ReasonUserNotDeleted, reasonOf(err))
+
+			ids, err := r.PurgeDeletedUsers(time.Now().Add(time.Minute), 10)
+			require.NoError(t, err)
+			assert.Equal(t, []string{alice.ID.String()}, ids)
+			_, err = r.RestoreUser(alice.ID.String())
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		})
+
+		t.Run(name+"/Erase", func(t *testing.T) {
+			r := newRepo(t)
+			user := createUser(t, r, "alice", "UK")
+			id := user.ID.String()
+
+			receipt, err := r.EraseUser(id, erasure.Receipt{ActorID: "admin", Source: "conformance"})
+			require.NoError(t, err)
+			assert.Equal(t, id, receipt.UserID)
+			assert.Equal(t, "admin", receipt.ActorID)
+
+			_, err = r.GetUserByID(id)
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+			_, err = r.RestoreUser(id)
+			assert.Equal(t, apperror.ReasonUserErased, reasonOf(err))
+
+			createUser(t, r, "alice", "UK")
+		})
+	}
+}
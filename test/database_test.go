@@ -0,0 +1,40 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// closedPort returns a local port which refuses the connections
+func closedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+	return port
+}
+
+func TestDBServiceRetriesAndReturnsError(t *testing.T) {
+	configs := &common.Postgresql{
+		HOST:              "127.0.0.1",
+		PORT:              closedPort(t),
+		USER:              "postgres",
+		PASSWORD:          "password",
+		NAME:              "faceit",
+		STATEMENT_TIMEOUT: time.Second,
+		CONNECT_RETRIES:   2,
+		CONNECT_BACKOFF:   10 * time.Millisecond,
+	}
+
+	start := time.Now()
+	db, err := postgres.NewDBHandler(configs, log.New().WithFields(log.Fields{"service": "database"})).New()
+
+	assert.Nil(t, db)
+	assert.ErrorContains(t, err, "failed to connect database")
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
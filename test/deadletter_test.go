@@ -0,0 +1,325 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingUserRepo fails every delete with err
+type failingUserRepo struct {
+	domain.UserRepository
+	err error
+}
+
+func (r failingUserRepo) DeleteUser(id string) error {
+	return r.err
+}
+
+type capturingSender struct {
+	responses []*pb.Response
+}
+
+func (s *capturingSender) Send(response *pb.Response) error {
+	s.responses = append(s.responses, response)
+	return nil
+}
+
+// memoryDeadLetters keeps the dead letters in a map
+type memoryDeadLetters struct {
+	events   map[string]*pb.Events
+	attempts map[string]int
+	reasons  map[string]string
+}
+
+func newMemoryDeadLetters() *memoryDeadLetters {
+	return &memoryDeadLetters{events: map[string]*pb.Events{}, attempts: map[string]int{}, reasons: map[string]string{}}
+}
+
+func (m *memoryDeadLetters) Add(event *pb.Events, appErr *apperror.Error) error {
+	if event.CorrelationId == "" {
+		event.CorrelationId = uuid.New().String()
+	}
+	m.events[event.CorrelationId] = event
+	m.attempts[event.CorrelationId]++
+	m.reasons[event.CorrelationId] = appErr.Reason
+	return nil
+}
+
+func (m *memoryDeadLetters) RecordAttempt(id string, appErr *apperror.Error) error {
+	m.attempts[id]++
+	m.reasons[id] = appErr.Reason
+	return nil
+}
+
+func (m *memoryDeadLetters) List(limit int, offset int) ([]model.DeadLetter, int64, error) {
+	var records []model.DeadLetter
+	for id, event := range m.events {
+		records = append(records, model.DeadLetter{ID: id, EventName: int32(event.EventName), Attempts: m.attempts[id], Reason: m.reasons[id]})
+	}
+	return records, int64(len(records)), nil
+}
+
+func (m *memoryDeadLetters) Get(id string) (*model.DeadLetter, *pb.Events, error) {
+	event, ok := m.events[id]
+	if !ok {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	return &model.DeadLetter{ID: id, EventName: int32(event.EventName), Attempts: m.attempts[id], Reason: m.reasons[id]}, event, nil
+}
+
+func (m *memoryDeadLetters) Delete(id string) error {
+	if _, ok := m.events[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(m.events, id)
+	return nil
+}
+
+func (m *memoryDeadLetters) Count() (int64, error) {
+	return int64(len(m.events)), nil
+}
+
+// stubEventClient answers every event with response
+type stubEventClient struct {
+	response *pb.Response
+	sent     []*pb.Events
+}
+
+func (s *stubEventClient) Send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	s.sent = append(s.sent, event)
+	return s.response, nil
+}
+
+func (s *stubEventClient) SendIdempotent(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	return s.Send(ctx, event)
+}
+
+func (s *stubEventClient) State() eventclient.State { return eventclient.Closed }
+
+func (s *stubEventClient) Close() error { return nil }
+
+func deleteEvent(id string) *pb.Events {
+	return &pb.Events{
+		EventName:     pb.EventName_USER_DELETED,
+		CorrelationId: uuid.New().String(),
+		Payload:       &pb.Events_DeleteUser{DeleteUser: &pb.DeleteUser{Id: id}},
+	}
+}
+
+func TestUserHandler_DeadLettersServerFailures(t *testing.T) {
+	deadLetters := newMemoryDeadLetters()
+	sender := &capturingSender{}
+	userHandler := handler.NewUserEventHandler(userService(failingUserRepo{err: errors.New("connection refused")}), nil, sender, deadLetters, log.New())
+
+	event := deleteEvent(uuid.New().String())
+	assert.NoError(t, userHandler.Handle(event))
+
+	assert.Equal(t, int32(500), sender.responses[0].StatusCode)
+	assert.Contains(t, deadLetters.events, event.CorrelationId)
+	assert.Equal(t, 1, deadLetters.attempts[event.CorrelationId])
+}
+
+func TestUserHandler_DoesNotDeadLetterClientErrors(t *testing.T) {
+	deadLetters := newMemoryDeadLetters()
+	sender := &capturingSender{}
+	userHandler := handler.NewUserEventHandler(userService(failingUserRepo{err: gorm.ErrRecordNotFound}), nil, sender, deadLetters, log.New())
+
+	assert.NoError(t, userHandler.Handle(deleteEvent(uuid.New().String())))
+
+	assert.Equal(t, int32(404), sender.responses[0].StatusCode)
+	assert.Empty(t, deadLetters.events)
+}
+
+// failingEventStore fails every append
+type failingEventStore struct {
+	memoryEventStore
+}
+
+func (s *failingEventStore) Append(event *pb.Events) (*pb.Events, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUserHandler_DoesNotDeadLetterUnrecordedEvents(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	deadLetters := newMemoryDeadLetters()
+	sender := &capturingSender{}
+	recorder := handler.NewEventRecorder(&failingEventStore{}, broker.NewEventBroker(), nil, nil, log.New())
+	userHandler := handler.NewUserEventHandler(userService(userRepo), recorder, sender, deadLetters, log.New())
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName: pb.EventName_USER_SUSPENDED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: userRepo.user.ID.String(), Reason: "spam"}},
+	}))
+
+	assert.True(t, userRepo.user.Suspended)
+	assert.Equal(t, int32(500), sender.responses[0].StatusCode)
+	assert.Equal(t, "user is changed but its event couldn't be recorded", sender.responses[0].Message)
+	assert.Empty(t, deadLetters.events)
+}
+
+func newDeadLetterApp(deadLetters repo.DeadLetterRepository, events eventclient.EventClient) *fiber.App {
+	app := fiber.New()
+	svc := service.NewDeadLetterService(deadLetters, events, log.New().WithFields(log.Fields{"service": "test"}), &user.AppConfig{})
+	app.Get("/dlq", svc.ListDeadLetters)
+	app.Get("/dlq/:id", svc.GetDeadLetter)
+	app.Post("/dlq/:id/retry", svc.RetryDeadLetter)
+	app.Delete("/dlq/:id", svc.DiscardDeadLetter)
+	return app
+}
+
+func TestDeadLetterService_RetrySucceeds(t *testing.T) {
+	deadLetters := newMemoryDeadLetters()
+	event := deleteEvent(uuid.New().String())
+	_ = deadLetters.Add(event, apperror.New(apperror.Internal, apperror.ReasonInternal, "internal error"))
+
+	events := &stubEventClient{response: &pb.Response{StatusCode: 200}}
+	app := newDeadLetterApp(deadLetters, events)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/dlq/"+event.CorrelationId+"/retry", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, event.CorrelationId, events.sent[0].CorrelationId)
+	assert.Empty(t, deadLetters.events)
+}
+
+func TestDeadLetterService_RetryFails(t *testing.T) {
+	deadLetters := newMemoryDeadLetters()
+	event := deleteEvent(uuid.New().String())
+	_ = deadLetters.Add(event, apperror.New(apperror.Internal, apperror.ReasonInternal, "internal error"))
+
+	notFound := apperror.New(apperror.NotFound, apperror.ReasonUserNotFound, "user not found")
+	events := &stubEventClient{response: &pb.Response{StatusCode: 404, Status: notFound.GRPCStatus().Proto()}}
+	app := newDeadLetterApp(deadLetters, events)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/dlq/"+event.CorrelationId+"/retry", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, 2, deadLetters.attempts[event.CorrelationId])
+	assert.Equal(t, apperror.ReasonUserNotFound, deadLetters.reasons[event.CorrelationId])
+}
+
+func TestDeadLetterService_InspectAndDiscard(t *testing.T) {
+	deadLetters := newMemoryDeadLetters()
+	event := deleteEvent(uuid.New().String())
+	_ = deadLetters.Add(event, apperror.New(apperror.Internal, apperror.ReasonInternal, "internal error"))
+	app := newDeadLetterApp(deadLetters, &stubEventClient{})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/dlq/"+event.CorrelationId, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var body struct {
+		Data model.DeadLetterData `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "USER_DELETED", body.Data.EventName)
+	assert.NotNil(t, body.Data.Event)
+
+	resp, err = app.Test(httptest.NewRequest("DELETE", "/dlq/"+event.CorrelationId, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("DELETE", "/dlq/"+event.CorrelationId, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+// passwordEvents are the events which carry a password, the update is not masked
+func passwordEvents(id string) []*pb.Events {
+	return []*pb.Events{
+		{
+			EventName:     pb.EventName_USER_CREATED,
+			CorrelationId: uuid.New().String(),
+			Payload:       &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "alice", Email: "alice@mail.com", Password: "s3cret-pass", FirstName: "Alice"}},
+		},
+		{
+			EventName:     pb.EventName_USER_UPDATED,
+			CorrelationId: uuid.New().String(),
+			Payload:       &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{Id: id, Password: "s3cret-pass", Country: "DE"}},
+		},
+		{
+			EventName:     pb.EventName_USER_PASSWORD_CHANGED,
+			CorrelationId: uuid.New().String(),
+			Payload:       &pb.Events_ChangePassword{ChangePassword: &pb.ChangePassword{Id: id, Password: "s3cret-pass"}},
+		},
+	}
+}
+
+func TestDeadLetterRepo_DoesNotStorePasswords(t *testing.T) {
+	db := conformanceDB(t)
+	deadLetters := repo.NewDeadLetterRepo(db, log.New().WithFields(log.Fields{"service": "test"}))
+
+	for _, event := range passwordEvents(uuid.New().String()) {
+		t.Run(event.EventName.String(), func(t *testing.T) {
+			require.NoError(t, deadLetters.Add(event, apperror.New(apperror.Internal, apperror.ReasonInternal, "internal error")))
+
+			var record model.DeadLetter
+			require.NoError(t, db.Where("id = ?", event.CorrelationId).First(&record).Error)
+			assert.NotContains(t, string(record.Payload), "s3cret-pass")
+
+			_, stored, err := deadLetters.Get(event.CorrelationId)
+			require.NoError(t, err)
+			assert.True(t, util.RequiresPassword(stored))
+
+			// the event of the caller is not changed
+			assert.Contains(t, event.String(), "s3cret-pass")
+		})
+	}
+}
+
+func TestDeadLetterService_RedactsAndRequiresPassword(t *testing.T) {
+	deadLetters := repo.NewDeadLetterRepo(conformanceDB(t), log.New().WithFields(log.Fields{"service": "test"}))
+	event := passwordEvents(uuid.New().String())[0]
+	event.Meta = &pb.RequestMeta{Ip: "10.0.0.1", UserAgent: "curl", RequestId: "req-1"}
+	require.NoError(t, deadLetters.Add(event, apperror.New(apperror.Internal, apperror.ReasonInternal, "internal error")))
+
+	events := &stubEventClient{response: &pb.Response{StatusCode: 200}}
+	app := newDeadLetterApp(deadLetters, events)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/dlq/"+event.CorrelationId, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	for _, private := range []string{"s3cret-pass", "alice@mail.com", "Alice", "10.0.0.1", "curl"} {
+		assert.NotContains(t, string(raw), private)
+	}
+	assert.Contains(t, string(raw), `"password_required":true`)
+	assert.Contains(t, string(raw), "req-1")
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/dlq/"+event.CorrelationId+"/retry", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Empty(t, events.sent)
+
+	req := httptest.NewRequest("POST", "/dlq/"+event.CorrelationId+"/retry", strings.NewReader(`{"password":"new-pass"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	require.Len(t, events.sent, 1)
+	assert.Equal(t, "new-pass", events.sent[0].GetCreateUser().Password)
+	assert.Equal(t, "alice@mail.com", events.sent[0].GetCreateUser().Email)
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+func newDomainService(t *testing.T) domain.UserService {
+	fastHashes(t)
+	return domain.NewUserService(domain.NewMemoryUserRepo(nil, nil, log.NewEntry(log.New())), nil, log.NewEntry(log.New()))
+}
+
+func TestDomainCreateUserHashesPassword(t *testing.T) {
+	users := newDomainService(t)
+
+	user, err := users.CreateUser(&domain.User{NickName: "alice", Email: "alice@mail.com", Password: "secret"})
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("secret")))
+
+	found, err := users.GetUser(user.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, user.Password, found.Password)
+}
+
+func TestDomainCreateUserValidates(t *testing.T) {
+	users := newDomainService(t)
+
+	_, err := users.CreateUser(&domain.User{NickName: "alice", Email: "not-an-email", Password: "secret"})
+	appErr := apperror.Wrap(err)
+	assert.Equal(t, apperror.ReasonValidationFailed, appErr.Reason)
+	require.Len(t, appErr.Violations, 1)
+	assert.Equal(t, "email", appErr.Violations[0].Field)
+}
+
+func TestDomainUpdateUser(t *testing.T) {
+	users := newDomainService(t)
+	user, err := users.CreateUser(&domain.User{NickName: "alice", Email: "alice@mail.com", Password: "secret", FirstName: "Alice"})
+	require.NoError(t, err)
+	id := user.ID.String()
+
+	updated, err := users.UpdateUser(id, &domain.UpdateUser{LastName: "Smith", Password: "new-secret"})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", updated.FirstName)
+	assert.Equal(t, "Smith", updated.LastName)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updated.Password), []byte("new-secret")))
+
+	_, err = users.UpdateUser(id, &domain.UpdateUser{UpdateMask: []string{"nickname"}})
+	assert.Equal(t, apperror.ReasonValidationFailed, reasonOf(err))
+	_, err = users.UpdateUser(id, &domain.UpdateUser{UpdateMask: []string{"role"}})
+	assert.Equal(t, apperror.ReasonInvalidArgument, reasonOf(err))
+	_, err = users.UpdateUser("nope", &domain.UpdateUser{Country: "DE"})
+	assert.Equal(t, apperror.ReasonInvalidArgument, reasonOf(err))
+}
+
+func TestDomainLifecycleRules(t *testing.T) {
+	users := newDomainService(t)
+	user, err := users.CreateUser(&domain.User{NickName: "alice", Email: "alice@mail.com", Password: "secret"})
+	require.NoError(t, err)
+	id := user.ID.String()
+
+	_, err = users.ChangePassword(id, "")
+	assert.Equal(t, apperror.ReasonValidationFailed, reasonOf(err))
+	changed, err := users.ChangePassword(id, "new-secret")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(changed.Password), []byte("new-secret")))
+
+	_, err = users.ChangeEmail(id, "not-an-email")
+	assert.Equal(t, apperror.ReasonValidationFailed, reasonOf(err))
+	_, err = users.ChangeRole(id, "owner")
+	assert.Equal(t, apperror.ReasonInvalidArgument, reasonOf(err))
+
+	_, err = users.SuspendUser(id, "spam")
+	require.NoError(t, err)
+	_, err = users.UpdateUser(id, &domain.UpdateUser{Country: "DE"})
+	assert.Equal(t, apperror.ReasonUserSuspended, reasonOf(err))
+	_, err = users.ChangePassword(id, "other-secret")
+	assert.Equal(t, apperror.ReasonUserSuspended, reasonOf(err))
+}
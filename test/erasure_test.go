@@ -0,0 +1,108 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"testing"
+)
+
+func TestErasure_ScrubsEventsAndAudit(t *testing.T) {
+	userID := "9b2a4c7e-1111-4c4c-9c9c-000000000001"
+	store := &memoryEventStore{}
+	appendUserEvents(store, userID, 2)
+	store.events[0].Meta = &pb.RequestMeta{Ip: "10.0.0.1", UserAgent: "curl", RequestId: "req-1"}
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_SUSPENDED,
+		InternalId: userID,
+		Payload:    &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: userID, Reason: "nick is spamming"}},
+	})
+
+	auditLog := &memoryAuditLog{}
+	entry, _ := audit.NewEntry(audit.ActionUserUpdated, userID, "user", audit.Meta{ActorID: "admin", IP: "10.0.0.1"}, []audit.Change{
+		{Field: "nickname", From: "nick", To: "nicka"},
+		{Field: "password", From: "hash1", To: "hash2"},
+		{Field: "role", From: "user", To: "admin"},
+	})
+	_ = auditLog.Append(entry)
+
+	scrubbed, err := store.Scrub(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), scrubbed)
+	_, err = auditLog.Scrub(userID)
+	assert.NoError(t, err)
+
+	for _, event := range store.events {
+		raw, _ := protojson.Marshal(event)
+		for _, pii := range []string{"nick@mail.com", "\"nicka\"", "UK", "TR", "10.0.0.1", "curl", "spamming"} {
+			assert.NotContains(t, string(raw), pii)
+		}
+	}
+	assert.Equal(t, "req-1", store.events[0].Meta.RequestId)
+
+	aggregates := repo.NewUserAggregateRepo(store, &memorySnapshots{}, 0, log.NewEntry(log.New()))
+	state, err := aggregates.Load(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, erasure.Nickname(userID), state.NickName)
+	assert.Equal(t, erasure.Email(userID), state.Email)
+	assert.Empty(t, state.Country)
+
+	assert.Equal(t, []audit.Change{
+		{Field: "nickname", From: erasure.Erased, To: erasure.Erased},
+		{Field: "password", From: audit.Redacted, To: audit.Redacted},
+		{Field: "role", From: "user", To: "admin"},
+	}, auditChanges(t, auditLog.entries[0]))
+	assert.Empty(t, auditLog.entries[0].IP)
+	assert.Equal(t, "admin", auditLog.entries[0].ActorID)
+}
+
+func TestAggregate_ApplyErase(t *testing.T) {
+	userID := "9b2a4c7e-1111-4c4c-9c9c-000000000002"
+	store := &memoryEventStore{}
+	appendUserEvents(store, userID, 0)
+	_, _ = store.Append(&pb.Events{EventName: pb.EventName_USER_ERASED, InternalId: userID, Payload: &pb.Events_EraseUser{EraseUser: &pb.EraseUser{Id: userID}}})
+
+	loaded, err := repo.NewUserAggregateRepo(store, &memorySnapshots{}, 0, log.NewEntry(log.New())).Load(userID)
+	assert.NoError(t, err)
+	assert.True(t, loaded.Deleted)
+	assert.Equal(t, erasure.Nickname(userID), loaded.NickName)
+}
+
+func TestUserHandler_EraseUser(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	recorder := &capturingRecorder{}
+	sender := &capturingSender{}
+	userHandler := handler.NewUserEventHandler(userService(userRepo), recorder, sender, nil, log.New())
+	id := userRepo.user.ID.String()
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName:  pb.EventName_USER_ERASED,
+		InternalId: id,
+		Actor:      "admin",
+		Meta:       &pb.RequestMeta{Ip: "10.0.0.1", UserAgent: "curl", RequestId: "req-1"},
+		Payload:    &pb.Events_EraseUser{EraseUser: &pb.EraseUser{Id: id}},
+	}))
+
+	assert.Equal(t, int32(200), sender.responses[0].StatusCode)
+	var receipt erasure.Receipt
+	assert.NoError(t, json.Unmarshal(sender.responses[0].Data, &receipt))
+	assert.Equal(t, id, receipt.UserID)
+	assert.Equal(t, "admin", receipt.ActorID)
+	assert.Equal(t, "req-1", receipt.RequestID)
+	assert.Equal(t, "grpcsrv", receipt.Source)
+
+	assert.Equal(t, erasure.Nickname(id), userRepo.user.NickName)
+	assert.Len(t, recorder.events, 1)
+	assert.Empty(t, recorder.events[0].Meta.Ip)
+	assert.Empty(t, recorder.events[0].Meta.UserAgent)
+
+	// erasing without the payload is rejected
+	assert.NoError(t, userHandler.Handle(&pb.Events{EventName: pb.EventName_USER_ERASED, InternalId: id}))
+	assert.Equal(t, int32(400), sender.responses[1].StatusCode)
+}
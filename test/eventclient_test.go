@@ -0,0 +1,262 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoEventServer answers every event with its internal id and correlation id
+type echoEventServer struct {
+	pb.UnimplementedEventGrpcServiceServer
+}
+
+func (echoEventServer) HandleEvent(stream pb.EventGrpcService_HandleEventServer) error {
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = stream.Send(&pb.Response{StatusCode: 200, Message: event.InternalId, CorrelationId: event.CorrelationId})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func startEventServer(lis *bufconn.Listener) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterEventGrpcServiceServer(s, echoEventServer{})
+	go func() { _ = s.Serve(lis) }()
+	return s
+}
+
+func dialBufconn(t *testing.T, lis func() *bufconn.Listener) *grpc.ClientConn {
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis().DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	return conn
+}
+
+func TestStreamPool_MatchesResponses(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := startEventServer(lis)
+	defer srv.Stop()
+
+	conn := dialBufconn(t, func() *bufconn.Listener { return lis })
+	defer conn.Close()
+
+	pool := eventclient.NewStreamPool(pb.NewEventGrpcServiceClient(conn), 2, log.New().WithFields(log.Fields{"service": "test"}))
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("user-%d", i)
+			resp, err := pool.Send(context.Background(), &pb.Events{InternalId: id})
+			assert.NoError(t, err)
+			if resp != nil {
+				assert.Equal(t, id, resp.Message)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStreamPool_ReconnectsAfterRestart(t *testing.T) {
+	var mu sync.Mutex
+	lis := bufconn.Listen(1024 * 1024)
+	current := func() *bufconn.Listener {
+		mu.Lock()
+		defer mu.Unlock()
+		return lis
+	}
+
+	srv := startEventServer(lis)
+	conn := dialBufconn(t, current)
+	defer conn.Close()
+
+	pool := eventclient.NewStreamPool(pb.NewEventGrpcServiceClient(conn), 1, log.New().WithFields(log.Fields{"service": "test"}))
+	defer pool.Close()
+
+	_, err := pool.Send(context.Background(), &pb.Events{InternalId: "before"})
+	assert.NoError(t, err)
+
+	srv.Stop()
+	mu.Lock()
+	lis = bufconn.Listen(1024 * 1024)
+	mu.Unlock()
+	srv = startEventServer(current())
+	defer srv.Stop()
+
+	assert.Eventually(t, func() bool {
+		resp, err := pool.Send(context.Background(), &pb.Events{InternalId: "after"})
+		return err == nil && resp.Message == "after"
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestStreamPool_Closed(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := startEventServer(lis)
+	defer srv.Stop()
+
+	conn := dialBufconn(t, func() *bufconn.Listener { return lis })
+	defer conn.Close()
+
+	pool := eventclient.NewStreamPool(pb.NewEventGrpcServiceClient(conn), 1, log.New().WithFields(log.Fields{"service": "test"}))
+	assert.NoError(t, pool.Close())
+
+	_, err := pool.Send(context.Background(), &pb.Events{InternalId: "closed"})
+	assert.ErrorIs(t, err, eventclient.ErrClosed)
+}
+
+// flakyStreams fails the first failures sends with err, then responds with status or 200
+type flakyStreams struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	status   int32
+	calls    int
+}
+
+func (f *flakyStreams) Send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	if f.status != 0 {
+		return &pb.Response{StatusCode: f.status, CorrelationId: event.CorrelationId}, nil
+	}
+	return &pb.Response{StatusCode: 200, CorrelationId: event.CorrelationId}, nil
+}
+
+func (f *flakyStreams) Close() error { return nil }
+
+func TestEventClient_RetriesIdempotent(t *testing.T) {
+	streams := &flakyStreams{failures: 2, err: status.Error(codes.Unavailable, "down")}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(5, time.Minute), 3, log.New().WithFields(log.Fields{"service": "test"}))
+
+	resp, err := client.SendIdempotent(context.Background(), &pb.Events{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(200), resp.StatusCode)
+	assert.Equal(t, 3, streams.calls)
+	assert.Equal(t, eventclient.Closed, client.State())
+}
+
+func TestEventClient_RetriedDeleteOfMissingUserSucceeds(t *testing.T) {
+	// the first attempt is applied but its response is lost
+	streams := &flakyStreams{failures: 1, err: status.Error(codes.Unavailable, "down"), status: 404}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(5, time.Minute), 3, log.New().WithFields(log.Fields{"service": "test"}))
+
+	resp, err := client.SendIdempotent(context.Background(), &pb.Events{EventName: pb.EventName_USER_DELETED})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(200), resp.StatusCode)
+	assert.Equal(t, 2, streams.calls)
+
+	// a delete which isn't retried still reports the missing user
+	streams = &flakyStreams{status: 404}
+	client = eventclient.NewEventClient(streams, eventclient.NewBreaker(5, time.Minute), 3, log.New().WithFields(log.Fields{"service": "test"}))
+
+	resp, err = client.SendIdempotent(context.Background(), &pb.Events{EventName: pb.EventName_USER_DELETED})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(404), resp.StatusCode)
+}
+
+func TestEventClient_DoesNotRetryCreate(t *testing.T) {
+	streams := &flakyStreams{failures: 1, err: status.Error(codes.Unavailable, "down")}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(5, time.Minute), 3, log.New().WithFields(log.Fields{"service": "test"}))
+
+	_, err := client.Send(context.Background(), &pb.Events{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 1, streams.calls)
+}
+
+func TestEventClient_StopsRetryingAtDeadline(t *testing.T) {
+	streams := &flakyStreams{failures: 100, err: status.Error(codes.Unavailable, "down")}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(100, time.Minute), 100, log.New().WithFields(log.Fields{"service": "test"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.SendIdempotent(ctx, &pb.Events{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestEventClient_CircuitBreaker(t *testing.T) {
+	streams := &flakyStreams{failures: 2, err: status.Error(codes.Unavailable, "down")}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(2, 100*time.Millisecond), 0, log.New().WithFields(log.Fields{"service": "test"}))
+
+	_, _ = client.Send(context.Background(), &pb.Events{})
+	_, _ = client.Send(context.Background(), &pb.Events{})
+	assert.Equal(t, eventclient.Open, client.State())
+
+	// fails fast without calling the server
+	_, err := client.Send(context.Background(), &pb.Events{})
+	assert.Equal(t, eventclient.ErrCircuitOpen, err)
+	assert.Equal(t, 2, streams.calls)
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, eventclient.HalfOpen, client.State())
+
+	_, err = client.Send(context.Background(), &pb.Events{})
+	assert.NoError(t, err)
+	assert.Equal(t, eventclient.Closed, client.State())
+}
+
+func TestEventClient_DomainErrorsKeepCircuitClosed(t *testing.T) {
+	streams := &flakyStreams{failures: 3, err: status.Error(codes.InvalidArgument, "bad")}
+	client := eventclient.NewEventClient(streams, eventclient.NewBreaker(2, time.Minute), 3, log.New().WithFields(log.Fields{"service": "test"}))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.SendIdempotent(context.Background(), &pb.Events{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	assert.Equal(t, 3, streams.calls)
+	assert.Equal(t, eventclient.Closed, client.State())
+}
+
+func TestHealthChecker_Serving(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn := dialBufconn(t, func() *bufconn.Listener { return lis })
+	defer conn.Close()
+
+	checker := eventclient.NewHealthChecker(healthpb.NewHealthClient(conn))
+
+	healthServer.SetServingStatus(pb.EventGrpcService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	err := checker.Serving(context.Background())
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	healthServer.SetServingStatus(pb.EventGrpcService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	assert.NoError(t, checker.Serving(context.Background()))
+}
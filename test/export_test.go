@@ -0,0 +1,186 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/export"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		assert.NoError(t, err)
+		files[file.Name], _ = io.ReadAll(rc)
+		_ = rc.Close()
+	}
+	return files
+}
+
+func TestArchiveBuilder_Build(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	userRepo.user.Password = "hash"
+	userID := userRepo.user.ID.String()
+
+	store := &memoryEventStore{}
+	appendUserEvents(store, userID, 2)
+	appendUserEvents(store, "other", 1)
+
+	auditLog := &memoryAuditLog{}
+	entry, _ := audit.NewEntry(audit.ActionUserUpdated, userID, "user", audit.Meta{ActorID: "admin"}, []audit.Change{{Field: "nickname", From: "a", To: "b"}})
+	_ = auditLog.Append(entry)
+
+	var archive bytes.Buffer
+	assert.NoError(t, export.NewArchiveBuilder(userRepo, store, auditLog).Build(&archive, userID))
+
+	files := readArchive(t, archive.Bytes())
+	for _, name := range []string{"manifest.json", "profile.json", "profile.csv", "events.json", "events.csv", "audit.json", "audit.csv"} {
+		assert.Contains(t, files, name)
+	}
+	assert.NotContains(t, string(files["profile.json"]), "hash")
+
+	var profile export.Profile
+	assert.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, "nick", profile.NickName)
+
+	var events []json.RawMessage
+	assert.NoError(t, json.Unmarshal(files["events.json"], &events))
+	assert.Len(t, events, 3)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["audit.csv"])).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "admin", rows[1][4])
+
+	// unknown users have no archive
+	assert.Error(t, export.NewArchiveBuilder(userRepo, store, auditLog).Build(&bytes.Buffer{}, "other"))
+}
+
+func TestExportService_ExportUser(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	userID := userRepo.user.ID.String()
+	jobs := repo.NewExportRepo(conformanceDB(t), log.NewEntry(log.New()))
+	exporter := export.NewJobExporter(export.NewArchiveBuilder(userRepo, &memoryEventStore{}, &memoryAuditLog{}), jobs, time.Hour, log.NewEntry(log.New()))
+	exportSvc := service.NewExportService(userRepo, jobs, exporter, log.NewEntry(log.New()))
+
+	app := fiber.New()
+	app.Get("/user/:id/export", exportSvc.ExportUser)
+	app.Get("/user/:id/export/:exportId", exportSvc.GetExport)
+	app.Get("/user/:id/export/:exportId/download", exportSvc.DownloadExport)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/user/"+userID+"/export", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 202, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	assert.True(t, strings.HasPrefix(location, "/user/"+userID+"/export/"))
+	exportID := strings.TrimPrefix(location, "/user/"+userID+"/export/")
+
+	assert.Eventually(t, func() bool {
+		job, _ := jobs.Get(exportID)
+		return job.Status == model.ExportDone
+	}, time.Second, 10*time.Millisecond)
+
+	// a finished export is reused within its TTL
+	resp, err = app.Test(httptest.NewRequest("GET", "/user/"+userID+"/export", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, location, resp.Header.Get("Location"))
+
+	resp, err = app.Test(httptest.NewRequest("GET", location+"/download", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, readArchive(t, body), "profile.csv")
+
+	// the archive is removed once it is downloaded
+	job, err := jobs.Get(exportID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ExportExpired, job.Status)
+	assert.Empty(t, job.Archive)
+	resp, err = app.Test(httptest.NewRequest("GET", location+"/download", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 409, resp.StatusCode)
+
+	// an expired export is not reused
+	resp, err = app.Test(httptest.NewRequest("GET", "/user/"+userID+"/export", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 202, resp.StatusCode)
+	restarted := strings.TrimPrefix(resp.Header.Get("Location"), "/user/"+userID+"/export/")
+	assert.NotEqual(t, exportID, restarted)
+	assert.Eventually(t, func() bool {
+		job, _ := jobs.Get(restarted)
+		return job != nil && job.Status == model.ExportDone
+	}, time.Second, 10*time.Millisecond)
+
+	// exports are only visible under their own user
+	resp, err = app.Test(httptest.NewRequest("GET", "/user/00000000-0000-0000-0000-000000000000/export/"+exportID, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/user/00000000-0000-0000-0000-000000000000/export", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestJobExporter_Run(t *testing.T) {
+	db := conformanceDB(t)
+	jobs := repo.NewExportRepo(db, log.NewEntry(log.New()))
+
+	pending, err := jobs.Create("1")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Model(&model.Export{}).Where("id = ?", pending.ID).Update("heartbeat_at", time.Now().Add(-time.Hour)).Error)
+	building, err := jobs.Create("4")
+	assert.NoError(t, err)
+	finished, err := jobs.Create("2")
+	assert.NoError(t, err)
+	assert.NoError(t, jobs.Complete(finished.ID, []byte("archive")))
+	assert.NoError(t, db.Model(&model.Export{}).Where("id = ?", finished.ID).Update("completed_at", time.Now().Add(-2*time.Hour)).Error)
+	recent, err := jobs.Create("3")
+	assert.NoError(t, err)
+	assert.NoError(t, jobs.Complete(recent.ID, []byte("archive")))
+
+	exporter := export.NewJobExporter(export.NewArchiveBuilder(newMemoryUserRepo(), &memoryEventStore{}, &memoryAuditLog{}), jobs, time.Hour, log.NewEntry(log.New()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exporter.Run(ctx)
+
+	// the pending export whose heartbeat stopped is failed, so that it can be started again
+	job, err := jobs.Get(pending.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ExportFailed, job.Status)
+	reusable, err := jobs.Reusable("1", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, reusable)
+
+	// the export which another instance is still building is kept
+	job, err = jobs.Get(building.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ExportPending, job.Status)
+	reusable, err = jobs.Reusable("4", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, building.ID, reusable.ID)
+
+	_, err = jobs.Get(finished.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = jobs.Get(recent.ID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/fieldcrypt"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, current string, ids ...string) string {
+	keys := map[string]string{}
+	for i, id := range ids {
+		keys[id] = base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune('a'+i)), 32)))
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"current":   current,
+		"keys":      keys,
+		"index_key": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("i", 32))),
+	})
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestFieldcrypt_EncryptAndRotate(t *testing.T) {
+	oldKeys, err := fieldcrypt.NewFileKeyProvider(writeKeyFile(t, "k1", "k1"))
+	assert.NoError(t, err)
+
+	first, err := fieldcrypt.Encrypt(oldKeys, "nick@mail.com")
+	assert.NoError(t, err)
+	second, _ := fieldcrypt.Encrypt(oldKeys, "nick@mail.com")
+	assert.True(t, fieldcrypt.IsEncrypted(first))
+	assert.True(t, strings.HasPrefix(first, "enc:v1:k1:"))
+	assert.NotContains(t, first, "nick")
+	assert.NotEqual(t, first, second)
+
+	// k2 is the current key after the rotation, k1 is kept to read the values which are not encrypted again
+	rotated, err := fieldcrypt.NewFileKeyProvider(writeKeyFile(t, "k2", "k1", "k2"))
+	assert.NoError(t, err)
+	value, err := fieldcrypt.Decrypt(rotated, first)
+	assert.NoError(t, err)
+	assert.Equal(t, "nick@mail.com", value)
+
+	encrypted, _ := fieldcrypt.Encrypt(rotated, value)
+	assert.True(t, strings.HasPrefix(encrypted, "enc:v1:k2:"))
+	_, err = fieldcrypt.Decrypt(oldKeys, encrypted)
+	assert.ErrorIs(t, err, fieldcrypt.ErrUnknownKey)
+
+	// values which are stored before the encryption are read as they are
+	value, err = fieldcrypt.Decrypt(rotated, "plain@mail.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain@mail.com", value)
+
+	_, err = fieldcrypt.NewFileKeyProvider(writeKeyFile(t, "k3", "k1"))
+	assert.ErrorIs(t, err, fieldcrypt.ErrUnknownKey)
+}
+
+func TestFieldcrypt_SerializerAndBlindIndex(t *testing.T) {
+	assert.NoError(t, fieldcrypt.Setup(writeKeyFile(t, "k1", "k1")))
+	defer fieldcrypt.Use(nil)
+
+	assert.Equal(t, fieldcrypt.BlindIndex("Nick@Mail.com "), fieldcrypt.BlindIndex("nick@mail.com"))
+	assert.NotEqual(t, fieldcrypt.BlindIndex("nick@mail.com"), fieldcrypt.BlindIndex("other@mail.com"))
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	assert.NoError(t, err)
+
+	user := &domain.User{NickName: "nick", Email: "nick@mail.com", Password: "hash", FirstName: "Nick", LastName: "Name", Country: "UK"}
+	stmt := db.Create(user).Statement
+
+	sql := stmt.SQL.String()
+	values := map[string]interface{}{}
+	columns := sql[strings.Index(sql, "(")+1 : strings.Index(sql, ")")]
+	for i, column := range strings.Split(columns, ",") {
+		value := stmt.Vars[i]
+		// serialized fields are encrypted when the driver reads them
+		if valuer, ok := value.(driver.Valuer); ok {
+			value, err = valuer.Value()
+			assert.NoError(t, err)
+		}
+		values[strings.Trim(column, `" `)] = value
+	}
+
+	for _, column := range []string{"email", "first_name", "last_name"} {
+		assert.True(t, fieldcrypt.IsEncrypted(values[column].(string)), column)
+	}
+	assert.Equal(t, "UK", values["country"])
+	assert.Equal(t, fieldcrypt.BlindIndex("nick@mail.com"), values["email_index"])
+	assert.Equal(t, "k1", values["key_id"])
+	assert.Equal(t, "nick@mail.com", user.Email)
+
+	userSchema, err := schema.Parse(&domain.User{}, &sync.Map{}, schema.NamingStrategy{})
+	assert.NoError(t, err)
+	scanned := &domain.User{}
+	assert.NoError(t, fieldcrypt.Serializer{}.Scan(context.Background(), userSchema.LookUpField("email"), reflect.ValueOf(scanned), values["email"]))
+	assert.Equal(t, "nick@mail.com", scanned.Email)
+}
+
+func TestUserService_ListsByEmailWhenEncrypted(t *testing.T) {
+	assert.NoError(t, fieldcrypt.Setup(writeKeyFile(t, "k1", "k1")))
+	defer fieldcrypt.Use(nil)
+	fastHashes(t)
+
+	for name, newRepo := range userBackends() {
+		t.Run(name, func(t *testing.T) {
+			users := domain.NewUserService(newRepo(t), nil, log.NewEntry(log.New()))
+			seedUsers(t, users, 3)
+
+			for _, pagination := range []common.Pagination{
+				{Conditions: map[string]interface{}{"email": "Nick2@mail.com"}},
+				{Conditions: map[string]interface{}{"email": "nick2@mail.com", "country": "DE"}},
+				{CQuery: "email = ?", CValue: "nick2@mail.com"},
+			} {
+				page, err := users.ListUsers(pagination)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"nick2"}, usersOf(t, page))
+				assert.Equal(t, int64(1), page.TotalRows)
+			}
+
+			for _, pagination := range []common.Pagination{
+				{SColumn: common.Email},
+				{Conditions: map[string]interface{}{"first_name": "Nick"}},
+				{CQuery: "email LIKE ?", CValue: "nick%"},
+				{CQuery: "last_name = ?", CValue: "Name"},
+			} {
+				_, err := users.ListUsers(pagination)
+				assert.Equal(t, apperror.InvalidArgument, apperror.Wrap(err).Kind)
+			}
+		})
+	}
+}
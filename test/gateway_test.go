@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/docs/grpcsrv"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/gateway"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubUserServer returns the user with the given id, other ids are not found
+type stubUserServer struct {
+	pbuser.UnimplementedUserServiceServer
+	updated *pb.UpdateUser
+}
+
+func (s *stubUserServer) GetUser(ctx context.Context, req *pbuser.GetUserRequest) (*pb.User, error) {
+	if req.Id != "1" {
+		return nil, apperror.New(apperror.NotFound, apperror.ReasonUserNotFound, "user not found").GRPCStatus().Err()
+	}
+	return &pb.User{Id: req.Id, Nickname: "nick", Country: "UK"}, nil
+}
+
+func (s *stubUserServer) UpdateUser(ctx context.Context, req *pb.UpdateUser) (*pb.User, error) {
+	s.updated = req
+	return &pb.User{Id: req.Id, Nickname: req.Nickname}, nil
+}
+
+func newGatewayServer(t *testing.T, userServer pbuser.UserServiceServer) *httptest.Server {
+	handler, err := gateway.NewGatewayHandler(context.Background(), userServer, grpcsrv.SwaggerJSON)
+	assert.NoError(t, err)
+	return httptest.NewServer(handler)
+}
+
+func TestGateway_GetUser(t *testing.T) {
+	srv := newGatewayServer(t, &stubUserServer{})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/users/1")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var user map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+	assert.Equal(t, "nick", user["nickname"])
+	assert.Equal(t, "UK", user["country"])
+}
+
+func TestGateway_NotFound(t *testing.T) {
+	srv := newGatewayServer(t, &stubUserServer{})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/users/2")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGateway_UpdateUserWithMask(t *testing.T) {
+	userServer := &stubUserServer{}
+	srv := newGatewayServer(t, userServer)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPatch, srv.URL+"/v1/users/1", strings.NewReader(`{"nickname":"new","firstName":"","updateMask":"nickname,firstName"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", userServer.updated.Id)
+	assert.Equal(t, []string{"nickname", "first_name"}, userServer.updated.UpdateMask.Paths)
+}
+
+func TestGateway_ServesSwagger(t *testing.T) {
+	srv := newGatewayServer(t, &stubUserServer{})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/swagger.json")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Contains(t, doc["paths"], "/v1/users/{id}")
+}
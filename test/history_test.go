@@ -0,0 +1,132 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/aggregate"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"time"
+)
+
+var march = time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// historyStore returns the events of a user whose nickname is changed in March and April
+func historyStore() *memoryEventStore {
+	store := &memoryEventStore{}
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_CREATED,
+		EventDate:  march.AddDate(0, -1, 0).Unix(),
+		InternalId: "1",
+		Actor:      "signup",
+		Payload:    &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "nick", Email: "nick@mail.com"}},
+	})
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_UPDATED,
+		EventDate:  march.Unix(),
+		InternalId: "1",
+		Actor:      "support",
+		Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+			Id:         "1",
+			Nickname:   "march",
+			Email:      "march@mail.com",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "email"}},
+		}},
+	})
+	// derived from the update above, it doesn't change anything
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_EMAIL_CHANGED,
+		EventDate:  march.Unix(),
+		InternalId: "1",
+		Actor:      "support",
+		Payload:    &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Id: "1", Email: "march@mail.com"}},
+	})
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_PASSWORD_CHANGED,
+		EventDate:  march.AddDate(0, 0, 1).Unix(),
+		InternalId: "1",
+		Payload:    &pb.Events_ChangePassword{ChangePassword: &pb.ChangePassword{Id: "1"}},
+	})
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_UPDATED,
+		EventDate:  march.AddDate(0, 1, 0).Unix(),
+		InternalId: "1",
+		Actor:      "player",
+		Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+			Id:         "1",
+			Nickname:   "april",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+		}},
+	})
+	return store
+}
+
+func TestUserAggregateRepo_History(t *testing.T) {
+	aggregates := repo.NewUserAggregateRepo(historyStore(), &memorySnapshots{}, 0, log.NewEntry(log.New()))
+
+	entries, err := aggregates.History("1")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	assert.Equal(t, "USER_UPDATED", entries[1].Event)
+	assert.Equal(t, "support", entries[1].Actor)
+	assert.Equal(t, march, entries[1].Date)
+	assert.Equal(t, []aggregate.FieldChange{
+		{Field: "nickname", From: "nick", To: "march"},
+		{Field: "email", From: "nick@mail.com", To: "march@mail.com"},
+	}, entries[1].Changes)
+
+	// password values are never shown
+	assert.Equal(t, "USER_PASSWORD_CHANGED", entries[2].Event)
+	assert.Equal(t, []aggregate.FieldChange{{Field: "password"}}, entries[2].Changes)
+
+	assert.Equal(t, "player", entries[3].Actor)
+
+	_, err = aggregates.History("unknown")
+	assert.Equal(t, apperror.NotFound, apperror.Wrap(err).Kind)
+}
+
+func TestUserAggregateRepo_LoadAsOf(t *testing.T) {
+	store := historyStore()
+	snapshots := &memorySnapshots{}
+	aggregates := repo.NewUserAggregateRepo(store, snapshots, 1, log.NewEntry(log.New()))
+
+	// a snapshot after April must not be used for March
+	latest, err := aggregates.Load("1")
+	assert.NoError(t, err)
+	_, err = aggregates.Snapshot(latest)
+	assert.NoError(t, err)
+
+	state, err := aggregates.LoadAsOf("1", march.AddDate(0, 0, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, "march", state.NickName)
+	assert.Equal(t, int64(4), state.Sequence)
+
+	state, err = aggregates.LoadAsOf("1", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "april", state.NickName)
+
+	_, err = aggregates.LoadAsOf("1", march.AddDate(-1, 0, 0))
+	assert.Equal(t, apperror.NotFound, apperror.Wrap(err).Kind)
+}
+
+func TestParseAsOf(t *testing.T) {
+	asOf, err := util.ParseAsOf("2026-03-01T12:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, march.Equal(asOf))
+
+	asOf, err = util.ParseAsOf("2026-03-01")
+	assert.NoError(t, err)
+	assert.True(t, march.Add(-12*time.Hour).Equal(asOf))
+
+	asOf, err = util.ParseAsOf("1772366400")
+	assert.NoError(t, err)
+	assert.True(t, march.Equal(asOf))
+
+	_, err = util.ParseAsOf("last march")
+	assert.Error(t, err)
+}
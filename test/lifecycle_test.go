@@ -0,0 +1,205 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// memoryUserRepo keeps a single user in memory
+type memoryUserRepo struct {
+	domain.UserRepository
+	user *domain.User
+}
+
+func newMemoryUserRepo() *memoryUserRepo {
+	return &memoryUserRepo{user: &domain.User{Base: &domain.Base{ID: uuid.New()}, NickName: "nick", Email: "nick@mail.com", Role: "user"}}
+}
+
+func (r *memoryUserRepo) GetUserByID(id string) (*domain.User, error) {
+	if id != r.user.ID.String() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.user, nil
+}
+
+func (r *memoryUserRepo) UpdateUser(id string, user *domain.UpdateUser) (*domain.User, error) {
+	return r.user, nil
+}
+
+func (r *memoryUserRepo) SuspendUser(id string, reason string) (*domain.User, error) {
+	if r.user.Suspended {
+		return nil, apperror.New(apperror.FailedPrecondition, apperror.ReasonUserSuspended, "user is suspended")
+	}
+	r.user.Suspended = true
+	return r.user, nil
+}
+
+func (r *memoryUserRepo) ReactivateUser(id string) (*domain.User, error) {
+	if !r.user.Suspended {
+		return nil, apperror.New(apperror.FailedPrecondition, apperror.ReasonUserNotSuspended, "user is not suspended")
+	}
+	r.user.Suspended = false
+	return r.user, nil
+}
+
+func (r *memoryUserRepo) ChangeRole(id string, role string) (*domain.User, error) {
+	r.user.Role = role
+	return r.user, nil
+}
+
+func (r *memoryUserRepo) ChangePassword(id string, hash string) (*domain.User, error) {
+	return r.user, nil
+}
+
+// userService returns the domain service over given repository
+func userService(r domain.UserRepository) domain.UserService {
+	return domain.NewUserService(r, nil, log.NewEntry(log.New()))
+}
+
+func (r *memoryUserRepo) EraseUser(id string, receipt erasure.Receipt) (*erasure.Receipt, error) {
+	if id != r.user.ID.String() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	r.user.NickName, r.user.Email = erasure.Nickname(id), erasure.Email(id)
+	receipt.UserID = id
+	return &receipt, nil
+}
+
+type capturingRecorder struct {
+	events []*pb.Events
+}
+
+func (r *capturingRecorder) Record(event *pb.Events, userID string) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestUserHandler_SuspendAndReactivate(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	recorder := &capturingRecorder{}
+	sender := &capturingSender{}
+	userHandler := handler.NewUserEventHandler(userService(userRepo), recorder, sender, nil, log.New())
+	id := userRepo.user.ID.String()
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName: pb.EventName_USER_SUSPENDED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: id, Reason: "spam"}},
+	}))
+	assert.Equal(t, int32(200), sender.responses[0].StatusCode)
+	assert.True(t, sender.responses[0].User.Suspended)
+
+	// suspended twice
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName: pb.EventName_USER_SUSPENDED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: id}},
+	}))
+	assert.Equal(t, int32(409), sender.responses[1].StatusCode)
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName:  pb.EventName_USER_REACTIVATED,
+		InternalId: id,
+		Payload:    &pb.Events_ReactivateUser{ReactivateUser: &pb.ReactivateUser{}},
+	}))
+	assert.Equal(t, int32(200), sender.responses[2].StatusCode)
+	assert.False(t, sender.responses[2].User.Suspended)
+
+	assert.Len(t, recorder.events, 2)
+	assert.Equal(t, pb.EventName_USER_SUSPENDED, recorder.events[0].EventName)
+	assert.Equal(t, pb.EventName_USER_REACTIVATED, recorder.events[1].EventName)
+}
+
+func TestUserHandler_LifecyclePayloadMustMatch(t *testing.T) {
+	userRepo := newMemoryUserRepo()
+	sender := &capturingSender{}
+	userHandler := handler.NewUserEventHandler(userService(userRepo), &capturingRecorder{}, sender, nil, log.New())
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName: pb.EventName_USER_ROLE_CHANGED,
+		Payload:   &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Id: userRepo.user.ID.String()}},
+	}))
+	assert.Equal(t, int32(400), sender.responses[0].StatusCode)
+
+	assert.NoError(t, userHandler.Handle(&pb.Events{
+		EventName: pb.EventName_USER_ROLE_CHANGED,
+		Payload:   &pb.Events_ChangeRole{ChangeRole: &pb.ChangeRole{Id: userRepo.user.ID.String(), Role: pb.Role_ROLE_ADMIN}},
+	}))
+	assert.Equal(t, int32(200), sender.responses[1].StatusCode)
+	assert.Equal(t, pb.Role_ROLE_ADMIN, sender.responses[1].User.Role)
+	assert.Equal(t, "admin", userRepo.user.Role)
+}
+
+func TestUserHandler_UpdateDerivesSpecificEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload *pb.UpdateUser
+		want    []pb.EventName
+	}{
+		{
+			name:    "masked email and password",
+			payload: &pb.UpdateUser{Email: "new@mail.com", Password: "secret", Country: "UK", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email", "password", "country"}}},
+			want:    []pb.EventName{pb.EventName_USER_UPDATED, pb.EventName_USER_EMAIL_CHANGED, pb.EventName_USER_PASSWORD_CHANGED},
+		},
+		{
+			name:    "unmasked email",
+			payload: &pb.UpdateUser{Email: "new@mail.com"},
+			want:    []pb.EventName{pb.EventName_USER_UPDATED, pb.EventName_USER_EMAIL_CHANGED},
+		},
+		{
+			name:    "unmasked password",
+			payload: &pb.UpdateUser{Password: "secret"},
+			want:    []pb.EventName{pb.EventName_USER_UPDATED, pb.EventName_USER_PASSWORD_CHANGED},
+		},
+		{
+			name:    "same email",
+			payload: &pb.UpdateUser{Email: "alice@mail.com", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}}},
+			want:    []pb.EventName{pb.EventName_USER_UPDATED},
+		},
+		{
+			name:    "other fields",
+			payload: &pb.UpdateUser{Country: "DE", FirstName: "Alice"},
+			want:    []pb.EventName{pb.EventName_USER_UPDATED},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newDomainService(t)
+			user, err := users.CreateUser(&domain.User{NickName: "alice", Email: "alice@mail.com", Password: "old-secret"})
+			require.NoError(t, err)
+			id := user.ID.String()
+
+			recorder := &capturingRecorder{}
+			userHandler := handler.NewUserEventHandler(users, recorder, &capturingSender{}, nil, log.New())
+			tt.payload.Id = id
+			assert.NoError(t, userHandler.Handle(&pb.Events{
+				EventName: pb.EventName_USER_UPDATED,
+				Actor:     "admin",
+				Payload:   &pb.Events_UpdateUser{UpdateUser: tt.payload},
+			}))
+
+			var names []pb.EventName
+			for i, event := range recorder.events {
+				names = append(names, event.EventName)
+				assert.Equal(t, "admin", event.Actor)
+				if i > 0 {
+					assert.Equal(t, id, event.InternalId)
+				}
+				assert.Empty(t, event.GetChangePassword().GetPassword())
+				if event.EventName == pb.EventName_USER_EMAIL_CHANGED {
+					assert.Equal(t, "new@mail.com", event.GetChangeEmail().Email)
+				}
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
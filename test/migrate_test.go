@@ -0,0 +1,111 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/migrations"
+	"github.com/cemayan/faceit-technical-test/pkg/migrate"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMigrate_LoadOrdersByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_add_country.up.sql":    {Data: []byte("ALTER TABLE users ADD country text;")},
+		"2_add_country.down.sql":  {Data: []byte("ALTER TABLE users DROP country;")},
+		"1_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id uuid);")},
+		"1_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"README.md":               {Data: []byte("not a migration")},
+	}
+
+	loaded, err := migrate.Load(fsys)
+	assert.NoError(t, err)
+	assert.Len(t, loaded, 2)
+	assert.Equal(t, int64(1), loaded[0].Version)
+	assert.Equal(t, "create_users", loaded[0].Name)
+	assert.Equal(t, "DROP TABLE users;", loaded[0].Down)
+	assert.Equal(t, "add_country", loaded[1].Name)
+}
+
+func TestMigrate_LoadRejectsInvalidMigrations(t *testing.T) {
+	_, err := migrate.Load(fstest.MapFS{
+		"1_create_users.up.sql": {Data: []byte("CREATE TABLE users (id uuid);")},
+	})
+	assert.Error(t, err, "a migration without down file")
+
+	_, err = migrate.Load(fstest.MapFS{
+		"1_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id uuid);")},
+		"1_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"1_create_events.up.sql":  {Data: []byte("CREATE TABLE events (id uuid);")},
+	})
+	assert.Error(t, err, "two migrations with the same version")
+}
+
+func TestMigrate_EmbeddedMigrationsAreValid(t *testing.T) {
+	loaded, err := migrate.Load(migrations.FS)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, loaded)
+	assert.Equal(t, "baseline", loaded[0].Name)
+}
+
+func TestMigrate_BaselineAddsColumnsToExistingTables(t *testing.T) {
+	loaded, err := migrate.Load(migrations.FS)
+	assert.NoError(t, err)
+	baseline := loaded[0].Up
+
+	// the columns of the users table before the migrations
+	original := map[string]bool{"created_at": true, "updated_at": true, "deleted": true, "nick_name": true, "email": true, "password": true, "first_name": true, "last_name": true, "country": true}
+
+	tables := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`).FindAllStringSubmatch(baseline, -1)
+	assert.NotEmpty(t, tables)
+	for _, table := range tables {
+		name := table[1]
+		alter := regexp.MustCompile(`(?s)ALTER TABLE ` + name + `\n(.*?);`).FindStringSubmatch(baseline)
+		if !assert.NotNil(t, alter, name) {
+			continue
+		}
+
+		for _, line := range strings.Split(table[2], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.Contains(line, "PRIMARY KEY") || (name == "users" && original[fields[0]]) {
+				continue
+			}
+			assert.Contains(t, alter[1], "ADD COLUMN IF NOT EXISTS "+fields[0]+" ", name)
+		}
+	}
+}
+
+func TestMigrate_BaselineCantBeReverted(t *testing.T) {
+	loaded, err := migrate.Load(migrations.FS)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, loaded[0].Down, "DROP TABLE", "the tables which existed before the migrations are kept")
+	assert.Contains(t, loaded[0].Down, "RAISE EXCEPTION")
+}
+
+func TestMigrate_Create(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+
+	paths, err := migrate.Create(dir, "Add Login Count", now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "20261019123000_add_login_count.up.sql"),
+		filepath.Join(dir, "20261019123000_add_login_count.down.sql"),
+	}, paths)
+
+	loaded, err := migrate.Load(os.DirFS(dir))
+	assert.NoError(t, err)
+	assert.Len(t, loaded, 1)
+	assert.Equal(t, int64(20261019123000), loaded[0].Version)
+
+	_, err = migrate.Create(dir, "Add Login Count", now)
+	assert.Error(t, err, "existing files are not overwritten")
+
+	_, err = migrate.Create(dir, "drop-users;", now)
+	assert.Error(t, err)
+}
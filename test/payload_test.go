@@ -0,0 +1,138 @@
+package test
+
+import (
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+)
+
+func TestUserHandler_AppliesUpdatePayloads(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  func(id string) *pb.Events
+		status int32
+		want   domain.User
+		mask   []string
+	}{
+		{
+			name: "masked payload changes only the listed fields even if they are empty",
+			event: func(id string) *pb.Events {
+				return &pb.Events{Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+					Id:         id,
+					LastName:   "Smith",
+					Country:    "DE",
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name", "country"}},
+				}}}
+			},
+			status: 200,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", Country: "DE"},
+			mask:   []string{"first_name", "country"},
+		},
+		{
+			name: "unmasked payload changes the non-empty fields",
+			event: func(id string) *pb.Events {
+				return &pb.Events{Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{Id: id, LastName: "Smith"}}}
+			},
+			status: 200,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", FirstName: "Alice", LastName: "Smith", Country: "UK"},
+		},
+		{
+			name: "legacy event_data is upgraded to a masked payload",
+			event: func(id string) *pb.Events {
+				return &pb.Events{InternalId: id, EventData: []byte(`{"last_name":"Smith","country":""}`)}
+			},
+			status: 200,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", FirstName: "Alice", LastName: "Smith", Country: "UK"},
+			mask:   []string{"last_name"},
+		},
+		{
+			name: "masked payload can't clear the email",
+			event: func(id string) *pb.Events {
+				return &pb.Events{Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+					Id:         id,
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+				}}}
+			},
+			status: 422,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", FirstName: "Alice", Country: "UK"},
+		},
+		{
+			name: "unknown mask path is rejected",
+			event: func(id string) *pb.Events {
+				return &pb.Events{Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+					Id:         id,
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"role"}},
+				}}}
+			},
+			status: 400,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", FirstName: "Alice", Country: "UK"},
+		},
+		{
+			name: "malformed legacy event_data is rejected",
+			event: func(id string) *pb.Events {
+				return &pb.Events{InternalId: id, EventData: []byte(`{`)}
+			},
+			status: 400,
+			want:   domain.User{NickName: "alice", Email: "alice@mail.com", FirstName: "Alice", Country: "UK"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newDomainService(t)
+			user, err := users.CreateUser(&domain.User{NickName: "alice", Email: "alice@mail.com", Password: "secret", FirstName: "Alice", Country: "UK"})
+			require.NoError(t, err)
+			id := user.ID.String()
+
+			recorder := &capturingRecorder{}
+			sender := &capturingSender{}
+			userHandler := handler.NewUserEventHandler(users, recorder, sender, nil, log.New())
+
+			event := tt.event(id)
+			event.EventName = pb.EventName_USER_UPDATED
+			require.NoError(t, userHandler.Handle(event))
+			require.Len(t, sender.responses, 1)
+			assert.Equal(t, tt.status, sender.responses[0].StatusCode, sender.responses[0].Message)
+
+			found, err := users.GetUser(id)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, domain.User{
+				NickName:  found.NickName,
+				Email:     found.Email,
+				FirstName: found.FirstName,
+				LastName:  found.LastName,
+				Country:   found.Country,
+			})
+
+			if tt.status != 200 {
+				assert.Empty(t, recorder.events)
+				return
+			}
+			require.NotEmpty(t, recorder.events)
+			recorded := recorder.events[0]
+			assert.Empty(t, recorded.EventData)
+			assert.Equal(t, schema.CurrentVersion, schema.VersionOf(recorded))
+			assert.Equal(t, tt.mask, recorded.GetUpdateUser().GetUpdateMask().GetPaths())
+		})
+	}
+}
+
+func TestUserHandler_RejectsUnhandledEvents(t *testing.T) {
+	for _, name := range []pb.EventName{pb.EventName_USER_PASSWORD_CHANGED, pb.EventName(99)} {
+		recorder := &capturingRecorder{}
+		sender := &capturingSender{}
+		userHandler := handler.NewUserEventHandler(newDomainService(t), recorder, sender, nil, log.New())
+
+		require.NoError(t, userHandler.Handle(&pb.Events{EventName: name, CorrelationId: "c-1"}))
+		require.Len(t, sender.responses, 1, name.String())
+		assert.Equal(t, int32(400), sender.responses[0].StatusCode)
+		assert.Equal(t, "c-1", sender.responses[0].CorrelationId)
+		assert.Empty(t, recorder.events)
+	}
+}
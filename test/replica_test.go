@@ -0,0 +1,170 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/postgres"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replicaSetup is a primary and a replica which never receives the writes of the primary
+type replicaSetup struct {
+	primary *gorm.DB
+	router  postgres.ReadRouter
+	users   domain.UserRepository
+	lag     time.Duration
+	err     error
+}
+
+func newReplicaSetup(t *testing.T) *replicaSetup {
+	logger := log.New().WithFields(log.Fields{"service": "replica"})
+	primary := conformanceDB(t)
+	setup := &replicaSetup{primary: primary}
+
+	probe := func(db *gorm.DB) (time.Duration, error) {
+		return setup.lag, setup.err
+	}
+	configs := &common.Postgresql{STICKY_WINDOW: 50 * time.Millisecond, MAX_REPLICA_LAG: time.Second}
+	setup.router = postgres.NewReplicaRouter(primary, []postgres.Pool{{Name: "replica_test", DB: conformanceDB(t)}}, probe, configs, logger)
+	setup.users = domain.NewReplicatedUserRepo(primary, setup.router, nil, logger)
+	return setup
+}
+
+// readsReplica reports whether the user is read from the replica, the replica doesn't have it
+func (s *replicaSetup) readsReplica(t *testing.T, id string) bool {
+	_, err := s.users.GetUserByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true
+	}
+	require.NoError(t, err)
+	return false
+}
+
+func TestReplicaRouterReadsYourWrites(t *testing.T) {
+	s := newReplicaSetup(t)
+	user := createUser(t, s.users, "alice", "UK")
+	id := user.ID.String()
+
+	// Replicas are skipped until their lag is read
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, s.readsReplica(t, id))
+
+	s.router.CheckLag()
+	assert.True(t, s.readsReplica(t, id))
+
+	page, err := s.users.GetAllUser(common.Pagination{})
+	require.NoError(t, err)
+	assert.Empty(t, usersOf(t, page))
+
+	// Writes read the user from the primary and keep its reads there for the sticky window
+	_, err = s.users.UpdateUser(id, &domain.UpdateUser{Country: "DE"})
+	require.NoError(t, err)
+	assert.False(t, s.readsReplica(t, id))
+
+	page, err = s.users.GetAllUser(common.Pagination{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, usersOf(t, page))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, s.readsReplica(t, id))
+
+	// Users which are changed by another process are invalidated
+	s.users.(domain.UserInvalidator).Invalidate(id)
+	assert.False(t, s.readsReplica(t, id))
+}
+
+// creatingEventClient creates the users of the events in db as gRPC event server does
+type creatingEventClient struct {
+	stubEventClient
+	users domain.UserRepository
+}
+
+func (c *creatingEventClient) Send(ctx context.Context, event *pb.Events) (*pb.Response, error) {
+	payload := event.GetCreateUser()
+	user, err := c.users.CreateUser(&domain.User{NickName: payload.Nickname, Email: payload.Email, Password: "hash", Country: payload.Country})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Response{StatusCode: 201, User: util.ToProtoUser(user)}, nil
+}
+
+func TestReplicaRouterReadsUsersCreatedByEventServer(t *testing.T) {
+	s := newReplicaSetup(t)
+	s.router.CheckLag()
+
+	events := &creatingEventClient{users: domain.NewUserRepo(s.primary, nil, log.NewEntry(log.New()))}
+	users := domain.NewUserService(s.users, validator.New(), log.NewEntry(log.New()))
+	userSvc := service.NewGrpcUserService(users, s.users.(domain.UserInvalidator), nil, validator.New(), events, nil, log.NewEntry(log.New()), &user.AppConfig{})
+
+	app := fiber.New()
+	app.Post("/", userSvc.CreateUser)
+	app.Get("/:id", userSvc.GetUser)
+
+	body := `{"nickname":"alice","email":"alice@mail.com","password":"secret","country":"UK"}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+
+	var created struct {
+		Data domain.UserData `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	// the replica doesn't have the user yet, it is read from the primary
+	resp, err = app.Test(httptest.NewRequest("GET", "/"+created.Data.ID.String(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, s.readsReplica(t, created.Data.ID.String()))
+}
+
+func TestReplicaRouterFallsBackToPrimary(t *testing.T) {
+	s := newReplicaSetup(t)
+	user := createUser(t, s.users, "alice", "UK")
+	id := user.ID.String()
+	time.Sleep(60 * time.Millisecond)
+
+	s.router.CheckLag()
+	assert.True(t, s.readsReplica(t, id))
+
+	s.lag = 2 * time.Second
+	s.router.CheckLag()
+	assert.False(t, s.readsReplica(t, id))
+
+	s.lag = 0
+	s.err = errors.New("connection refused")
+	s.router.CheckLag()
+	assert.False(t, s.readsReplica(t, id))
+
+	s.err = nil
+	s.router.CheckLag()
+	assert.True(t, s.readsReplica(t, id))
+}
+
+func TestReplicaRouterWithoutReplicas(t *testing.T) {
+	primary := conformanceDB(t)
+	router := postgres.NewReplicaRouter(primary, nil, nil, &common.Postgresql{}, log.New().WithFields(log.Fields{"service": "replica"}))
+
+	router.Written("any")
+	router.CheckLag()
+	assert.Same(t, primary, router.Reader("any"))
+}
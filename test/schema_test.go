@@ -0,0 +1,87 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/schema"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type eventFixture struct {
+	Description string          `json:"description"`
+	Version     int32           `json:"version"`
+	Event       json.RawMessage `json:"event"`
+	Expected    json.RawMessage `json:"expected"`
+}
+
+// TestUpcast_Fixtures replays the stored form of every fixture and compares it with the current version
+func TestUpcast_Fixtures(t *testing.T) {
+	files, err := filepath.Glob("fixtures/events/*.json")
+	assert.NoError(t, err)
+
+	versions := map[int32]bool{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+
+		var fixture eventFixture
+		assert.NoError(t, json.Unmarshal(content, &fixture))
+		versions[fixture.Version] = true
+
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			var event, expected pb.Events
+			assert.NoError(t, protojson.Unmarshal(fixture.Event, &event))
+			assert.NoError(t, protojson.Unmarshal(fixture.Expected, &expected))
+			assert.Equal(t, fixture.Version, schema.VersionOf(&event))
+
+			// events are replayed from their binary form in the event store
+			stored, err := proto.Marshal(&event)
+			assert.NoError(t, err)
+			var replayed pb.Events
+			assert.NoError(t, proto.Unmarshal(stored, &replayed))
+
+			assert.NoError(t, schema.Default.Upcast(&replayed))
+			assert.True(t, proto.Equal(&expected, &replayed), "%s\nexpected %v\ngot      %v", fixture.Description, &expected, &replayed)
+		})
+	}
+
+	for version := int32(1); version <= schema.CurrentVersion; version++ {
+		assert.True(t, versions[version], "no fixture for schema version %d", version)
+	}
+}
+
+func TestUpcast_RejectsNewerVersion(t *testing.T) {
+	event := &pb.Events{EventName: pb.EventName_USER_CREATED, SchemaVersion: schema.CurrentVersion + 1}
+	assert.Error(t, schema.Default.Upcast(event))
+}
+
+func TestUpcast_MalformedLegacyPayload(t *testing.T) {
+	event := &pb.Events{EventName: pb.EventName_USER_CREATED, EventData: []byte("{")}
+	assert.Error(t, schema.Default.Upcast(event))
+}
+
+func TestUpcast_AppliesUpcastersInOrder(t *testing.T) {
+	registry := schema.NewRegistry()
+	registry.Register(pb.EventName_USER_SUSPENDED, 1, func(event *pb.Events) error {
+		event.GetSuspendUser().Reason = "upcast: " + event.GetSuspendUser().Reason
+		return nil
+	})
+
+	event := &pb.Events{
+		EventName:     pb.EventName_USER_SUSPENDED,
+		SchemaVersion: 1,
+		Payload:       &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Reason: "spam"}},
+	}
+	assert.NoError(t, registry.Upcast(event))
+	assert.Equal(t, "upcast: spam", event.GetSuspendUser().Reason)
+	assert.Equal(t, schema.CurrentVersion, event.SchemaVersion)
+
+	// events of the current version are not changed
+	assert.NoError(t, registry.Upcast(event))
+	assert.Equal(t, "upcast: spam", event.GetSuspendUser().Reason)
+}
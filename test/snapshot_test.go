@@ -0,0 +1,241 @@
+package test
+
+import (
+	"errors"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/aggregate"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/util"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"sync"
+	"testing"
+)
+
+// memoryEventStore keeps the events in memory and counts the events which are read
+type memoryEventStore struct {
+	mu     sync.Mutex
+	events []*pb.Events
+	reads  int
+}
+
+func (s *memoryEventStore) Append(event *pb.Events) (*pb.Events, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.Sequence = int64(len(s.events) + 1)
+	s.events = append(s.events, event)
+	return event, nil
+}
+
+func (s *memoryEventStore) GetEvents(filter repo.EventFilter, limit int) ([]*pb.Events, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*pb.Events
+	for _, event := range s.events {
+		if event.Sequence <= filter.FromSequence || (filter.UserID != "" && event.InternalId != filter.UserID) {
+			continue
+		}
+		if len(filter.EventNames) > 0 && !containsEventName(filter.EventNames, event.EventName) {
+			continue
+		}
+		if len(events) == limit {
+			break
+		}
+		events = append(events, event)
+	}
+	s.reads += len(events)
+	return events, nil
+}
+
+func containsEventName(names []pb.EventName, name pb.EventName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryEventStore) GetUserIDs() ([]string, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memoryEventStore) LastSequence() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.events)), nil
+}
+
+func (s *memoryEventStore) Scrub(userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scrubbed int64
+	for _, event := range s.events {
+		if event.InternalId == userID {
+			util.ScrubPayload(event, userID)
+			scrubbed++
+		}
+	}
+	return scrubbed, nil
+}
+
+type memorySnapshots struct {
+	snapshots []*aggregate.User
+}
+
+func (s *memorySnapshots) Save(state *aggregate.User) error {
+	s.snapshots = append(s.snapshots, state.Clone())
+	return nil
+}
+
+func (s *memorySnapshots) Latest(userID string) (*aggregate.User, error) {
+	var latest *aggregate.User
+	for _, snapshot := range s.snapshots {
+		if snapshot.ID == userID && (latest == nil || snapshot.Sequence > latest.Sequence) {
+			latest = snapshot
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return latest.Clone(), nil
+}
+
+func (s *memorySnapshots) LatestAsOf(userID string, eventDate int64) (*aggregate.User, error) {
+	var latest *aggregate.User
+	for _, snapshot := range s.snapshots {
+		if snapshot.ID == userID && snapshot.EventDate <= eventDate && (latest == nil || snapshot.Sequence > latest.Sequence) {
+			latest = snapshot
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return latest.Clone(), nil
+}
+
+func (s *memorySnapshots) Delete(userID string) (int64, error) {
+	var kept []*aggregate.User
+	for _, snapshot := range s.snapshots {
+		if userID != "" && snapshot.ID != userID {
+			kept = append(kept, snapshot)
+		}
+	}
+	removed := int64(len(s.snapshots) - len(kept))
+	s.snapshots = kept
+	return removed, nil
+}
+
+func (s *memorySnapshots) Compact() (int64, error) {
+	return 0, nil
+}
+
+func appendUserEvents(store *memoryEventStore, userID string, updates int) {
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_CREATED,
+		InternalId: userID,
+		Payload:    &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "nick", Email: "nick@mail.com", Country: "UK"}},
+	})
+	for i := 0; i < updates; i++ {
+		_, _ = store.Append(&pb.Events{
+			EventName:  pb.EventName_USER_UPDATED,
+			InternalId: userID,
+			Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+				Id:         userID,
+				Nickname:   "nick" + string(rune('a'+i%26)),
+				Country:    "TR",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+			}},
+		})
+	}
+}
+
+func TestUserAggregate_Apply(t *testing.T) {
+	state := aggregate.NewUser("1")
+	events := []*pb.Events{
+		{Sequence: 1, Payload: &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "nick", Email: "nick@mail.com", Country: "UK"}}},
+		{Sequence: 2, Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{Nickname: "new", Country: "TR", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}}}}},
+		{Sequence: 3, Payload: &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Email: "new@mail.com"}}},
+		{Sequence: 4, Payload: &pb.Events_SuspendUser{SuspendUser: &pb.SuspendUser{Reason: "spam"}}},
+		{Sequence: 5, Payload: &pb.Events_ChangeRole{ChangeRole: &pb.ChangeRole{Role: pb.Role_ROLE_ADMIN}}},
+		{Sequence: 6, Payload: &pb.Events_DeleteUser{DeleteUser: &pb.DeleteUser{}}},
+	}
+	for _, event := range events {
+		state.Apply(event)
+	}
+	// already applied
+	state.Apply(events[1])
+
+	assert.Equal(t, "new", state.NickName)
+	assert.Equal(t, "UK", state.Country)
+	assert.Equal(t, "new@mail.com", state.Email)
+	assert.True(t, state.Suspended)
+	assert.Equal(t, "spam", state.SuspendReason)
+	assert.Equal(t, "admin", state.Role)
+	assert.True(t, state.Deleted)
+	assert.Equal(t, int64(6), state.Sequence)
+	assert.Equal(t, 6, state.Version)
+
+	state.Apply(&pb.Events{Sequence: 7, Payload: &pb.Events_ReactivateUser{ReactivateUser: &pb.ReactivateUser{}}})
+	state.Apply(&pb.Events{Sequence: 8, Payload: &pb.Events_RestoreUser{RestoreUser: &pb.RestoreUser{}}})
+	assert.False(t, state.Suspended)
+	assert.Empty(t, state.SuspendReason)
+	assert.False(t, state.Deleted)
+}
+
+func TestUserAggregateRepo_SnapshotAndLoadTail(t *testing.T) {
+	store := &memoryEventStore{}
+	snapshots := &memorySnapshots{}
+	aggregates := repo.NewUserAggregateRepo(store, snapshots, 10, log.NewEntry(log.New()))
+
+	appendUserEvents(store, "1", 4)
+	state, err := aggregates.Load("1")
+	assert.NoError(t, err)
+	saved, err := aggregates.Snapshot(state)
+	assert.NoError(t, err)
+	assert.False(t, saved)
+
+	appendUserEvents(store, "2", 3)
+	appendUserEvents(store, "1", 20)
+	state, err = aggregates.Load("1")
+	assert.NoError(t, err)
+	saved, err = aggregates.Snapshot(state)
+	assert.NoError(t, err)
+	assert.True(t, saved)
+	assert.Len(t, snapshots.snapshots, 1)
+	assert.Equal(t, 26, snapshots.snapshots[0].Version)
+
+	_, _ = store.Append(&pb.Events{
+		EventName:  pb.EventName_USER_EMAIL_CHANGED,
+		InternalId: "1",
+		Payload:    &pb.Events_ChangeEmail{ChangeEmail: &pb.ChangeEmail{Id: "1", Email: "new@mail.com"}},
+	})
+
+	// only the tail after the snapshot is read
+	store.reads = 0
+	state, err = aggregates.Load("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.reads)
+	assert.Equal(t, "new@mail.com", state.Email)
+	assert.Equal(t, 27, state.Version)
+	assert.Equal(t, store.events[len(store.events)-1].Sequence, state.Sequence)
+
+	// a rebuild from all events gives the same state
+	rebuilt, err := aggregates.Rebuild("1")
+	assert.NoError(t, err)
+	assert.Equal(t, state, rebuilt)
+	assert.Len(t, snapshots.snapshots, 1)
+}
+
+func TestUserAggregateRepo_LoadUnknownUser(t *testing.T) {
+	aggregates := repo.NewUserAggregateRepo(&memoryEventStore{}, &memorySnapshots{}, 0, log.NewEntry(log.New()))
+
+	_, err := aggregates.Load("unknown")
+	assert.Equal(t, apperror.NotFound, apperror.Wrap(err).Kind)
+}
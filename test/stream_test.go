@@ -0,0 +1,141 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// streamEventServer serves HandleEvent with the stream handler
+type streamEventServer struct {
+	pb.UnimplementedEventGrpcServiceServer
+	streams handler.EventStreamHandler
+}
+
+func (s streamEventServer) HandleEvent(stream pb.EventGrpcService_HandleEventServer) error {
+	return s.streams.Serve(stream)
+}
+
+// orderedRecorder keeps the recorded events of every user in the order they are recorded
+type orderedRecorder struct {
+	mu     sync.Mutex
+	events map[string][]*pb.Events
+}
+
+func (r *orderedRecorder) Record(event *pb.Events, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[userID] = append(r.events[userID], event)
+	return nil
+}
+
+// TestStreamHandler_KeepsAggregateOrder sends interleaved updates of several users over one stream
+// Run it with -race, the workers share the user handler and the stream
+func TestStreamHandler_KeepsAggregateOrder(t *testing.T) {
+	const userCount, updates = 8, 25
+
+	users := newDomainService(t)
+	var ids []string
+	for i := 0; i < userCount; i++ {
+		user, err := users.CreateUser(&domain.User{NickName: fmt.Sprintf("nick%d", i), Email: fmt.Sprintf("nick%d@mail.com", i), Password: "secret"})
+		require.NoError(t, err)
+		ids = append(ids, user.ID.String())
+	}
+
+	recorder := &orderedRecorder{events: map[string][]*pb.Events{}}
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterEventGrpcServiceServer(s, streamEventServer{streams: handler.NewEventStreamHandler(users, recorder, nil, 4, log.New())})
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	conn := dialBufconn(t, func() *bufconn.Listener { return lis })
+	defer conn.Close()
+
+	stream, err := pb.NewEventGrpcServiceClient(conn).HandleEvent(context.Background())
+	require.NoError(t, err)
+
+	// correlation id of every event is mapped to its user and its position
+	type sent struct {
+		userID string
+		step   int
+	}
+	correlations := map[string]sent{}
+	var events []*pb.Events
+	for step := 0; step < updates; step++ {
+		for _, id := range ids {
+			event := &pb.Events{
+				AggregateType: pb.AggregateType_USER,
+				AggregateId:   uuid.New().String(),
+				CorrelationId: uuid.New().String(),
+				EventName:     pb.EventName_USER_UPDATED,
+				Payload: &pb.Events_UpdateUser{UpdateUser: &pb.UpdateUser{
+					Id:         id,
+					LastName:   strconv.Itoa(step),
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"last_name"}},
+				}},
+			}
+			correlations[event.CorrelationId] = sent{userID: id, step: step}
+			events = append(events, event)
+		}
+	}
+
+	go func() {
+		for _, event := range events {
+			if err := stream.Send(event); err != nil {
+				return
+			}
+		}
+		_ = stream.CloseSend()
+	}()
+
+	var responses []*pb.Response
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		responses = append(responses, response)
+	}
+
+	// responses of a user come back in the order its events are sent
+	require.Len(t, responses, userCount*updates)
+	next := map[string]int{}
+	seen := map[string]bool{}
+	for _, response := range responses {
+		assert.Equal(t, int32(200), response.StatusCode, response.Message)
+		assert.False(t, seen[response.CorrelationId], "correlation id %s is echoed twice", response.CorrelationId)
+		seen[response.CorrelationId] = true
+
+		event, ok := correlations[response.CorrelationId]
+		require.True(t, ok, "unknown correlation id %s", response.CorrelationId)
+		assert.Equal(t, next[event.userID], event.step, "response of user %s is out of order", event.userID)
+		next[event.userID] = event.step + 1
+	}
+
+	for _, id := range ids {
+		recorded := recorder.events[id]
+		require.Len(t, recorded, updates)
+		for step, event := range recorded {
+			assert.Equal(t, strconv.Itoa(step), event.GetUpdateUser().LastName, "event of user %s is recorded out of order", id)
+		}
+
+		user, err := users.GetUser(id)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(updates-1), user.LastName)
+	}
+}
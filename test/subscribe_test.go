@@ -0,0 +1,199 @@
+package test
+
+import (
+	"context"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+// subscriptionStream collects the streamed events
+type subscriptionStream struct {
+	pb.EventGrpcService_SubscribeUserEventsServer
+	ctx  context.Context
+	sent chan *pb.Events
+}
+
+func (s *subscriptionStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *subscriptionStream) Send(event *pb.Events) error {
+	s.sent <- event
+	return nil
+}
+
+// subscription runs Subscribe with given request until the test ends
+type subscription struct {
+	store  *memoryEventStore
+	broker broker.EventBroker
+	stream *subscriptionStream
+	done   chan error
+}
+
+func subscribe(t *testing.T, store *memoryEventStore, req *pb.SubscribeRequest) *subscription {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &subscription{
+		store:  store,
+		broker: broker.NewEventBroker(),
+		stream: &subscriptionStream{ctx: ctx, sent: make(chan *pb.Events, 100)},
+		done:   make(chan error, 1),
+	}
+
+	subscriber := handler.NewSubscriptionHandler(store, s.broker, log.New())
+	go func() {
+		s.done <- subscriber.Subscribe(req, s.stream)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-s.done)
+	})
+	return s
+}
+
+// publish appends the event and publishes it like the recorder does
+func (s *subscription) publish(userID string, name pb.EventName) {
+	stored, _ := s.store.Append(&pb.Events{EventName: name, InternalId: userID})
+	s.broker.Publish(stored)
+}
+
+// receive returns the sequences of the next n streamed events
+func (s *subscription) receive(t *testing.T, n int) []int64 {
+	var sequences []int64
+	for len(sequences) < n {
+		select {
+		case event := <-s.stream.sent:
+			sequences = append(sequences, event.Sequence)
+		case <-time.After(time.Second):
+			require.Failf(t, "events are not streamed", "got %v, want %d events", sequences, n)
+		}
+	}
+	return sequences
+}
+
+func (s *subscription) assertNoMore(t *testing.T) {
+	select {
+	case event := <-s.stream.sent:
+		assert.Failf(t, "unexpected event", "sequence %d", event.Sequence)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func storeWithEvents(names ...pb.EventName) *memoryEventStore {
+	store := &memoryEventStore{}
+	for i, name := range names {
+		userID := "user-a"
+		if i%2 == 1 {
+			userID = "user-b"
+		}
+		_, _ = store.Append(&pb.Events{EventName: name, InternalId: userID})
+	}
+	return store
+}
+
+func TestSubscribeReplaysStoredEventsThenLiveEvents(t *testing.T) {
+	store := storeWithEvents(pb.EventName_USER_CREATED, pb.EventName_USER_CREATED, pb.EventName_USER_UPDATED)
+	s := subscribe(t, store, &pb.SubscribeRequest{})
+
+	assert.Equal(t, []int64{1, 2, 3}, s.receive(t, 3))
+
+	s.publish("user-a", pb.EventName_USER_DELETED)
+	assert.Equal(t, []int64{4}, s.receive(t, 1))
+	s.assertNoMore(t)
+}
+
+func TestSubscribeResumesFromSequence(t *testing.T) {
+	store := storeWithEvents(pb.EventName_USER_CREATED, pb.EventName_USER_CREATED, pb.EventName_USER_UPDATED)
+	s := subscribe(t, store, &pb.SubscribeRequest{FromSequence: 2})
+
+	assert.Equal(t, []int64{3}, s.receive(t, 1))
+
+	s.publish("user-b", pb.EventName_USER_UPDATED)
+	assert.Equal(t, []int64{4}, s.receive(t, 1))
+	s.assertNoMore(t)
+}
+
+func TestSubscribeFiltersEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *pb.SubscribeRequest
+		want []int64
+	}{
+		{
+			name: "by user",
+			req:  &pb.SubscribeRequest{UserId: "user-a"},
+			want: []int64{1, 3, 5},
+		},
+		{
+			name: "by event name",
+			req:  &pb.SubscribeRequest{EventNames: []pb.EventName{pb.EventName_USER_UPDATED}},
+			want: []int64{3, 6},
+		},
+		{
+			name: "by user and event name",
+			req:  &pb.SubscribeRequest{UserId: "user-b", EventNames: []pb.EventName{pb.EventName_USER_CREATED, pb.EventName_USER_DELETED}},
+			want: []int64{2, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithEvents(pb.EventName_USER_CREATED, pb.EventName_USER_CREATED, pb.EventName_USER_UPDATED)
+			s := subscribe(t, store, tt.req)
+
+			var replayed int
+			for _, seq := range tt.want {
+				if seq <= 3 {
+					replayed++
+				}
+			}
+			got := s.receive(t, replayed)
+
+			s.publish("user-b", pb.EventName_USER_DELETED)
+			s.publish("user-a", pb.EventName_USER_DELETED)
+			s.publish("user-b", pb.EventName_USER_UPDATED)
+
+			got = append(got, s.receive(t, len(tt.want)-replayed)...)
+			assert.Equal(t, tt.want, got)
+			s.assertNoMore(t)
+		})
+	}
+}
+
+func TestSubscribeSendsLateEvents(t *testing.T) {
+	store := storeWithEvents(pb.EventName_USER_CREATED)
+	s := subscribe(t, store, &pb.SubscribeRequest{})
+	require.Equal(t, []int64{1}, s.receive(t, 1))
+
+	// Sequence 2 is allocated first but its event is published after sequence 3
+	late, _ := store.Append(&pb.Events{EventName: pb.EventName_USER_CREATED, InternalId: "user-b"})
+	s.publish("user-a", pb.EventName_USER_UPDATED)
+	s.broker.Publish(late)
+
+	got := s.receive(t, 2)
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	assert.Equal(t, []int64{2, 3}, got)
+	s.assertNoMore(t)
+}
+
+func TestSubscribeReadsEventsMissingFromLiveStream(t *testing.T) {
+	store := storeWithEvents(pb.EventName_USER_CREATED)
+	s := subscribe(t, store, &pb.SubscribeRequest{})
+	require.Equal(t, []int64{1}, s.receive(t, 1))
+
+	s.publish("user-a", pb.EventName_USER_UPDATED)
+	require.Equal(t, []int64{2}, s.receive(t, 1))
+
+	// Sequence 3 is appended by another process, so it is only in the event store
+	_, _ = store.Append(&pb.Events{EventName: pb.EventName_USER_CREATED, InternalId: "user-b"})
+	s.publish("user-a", pb.EventName_USER_DELETED)
+
+	assert.Equal(t, []int64{4, 3}, s.receive(t, 2))
+	s.assertNoMore(t)
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/service"
+	"github.com/cemayan/faceit-technical-test/pkg/common"
+	"github.com/cemayan/faceit-technical-test/pkg/erasure"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// trashUserRepo keeps the deleted users in memory
+type trashUserRepo struct {
+	domain.UserRepository
+	users []domain.User
+}
+
+func (r *trashUserRepo) deleted(nickname string, at time.Time) {
+	r.users = append(r.users, domain.User{
+		Base:     &domain.Base{ID: uuid.New(), Deleted: gorm.DeletedAt{Time: at, Valid: true}},
+		NickName: nickname,
+		Password: "hash",
+	})
+}
+
+func (r *trashUserRepo) GetDeletedUsers(pagination common.Pagination) (*common.Pagination, error) {
+	pagination.Rows = r.users
+	pagination.TotalRows = int64(len(r.users))
+	return &pagination, nil
+}
+
+func (r *trashUserRepo) PurgeDeletedUsers(before time.Time, limit int) ([]string, error) {
+	var ids []string
+	var kept []domain.User
+	for _, user := range r.users {
+		if user.Deleted.Time.Before(before) && len(ids) < limit {
+			ids = append(ids, user.ID.String())
+			continue
+		}
+		kept = append(kept, user)
+	}
+	r.users = kept
+	return ids, nil
+}
+
+func TestTrashPurger_PurgesExpiredUsers(t *testing.T) {
+	userRepo := &trashUserRepo{}
+	userRepo.deleted("old", time.Now().AddDate(0, 0, -31))
+	userRepo.deleted("recent", time.Now().AddDate(0, 0, -1))
+	auditLog := &memoryAuditLog{}
+
+	purger := handler.NewTrashPurger(userRepo, auditLog, 30, time.Hour, log.NewEntry(log.New()))
+	purged, err := purger.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	assert.Len(t, userRepo.users, 1)
+	assert.Equal(t, "recent", userRepo.users[0].NickName)
+
+	assert.Len(t, auditLog.entries, 1)
+	assert.Equal(t, "USER_PURGED", auditLog.entries[0].Action)
+	assert.Equal(t, "retention", auditLog.entries[0].ActorID)
+	assert.NotEmpty(t, auditLog.entries[0].UserID)
+}
+
+func TestTrashService_ListTrash(t *testing.T) {
+	userRepo := &trashUserRepo{}
+	deletedAt := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	userRepo.deleted("nick", deletedAt)
+
+	app := fiber.New()
+	app.Get("/admin/trash", service.NewTrashService(userRepo, log.NewEntry(log.New())).ListTrash)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin/trash", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "hash")
+
+	var result struct {
+		Data struct {
+			Rows []domain.DeletedUserData `json:"rows"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &result))
+	assert.Len(t, result.Data.Rows, 1)
+	assert.Equal(t, "nick", result.Data.Rows[0].NickName)
+	assert.True(t, deletedAt.Equal(result.Data.Rows[0].DeletedAt))
+}
+
+func TestTrashPurger_RemovesHistoryOfPurgedUsers(t *testing.T) {
+	logger := log.NewEntry(log.New())
+	backends := map[string]func(db *gorm.DB) domain.UserRepository{
+		"memory": func(db *gorm.DB) domain.UserRepository {
+			return domain.NewMemoryUserRepo(db, repo.EventStoreScrub(logger), logger)
+		},
+		"sqlite": func(db *gorm.DB) domain.UserRepository {
+			return domain.NewUserRepo(db, repo.EventStoreScrub(logger), logger)
+		},
+	}
+
+	for name, newRepo := range backends {
+		t.Run(name, func(t *testing.T) {
+			db := conformanceDB(t)
+			userRepo := newRepo(db)
+			events := repo.NewEventRepo(db, logger)
+			snapshots := repo.NewSnapshotRepo(db, logger)
+			aggregates := repo.NewUserAggregateRepo(events, snapshots, 0, logger)
+			recorder := handler.NewEventRecorder(events, broker.NewEventBroker(), aggregates, nil, log.New())
+
+			id := createUser(t, userRepo, "alice", "UK").ID.String()
+			assert.NoError(t, recorder.Record(&pb.Events{
+				EventName: pb.EventName_USER_CREATED,
+				Payload:   &pb.Events_CreateUser{CreateUser: &pb.CreateUser{Nickname: "alice", Email: "alice@mail.com", Country: "UK"}},
+			}, id))
+			assert.NoError(t, db.Create(&model.Export{ID: uuid.NewString(), UserID: id, Archive: []byte("alice@mail.com")}).Error)
+			assert.NoError(t, userRepo.DeleteUser(id))
+
+			purger := handler.NewTrashPurger(userRepo, nil, 0, time.Hour, logger)
+			purged, err := purger.Purge()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, purged)
+
+			users := domain.NewUserService(userRepo, nil, logger)
+			userSvc := service.NewGrpcUserService(users, nil, aggregates, nil, nil, nil, logger, &user.AppConfig{})
+			app := fiber.New()
+			app.Get("/:id", userSvc.GetUser)
+			app.Get("/:id/history", userSvc.GetUserHistory)
+
+			for _, path := range []string{"/" + id + "/history", "/" + id + "?asOf=" + strconv.FormatInt(time.Now().Unix(), 10)} {
+				resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+				assert.NoError(t, err)
+				assert.Equal(t, 404, resp.StatusCode, path)
+			}
+
+			var remaining int64
+			assert.NoError(t, db.Model(&model.Event{}).Where("internal_id = ?", id).Count(&remaining).Error)
+			assert.Zero(t, remaining)
+			for _, record := range []interface{}{&model.Snapshot{}, &model.Export{}} {
+				assert.NoError(t, db.Model(record).Where("user_id = ?", id).Count(&remaining).Error)
+				assert.Zero(t, remaining)
+			}
+
+			var receipt erasure.Receipt
+			assert.NoError(t, db.Where("user_id = ?", id).First(&receipt).Error)
+			assert.Equal(t, int64(1), receipt.EventsScrubbed)
+		})
+	}
+}
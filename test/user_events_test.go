@@ -0,0 +1,92 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/user/service"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/broker"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newUserApp(t *testing.T, store *memoryEventStore) *fiber.App {
+	recorder := handler.NewEventRecorder(store, broker.NewEventBroker(), nil, nil, log.New())
+	userSvc := service.NewUserService(newDomainService(t), nil, recorder, log.New().WithFields(log.Fields{"service": "test"}), &user.AppConfig{})
+
+	app := fiber.New()
+	app.Post("/user", userSvc.CreateUser)
+	app.Put("/user/:id", userSvc.UpdateUser)
+	app.Delete("/user/:id", userSvc.DeleteUser)
+	app.Post("/user/:id/erase", userSvc.EraseUser)
+	return app
+}
+
+func sendJSON(t *testing.T, app *fiber.App, method string, path string, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(audit.ActorHeader, "admin")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestUserService_RecordsEvents(t *testing.T) {
+	store := &memoryEventStore{}
+	app := newUserApp(t, store)
+
+	req := httptest.NewRequest("POST", "/user", strings.NewReader(`{"nickname":"alice","email":"alice@mail.com","password":"s3cret-pass","country":"UK"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	id := created.Data.ID
+
+	assert.Equal(t, 200, sendJSON(t, app, "PUT", "/user/"+id, `{"email":"new@mail.com","password":"n3w-pass"}`))
+	assert.Equal(t, 200, sendJSON(t, app, "PUT", "/user/"+id, `{"country":"DE"}`))
+	assert.Equal(t, 200, sendJSON(t, app, "DELETE", "/user/"+id, ``))
+
+	var names []pb.EventName
+	for _, event := range store.events {
+		names = append(names, event.EventName)
+		assert.Equal(t, id, event.InternalId)
+		assert.Equal(t, pb.AggregateType_USER, event.AggregateType)
+		if event.EventName != pb.EventName_USER_CREATED {
+			assert.Equal(t, "admin", event.Actor)
+		}
+		assert.NotContains(t, event.String(), "s3cret-pass")
+		assert.NotContains(t, event.String(), "n3w-pass")
+	}
+	assert.Equal(t, []pb.EventName{
+		pb.EventName_USER_CREATED,
+		pb.EventName_USER_UPDATED,
+		pb.EventName_USER_EMAIL_CHANGED,
+		pb.EventName_USER_PASSWORD_CHANGED,
+		pb.EventName_USER_UPDATED,
+		pb.EventName_USER_DELETED,
+	}, names)
+	assert.Equal(t, []string{"country"}, store.events[4].GetUpdateUser().GetUpdateMask().GetPaths())
+}
+
+func TestUserService_ReportsUnrecordedEvents(t *testing.T) {
+	recorder := handler.NewEventRecorder(&failingEventStore{}, broker.NewEventBroker(), nil, nil, log.New())
+	userSvc := service.NewUserService(newDomainService(t), nil, recorder, log.New().WithFields(log.Fields{"service": "test"}), &user.AppConfig{})
+	app := fiber.New()
+	app.Post("/user", userSvc.CreateUser)
+
+	assert.Equal(t, 500, sendJSON(t, app, "POST", "/user", `{"nickname":"alice","email":"alice@mail.com","password":"secret"}`))
+}
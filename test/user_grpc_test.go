@@ -0,0 +1,246 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/handler"
+	"github.com/cemayan/faceit-technical-test/pkg/apperror"
+	pb "github.com/cemayan/faceit-technical-test/protos/event"
+	pbuser "github.com/cemayan/faceit-technical-test/protos/user"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+)
+
+// startUserServer serves the unary user API of given service over bufconn
+func startUserServer(t *testing.T, users domain.UserService) pbuser.UserServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pbuser.RegisterUserServiceServer(s, handler.NewUserGrpcServer(users, &capturingRecorder{}, log.New()))
+	go func() { _ = s.Serve(lis) }()
+
+	conn := dialBufconn(t, func() *bufconn.Listener { return lis })
+	t.Cleanup(func() {
+		_ = conn.Close()
+		s.Stop()
+	})
+	return pbuser.NewUserServiceClient(conn)
+}
+
+// seedUsers creates nick1..nickN, odd ones live in UK and even ones in DE
+func seedUsers(t *testing.T, users domain.UserService, n int) {
+	for i := 1; i <= n; i++ {
+		country := "UK"
+		if i%2 == 0 {
+			country = "DE"
+		}
+		_, err := users.CreateUser(&domain.User{
+			NickName: fmt.Sprintf("nick%d", i),
+			Email:    fmt.Sprintf("nick%d@mail.com", i),
+			Password: "secret",
+			Country:  country,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func nicknames(users []*pb.User) []string {
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Nickname)
+	}
+	return names
+}
+
+func TestUserGrpcServer_ListUsersPages(t *testing.T) {
+	users := newDomainService(t)
+	seedUsers(t, users, 5)
+	client := startUserServer(t, users)
+
+	var pages [][]string
+	token := ""
+	for {
+		resp, err := client.ListUsers(context.Background(), &pbuser.ListUsersRequest{
+			PageSize:  2,
+			PageToken: token,
+			SortField: pbuser.SortField_SORT_FIELD_NICKNAME,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), resp.TotalSize)
+		pages = append(pages, nicknames(resp.Users))
+
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+		require.Less(t, len(pages), 5, "next_page_token is never empty")
+	}
+
+	assert.Equal(t, [][]string{{"nick1", "nick2"}, {"nick3", "nick4"}, {"nick5"}}, pages)
+
+	resp, err := client.ListUsers(context.Background(), &pbuser.ListUsersRequest{
+		PageSize:   2,
+		SortField:  pbuser.SortField_SORT_FIELD_NICKNAME,
+		Descending: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nick5", "nick4"}, nicknames(resp.Users))
+}
+
+func TestUserGrpcServer_ListUsersFilters(t *testing.T) {
+	users := newDomainService(t)
+	seedUsers(t, users, 5)
+	client := startUserServer(t, users)
+
+	tests := []struct {
+		name   string
+		filter *pbuser.UserFilter
+		want   []string
+	}{
+		{name: "country", filter: &pbuser.UserFilter{Country: "DE"}, want: []string{"nick2", "nick4"}},
+		{name: "nickname", filter: &pbuser.UserFilter{Nickname: "nick3"}, want: []string{"nick3"}},
+		{name: "email", filter: &pbuser.UserFilter{Email: "nick5@mail.com"}, want: []string{"nick5"}},
+		{name: "country and nickname", filter: &pbuser.UserFilter{Country: "UK", Nickname: "nick2"}},
+		{name: "none", want: []string{"nick1", "nick2", "nick3", "nick4", "nick5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.ListUsers(context.Background(), &pbuser.ListUsersRequest{
+				Filter:    tt.filter,
+				SortField: pbuser.SortField_SORT_FIELD_NICKNAME,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, nicknames(resp.Users))
+			assert.Equal(t, int64(len(tt.want)), resp.TotalSize)
+			assert.Empty(t, resp.NextPageToken)
+		})
+	}
+}
+
+func TestUserGrpcServer_ListUsersRejectsInvalidRequests(t *testing.T) {
+	client := startUserServer(t, newDomainService(t))
+
+	tests := []struct {
+		name  string
+		req   *pbuser.ListUsersRequest
+		field string
+	}{
+		{name: "malformed page token", req: &pbuser.ListUsersRequest{PageToken: "%%%"}, field: "page_token"},
+		{name: "page token of page zero", req: &pbuser.ListUsersRequest{PageToken: "MA"}, field: "page_token"},
+		{name: "negative page size", req: &pbuser.ListUsersRequest{PageSize: -1}, field: "page_size"},
+		{name: "unknown sort field", req: &pbuser.ListUsersRequest{SortField: 42}, field: "sort_field"},
+		{name: "encrypted sort field", req: &pbuser.ListUsersRequest{SortField: pbuser.SortField_SORT_FIELD_EMAIL}, field: "sort_field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.ListUsers(context.Background(), tt.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+			appErr := apperror.Wrap(err)
+			assert.Equal(t, apperror.ReasonInvalidArgument, appErr.Reason)
+			require.Len(t, appErr.Violations, 1)
+			assert.Equal(t, tt.field, appErr.Violations[0].Field)
+		})
+	}
+}
+
+func TestUserGrpcServer_MapsErrorsToStatus(t *testing.T) {
+	users := newDomainService(t)
+	seedUsers(t, users, 1)
+	client := startUserServer(t, users)
+	ctx := context.Background()
+
+	created, err := client.CreateUser(ctx, &pb.CreateUser{Nickname: "alice", Email: "alice@mail.com", Password: "secret"})
+	require.NoError(t, err)
+	_, err = client.SuspendUser(ctx, &pb.SuspendUser{Id: created.Id, Reason: "spam"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		call   func() error
+		code   codes.Code
+		reason string
+	}{
+		{
+			name: "unknown user",
+			call: func() error {
+				_, err := client.GetUser(ctx, &pbuser.GetUserRequest{Id: uuid.New().String()})
+				return err
+			},
+			code:   codes.NotFound,
+			reason: apperror.ReasonUserNotFound,
+		},
+		{
+			name: "invalid id",
+			call: func() error {
+				_, err := client.GetUser(ctx, &pbuser.GetUserRequest{Id: "nope"})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: apperror.ReasonInvalidArgument,
+		},
+		{
+			name: "taken nickname",
+			call: func() error {
+				_, err := client.CreateUser(ctx, &pb.CreateUser{Nickname: "nick1", Email: "other@mail.com", Password: "secret"})
+				return err
+			},
+			code:   codes.AlreadyExists,
+			reason: apperror.ReasonNicknameTaken,
+		},
+		{
+			name: "validation",
+			call: func() error {
+				_, err := client.CreateUser(ctx, &pb.CreateUser{Nickname: "bob", Email: "not-an-email", Password: "secret"})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: apperror.ReasonValidationFailed,
+		},
+		{
+			name: "missing update mask",
+			call: func() error {
+				_, err := client.UpdateUser(ctx, &pb.UpdateUser{Id: created.Id})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: apperror.ReasonInvalidArgument,
+		},
+		{
+			name: "suspended user",
+			call: func() error {
+				_, err := client.UpdateUser(ctx, &pb.UpdateUser{Id: created.Id, Country: "DE", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}}})
+				return err
+			},
+			code:   codes.FailedPrecondition,
+			reason: apperror.ReasonUserSuspended,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.reason, reasonOf(err))
+		})
+	}
+}
+
+func TestUserGrpcServer_HidesInternalErrors(t *testing.T) {
+	client := startUserServer(t, userService(failingUserRepo{err: errors.New("connection refused")}))
+
+	_, err := client.DeleteUser(context.Background(), &pb.DeleteUser{Id: uuid.New().String()})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, apperror.ReasonInternal, reasonOf(err))
+	assert.NotContains(t, err.Error(), "connection refused")
+}
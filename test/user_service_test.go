@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 	"github.com/cemayan/faceit-technical-test/internal/user/model"
-	"github.com/cemayan/faceit-technical-test/internal/user/repo"
 	"github.com/cemayan/faceit-technical-test/internal/user/router"
 	"github.com/cemayan/faceit-technical-test/internal/user/service"
 	"github.com/cemayan/faceit-technical-test/internal/user/util"
-	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
+	"github.com/cemayan/faceit-technical-test/pkg/audit"
 	"github.com/cemayan/faceit-technical-test/pkg/postgres"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -58,18 +58,20 @@ func (ts *e2eTestSuite) SetupSuite() {
 		return
 	}
 
-	router.SetupRoutes(ts.app, log.New().WithFields(log.Fields{"service": "user"}), appConfig)
+	router.SetupRoutes(ts.app, log.New().WithFields(log.Fields{"service": "user"}), nil, appConfig)
 
 	//Postresql connection
 	ts.dbHandler = postgres.NewDBHandler(&ts.configs.Postgresql, log.New().WithFields(log.Fields{"service": "user"}))
-	db := ts.dbHandler.New()
+	db, err := ts.dbHandler.New()
+	ts.Require().NoError(err)
 	ts.db = db
 
 	util.MigrateDB(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
 
-	userRepo := repo.NewUserRepo(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
+	userRepo := domain.NewUserRepo(ts.db, nil, log.New().WithFields(log.Fields{"service": "user"}))
+	users := domain.NewUserService(userRepo, ts.validate, log.New().WithFields(log.Fields{"service": "user"}))
 
-	userSvc := service.NewUserService(userRepo, ts.validate, log.New().WithFields(log.Fields{"service": "user"}), ts.configs)
+	userSvc := service.NewUserService(users, audit.NewRepository(ts.db, log.New().WithFields(log.Fields{"service": "user"})), nil, log.New().WithFields(log.Fields{"service": "user"}), ts.configs)
 	ts.usrSvc = userSvc
 
 }
@@ -78,14 +80,14 @@ func (ts *e2eTestSuite) removeAllRecords() {
 	ts.db.Exec("DELETE FROM users")
 }
 
-func (ts *e2eTestSuite) getRecords() []model.User {
-	var users []model.User
+func (ts *e2eTestSuite) getRecords() []domain.User {
+	var users []domain.User
 	ts.db.Find(&users)
 	return users
 }
 
-func (ts *e2eTestSuite) getUserModel() model.User {
-	var userModel model.User
+func (ts *e2eTestSuite) getUserModel() domain.User {
+	var userModel domain.User
 	userModel.Password = "123"
 	userModel.NickName = "test"
 	userModel.Email = "user@test.com"
@@ -93,21 +95,21 @@ func (ts *e2eTestSuite) getUserModel() model.User {
 	return userModel
 }
 
-func (ts *e2eTestSuite) getWrongUserModel() model.User {
-	var userModel model.User
+func (ts *e2eTestSuite) getWrongUserModel() domain.User {
+	var userModel domain.User
 	userModel.Country = "UK"
 	return userModel
 }
 
-func (ts *e2eTestSuite) getUpdateUserModel() dto.UpdateUser {
-	var userModel dto.UpdateUser
+func (ts *e2eTestSuite) getUpdateUserModel() domain.UpdateUser {
+	var userModel domain.UpdateUser
 	userModel.NickName = "test4"
 	userModel.Email = "user@test.com"
 	return userModel
 }
 
-func (ts *e2eTestSuite) saveUserModel() model.User {
-	var userModel model.User
+func (ts *e2eTestSuite) saveUserModel() domain.User {
+	var userModel domain.User
 
 	password, _ := ts.usrSvc.HashPassword("123")
 	userModel.Password = password
@@ -452,7 +454,7 @@ func (ts *e2eTestSuite) TestUserService_GetUser() {
 		return
 	}
 
-	var userM model.User
+	var userM domain.User
 	str, err := json.Marshal(response.Data)
 	if err != nil {
 		return
@@ -491,7 +493,7 @@ func (ts *e2eTestSuite) TestUserService_GetUserWrongId() {
 		return
 	}
 
-	var userModel model.User
+	var userModel domain.User
 	str, err := json.Marshal(response.Data)
 	if err != nil {
 		return
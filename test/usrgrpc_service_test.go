@@ -5,8 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/cemayan/faceit-technical-test/config/user"
+	"github.com/cemayan/faceit-technical-test/internal/domain"
 
-	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/dto"
+	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/eventclient"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/model"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/repo"
 	"github.com/cemayan/faceit-technical-test/internal/usrgrpc/router"
@@ -22,6 +23,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"gorm.io/gorm"
 	"io"
 	"net"
@@ -66,10 +68,14 @@ func (ts *e2eGrpcTestSuite) SetupSuite() {
 
 	//Postresql connection
 	ts.dbHandler = postgres.NewDBHandler(&ts.configs.Postgresql, log.New().WithFields(log.Fields{"service": "user_grpc"}))
-	db := ts.dbHandler.New()
+	db, err := ts.dbHandler.New()
+	ts.Require().NoError(err)
 	ts.db = db
 
-	userRepo := repo.NewGrpcUserRepo(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
+	userRepo := domain.NewUserRepo(ts.db, repo.EventStoreScrub(log.New().WithFields(log.Fields{"service": "user"})), log.New().WithFields(log.Fields{"service": "user"}))
+	eventRepo := repo.NewEventRepo(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
+	snapshotRepo := repo.NewSnapshotRepo(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
+	aggregateRepo := repo.NewUserAggregateRepo(eventRepo, snapshotRepo, ts.configs.Grpc.SNAPSHOT_INTERVAL, log.New().WithFields(log.Fields{"service": "user"}))
 
 	util.MigrateDB(ts.db, log.New().WithFields(log.Fields{"service": "user"}))
 
@@ -79,15 +85,19 @@ func (ts *e2eGrpcTestSuite) SetupSuite() {
 	util.FailOnError(err, "did not connect")
 
 	grpcClient := pb.NewEventGrpcServiceClient(_grpcConn)
+	streams := eventclient.NewStreamPool(grpcClient, ts.configs.Grpc.STREAMS, log.New().WithFields(log.Fields{"service": "user_grpc"}))
+	breaker := eventclient.NewBreaker(ts.configs.Grpc.BREAKER_FAILURES, ts.configs.Grpc.BREAKER_COOLDOWN)
+	events := eventclient.NewEventClient(streams, breaker, ts.configs.Grpc.RETRIES, log.New().WithFields(log.Fields{"service": "user_grpc"}))
+	healthChecker := eventclient.NewHealthChecker(healthpb.NewHealthClient(_grpcConn))
 
-	userSvc := service.NewGrpcUserService(userRepo, ts.validate, grpcClient, log.New().WithFields(log.Fields{"service": "user_grpc"}), ts.configs)
+	userSvc := service.NewGrpcUserService(domain.NewUserService(userRepo, ts.validate, log.New().WithFields(log.Fields{"service": "user_grpc"})), nil, aggregateRepo, ts.validate, events, healthChecker, log.New().WithFields(log.Fields{"service": "user_grpc"}), ts.configs)
 	ts.usrSvc = userSvc
 
 	log.Infoln("gRPC server is starting...")
 	_, err = net.Listen("tcp", fmt.Sprintf(":%s", ts.configs.Grpc.PORT))
 	util.FailOnError(err, "tcp listen failed.")
 
-	router.SetupGrpcRoutes(ts.app, log.New().WithFields(log.Fields{"service": "user"}), grpcClient, appConfig)
+	router.SetupGrpcRoutes(ts.app, log.New().WithFields(log.Fields{"service": "user"}), events, healthChecker, nil, appConfig)
 
 }
 
@@ -95,14 +105,14 @@ func (ts *e2eGrpcTestSuite) removeAllRecords() {
 	ts.db.Exec("DELETE FROM users")
 }
 
-func (ts *e2eGrpcTestSuite) getRecords() []model.User {
-	var users []model.User
+func (ts *e2eGrpcTestSuite) getRecords() []domain.User {
+	var users []domain.User
 	ts.db.Find(&users)
 	return users
 }
 
-func (ts *e2eGrpcTestSuite) getUserModel() model.User {
-	var userModel model.User
+func (ts *e2eGrpcTestSuite) getUserModel() domain.User {
+	var userModel domain.User
 	userModel.Password = "123"
 	userModel.NickName = "test"
 	userModel.Email = "user@test.com"
@@ -110,21 +120,21 @@ func (ts *e2eGrpcTestSuite) getUserModel() model.User {
 	return userModel
 }
 
-func (ts *e2eGrpcTestSuite) getWrongUserModel() model.User {
-	var userModel model.User
+func (ts *e2eGrpcTestSuite) getWrongUserModel() domain.User {
+	var userModel domain.User
 	userModel.Country = "UK"
 	return userModel
 }
 
-func (ts *e2eGrpcTestSuite) getUpdateUserModel() dto.UpdateUser {
-	var userModel dto.UpdateUser
+func (ts *e2eGrpcTestSuite) getUpdateUserModel() domain.UpdateUser {
+	var userModel domain.UpdateUser
 	userModel.NickName = "test4"
 	userModel.Email = "user@test.com"
 	return userModel
 }
 
-func (ts *e2eGrpcTestSuite) saveUserModel() model.User {
-	var userModel model.User
+func (ts *e2eGrpcTestSuite) saveUserModel() domain.User {
+	var userModel domain.User
 
 	password, _ := ts.usrSvc.HashPassword("123")
 	userModel.Password = password
@@ -210,7 +220,7 @@ func (ts *e2eGrpcTestSuite) TestUserService_CreateWrongPayload2() {
 		return
 	}
 
-	ts.Equal(fiber.StatusBadRequest, resp.StatusCode)
+	ts.Equal(fiber.StatusUnprocessableEntity, resp.StatusCode)
 }
 
 func (ts *e2eGrpcTestSuite) TestUserService_CheckStatusCodeWhenCreate() {
@@ -259,7 +269,7 @@ func (ts *e2eGrpcTestSuite) TestUserService_SameEmail() {
 		return
 	}
 
-	ts.Equal(fiber.StatusBadRequest, resp.StatusCode)
+	ts.Equal(fiber.StatusConflict, resp.StatusCode)
 }
 
 func (ts *e2eGrpcTestSuite) TestUserService_SameNickname() {
@@ -284,7 +294,7 @@ func (ts *e2eGrpcTestSuite) TestUserService_SameNickname() {
 		return
 	}
 
-	ts.Equal(fiber.StatusBadRequest, resp.StatusCode)
+	ts.Equal(fiber.StatusConflict, resp.StatusCode)
 }
 
 func (ts *e2eGrpcTestSuite) TestUserService_UpdateUser() {
@@ -470,7 +480,7 @@ func (ts *e2eGrpcTestSuite) TestUserService_GetUser() {
 		return
 	}
 
-	var userM model.User
+	var userM domain.User
 	str, err := json.Marshal(response.Data)
 	if err != nil {
 		return
@@ -509,7 +519,7 @@ func (ts *e2eGrpcTestSuite) TestUserService_GetUserWrongId() {
 		return
 	}
 
-	var userModel model.User
+	var userModel domain.User
 	str, err := json.Marshal(response.Data)
 	if err != nil {
 		return